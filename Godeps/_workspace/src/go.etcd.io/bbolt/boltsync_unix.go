@@ -1,8 +1,6 @@
 // +build !windows,!plan9,!linux,!openbsd
 
-package bolt
-
-var odirect int
+package bbolt
 
 // fdatasync flushes written data to a file descriptor.
 func fdatasync(db *DB) error {
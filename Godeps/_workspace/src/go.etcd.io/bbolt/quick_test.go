@@ -1,4 +1,4 @@
-package bolt_test
+package bbolt_test
 
 import (
 	"bytes"
@@ -7,6 +7,7 @@ import (
 	"math/rand"
 	"os"
 	"reflect"
+	"testing"
 	"testing/quick"
 	"time"
 )
@@ -23,7 +24,7 @@ import (
 
 var qcount, qseed, qmaxitems, qmaxksize, qmaxvsize int
 
-func init() {
+func TestMain(m *testing.M) {
 	flag.IntVar(&qcount, "quick.count", 5, "")
 	flag.IntVar(&qseed, "quick.seed", int(time.Now().UnixNano())%100000, "")
 	flag.IntVar(&qmaxitems, "quick.maxitems", 1000, "")
@@ -32,6 +33,8 @@ func init() {
 	flag.Parse()
 	fmt.Fprintln(os.Stderr, "seed:", qseed)
 	fmt.Fprintf(os.Stderr, "quick settings: count=%v, items=%v, ksize=%v, vsize=%v\n", qcount, qmaxitems, qmaxksize, qmaxvsize)
+
+	m.Run()
 }
 
 func qconfig() *quick.Config {
@@ -50,9 +53,17 @@ func (t testdata) Less(i, j int) bool { return bytes.Compare(t[i].Key, t[j].Key)
 func (t testdata) Generate(rand *rand.Rand, size int) reflect.Value {
 	n := rand.Intn(qmaxitems-1) + 1
 	items := make(testdata, n)
+	used := make(map[string]bool)
 	for i := 0; i < n; i++ {
 		item := &items[i]
-		item.Key = randByteSlice(rand, 1, qmaxksize)
+		// Ensure that keys are unique by looping until we find one that we have not already used.
+		for {
+			item.Key = randByteSlice(rand, 1, qmaxksize)
+			if !used[string(item.Key)] {
+				used[string(item.Key)] = true
+				break
+			}
+		}
 		item.Value = randByteSlice(rand, 0, qmaxvsize)
 	}
 	return reflect.ValueOf(items)
@@ -0,0 +1,48 @@
+// astore-fsck checks a store for damaged keys and tx logs and optionally repairs them.
+//
+// astore-fsck can run while astored is running if astored was started with -lock-files; stop it
+// first otherwise. The tx logs are only repaired while astored is stopped.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/skyec/astore"
+)
+
+func main() {
+	var (
+		storeDir string
+		repair   bool
+	)
+
+	flag.StringVar(&storeDir, "s", "/var/astore", "Directory that contains the store data")
+	flag.BoolVar(&repair, "repair", false, "Truncate torn tails and rebuild hash logs from content")
+	flag.Parse()
+
+	if _, err := os.Stat(storeDir); err != nil {
+		log.Fatalln("Error opening the store:", err)
+	}
+
+	report, err := astore.Fsck(storeDir, &astore.FsckOptions{Repair: repair})
+	if report != nil {
+		for _, p := range report.Problems {
+			fmt.Println(p)
+		}
+		fmt.Printf("Checked %d keys (%d blocks) and %d tx logs (%d blocks)\n",
+			report.Keys, report.Blocks, report.TxLogs, report.TxLogBlocks)
+		fmt.Printf("Problems: %d, unrepaired: %d\n", len(report.Problems), report.Unrepaired())
+		if report.InUse && repair {
+			fmt.Println("The store is in use: the tx logs weren't repaired. Stop astored to repair them.")
+		}
+	}
+	if err != nil {
+		log.Fatalln("Error checking the store:", err)
+	}
+	if report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
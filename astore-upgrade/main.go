@@ -0,0 +1,38 @@
+// astore-upgrade rewrites a store in the current on-disk format.
+//
+// The store must not be in use while astore-upgrade runs. Stop astored first. The original files
+// are kept in a backup directory inside the store; remove it once the upgraded store has been
+// checked. An upgrade that was interrupted is finished by running astore-upgrade again.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/skyec/astore"
+)
+
+func main() {
+	var storeDir string
+
+	flag.StringVar(&storeDir, "s", "/var/astore", "Directory that contains the store data")
+	flag.Parse()
+
+	if _, err := os.Stat(storeDir); err != nil {
+		log.Fatalln("Error opening the store:", err)
+	}
+
+	report, err := astore.Upgrade(storeDir, nil)
+	if err != nil {
+		log.Fatalln("Error upgrading the store:", err)
+	}
+	if report.From == report.To {
+		fmt.Printf("Store is already format %d\n", report.To)
+		return
+	}
+	fmt.Printf("Upgraded from format %d to %d: %d keys rewritten, %d already current, %d tx log blocks applied\n",
+		report.From, report.To, report.Keys, report.Current, report.TxLogBlocks)
+	fmt.Println("The original files are in:", report.Backup)
+}
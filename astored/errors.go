@@ -24,6 +24,18 @@ const (
 	ErrorNotFound
 	ErrorMissingKey
 	ErrorStoreError
+	ErrorContentTooLarge
+	ErrorInvalidParameter
+	ErrorInvalidCursor
+	ErrorInvalidKey
+	ErrorInvalidAttributes
+	ErrorQuotaExceeded
+	ErrorKeyDeleted
+	ErrorKeySealed
+	ErrorInvalidRetraction
+	ErrorInvalidIdempotencyKey
+	ErrorCorruptBlock
+	ErrorTooManyKeys
 )
 
 func init() {
@@ -71,6 +83,91 @@ func init() {
 			ErrorStoreError,
 			"Error interacting with the store",
 		},
+
+		// ErrorContentTooLarge: the body is larger than the store allows for a single append
+		ErrorContentTooLarge: &ErrorResponse{
+			http.StatusRequestEntityTooLarge,
+			ErrorContentTooLarge,
+			"Request body is too large",
+		},
+
+		// ErrorInvalidParameter: a query parameter has an invalid value
+		ErrorInvalidParameter: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorInvalidParameter,
+			"Invalid query parameter. offset and limit must be non-negative integers and order must be asc or desc",
+		},
+
+		// ErrorInvalidCursor: the cursor passed in 'after' doesn't belong to the key
+		ErrorInvalidCursor: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorInvalidCursor,
+			"Invalid cursor in 'after'",
+		},
+
+		// ErrorInvalidKey: keys ending in '/' name a prefix and keys ending in a reserved suffix
+		// can't be read back, so neither can be appended to
+		ErrorInvalidKey: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorInvalidKey,
+			"Invalid key. Keys can't end with '/' or with /seal, /retract, /stream, /meta or /attributes",
+		},
+
+		// ErrorInvalidAttributes: the attributes in the body aren't a JSON object of strings
+		ErrorInvalidAttributes: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorInvalidAttributes,
+			"Attributes must be a JSON object with string values",
+		},
+
+		// ErrorQuotaExceeded: the key is over the limits of its retention policy
+		ErrorQuotaExceeded: &ErrorResponse{
+			http.StatusInsufficientStorage,
+			ErrorQuotaExceeded,
+			"The key is over its retention quota",
+		},
+
+		// ErrorKeyDeleted: the key has been deleted and can't be appended to until it's reclaimed
+		ErrorKeyDeleted: &ErrorResponse{
+			http.StatusGone,
+			ErrorKeyDeleted,
+			"The key has been deleted",
+		},
+
+		// ErrorKeySealed: the key has been sealed and can't be appended to
+		ErrorKeySealed: &ErrorResponse{
+			http.StatusConflict,
+			ErrorKeySealed,
+			"The key is sealed",
+		},
+
+		// ErrorInvalidRetraction: the body of a retraction doesn't have the hash of a record
+		ErrorInvalidRetraction: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorInvalidRetraction,
+			`Retractions must be a JSON object with the SHA1 of the record: {"hash":"<SHA1>"}`,
+		},
+
+		// ErrorInvalidIdempotencyKey: the Idempotency-Key header is too long
+		ErrorInvalidIdempotencyKey: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorInvalidIdempotencyKey,
+			"Idempotency-Key can't be longer than 255 bytes",
+		},
+
+		// ErrorCorruptBlock: a record in the key failed validation and can't be read
+		ErrorCorruptBlock: &ErrorResponse{
+			http.StatusInternalServerError,
+			ErrorCorruptBlock,
+			"The key has a corrupt record. Run astore-fsck",
+		},
+
+		// ErrorTooManyKeys: a prefix read would have to merge more keys than -max-prefix-keys
+		ErrorTooManyKeys: &ErrorResponse{
+			http.StatusBadRequest,
+			ErrorTooManyKeys,
+			"The prefix has too many keys to read. Read a longer prefix",
+		},
 	}
 }
 
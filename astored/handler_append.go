@@ -1,8 +1,6 @@
 package main
 
 import (
-	"fmt"
-	"io/ioutil"
 	"log"
 	"mime"
 	"net/http"
@@ -10,6 +8,18 @@ import (
 	"github.com/skyec/astore"
 )
 
+// maxIdempotencyKeySize is the longest Idempotency-Key header an append accepts.
+const maxIdempotencyKeySize = 255
+
+// AppendResponse is the body of a successful append. Duplicate is left out when the store can't
+// tell yet, like in tx log mode where the record is deduped when it's committed; Pending is set
+// instead.
+type AppendResponse struct {
+	Status    string `json:"status"`
+	Duplicate *bool  `json:"duplicate,omitempty"`
+	Pending   bool   `json:"pending,omitempty"`
+}
+
 type AppendHandler struct {
 	store astore.WriteableKey
 	vars  RequestVars
@@ -41,31 +51,54 @@ func (h *AppendHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "error handling for empty key value not implemented", http.StatusNotImplemented)
 		return
 	}
-
-	// TODO: protect this call from large senders by reading request into
-	//       a fixed buffer set to the max file size allowed in the store
-	//       OR see the reader passthrough option below
-	buf, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		logRequest(r, http.StatusInternalServerError)
-		http.Error(w, fmt.Sprintf("error reading body: %s", err), http.StatusInternalServerError)
+	if !validKey(key) {
+		writeErrorResponse(w, r, ErrorInvalidKey)
 		return
 	}
 
-	if len(buf) == 0 {
+	if r.ContentLength == 0 {
 		writeErrorResponse(w, r, ErrorEmptyBody)
 		return
 	}
 
-	// TODO: add a reader interface to the store to avoid a buffer copy here
-	err = h.store.WriteToKey(key, buf)
-	if err != nil {
-		log.Println("TODO not implemented: ", err)
-		logRequest(r, http.StatusNotImplemented)
-		http.Error(w, "not implemented", http.StatusNotImplemented)
+	// Keys with the id dedupe mode drop appends with an Idempotency-Key they've already seen
+	opts := &astore.WriteOptions{ID: r.Header.Get("Idempotency-Key")}
+	if len(opts.ID) > maxIdempotencyKeySize {
+		writeErrorResponse(w, r, ErrorInvalidIdempotencyKey)
+		return
+	}
+
+	// Stream the body to the store. ContentLength is -1 if the size isn't known (chunked)
+	status, err := h.store.WriteStreamToKeyWithOptions(key, r.Body, r.ContentLength, opts)
+	switch err {
+	case nil:
+	case astore.ErrEmptyContent:
+		writeErrorResponse(w, r, ErrorEmptyBody)
+		return
+	case astore.ErrContentTooLarge:
+		writeErrorResponse(w, r, ErrorContentTooLarge)
+		return
+	case astore.ErrQuotaExceeded:
+		writeErrorResponse(w, r, ErrorQuotaExceeded)
+		return
+	case astore.ErrKeyDeleted:
+		writeErrorResponse(w, r, ErrorKeyDeleted)
+		return
+	case astore.ErrKeySealed:
+		writeErrorResponse(w, r, ErrorKeySealed)
+		return
+	default:
+		log.Println("ERROR: writing to the store:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
 	}
 
-	//w.WriteHeader(http.StatusOK)
-	//logRequest(r, http.StatusOK)
-	writeOKResponse(w, r, map[string]string{"status": "ok"})
+	resp := &AppendResponse{Status: "ok"}
+	if status == astore.WRITE_PENDING {
+		resp.Pending = true
+	} else {
+		duplicate := status == astore.WRITE_DUPLICATE
+		resp.Duplicate = &duplicate
+	}
+	writeOKResponse(w, r, resp)
 }
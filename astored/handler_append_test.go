@@ -2,9 +2,15 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/skyec/astore"
 )
 
 func TestHandlerAppend(t *testing.T) {
@@ -35,6 +41,53 @@ func TestHandlerAppend(t *testing.T) {
 
 }
 
+func TestHandlerAppendDuplicate(t *testing.T) {
+
+	tests := []struct {
+		status   astore.WriteStatus
+		expected string
+	}{
+		{astore.WRITE_APPENDED, `{"status":"ok","duplicate":false}`},
+		{astore.WRITE_DUPLICATE, `{"status":"ok","duplicate":true}`},
+		{astore.WRITE_PENDING, `{"status":"ok","pending":true}`},
+	}
+	for _, test := range tests {
+		vars := MockRequestVars{}
+		vars["key"] = "asdf"
+		moc := &MockWriteableKey{status: test.status}
+		h := NewAppendHandler(moc, vars)
+
+		r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+		r.Method = "POST"
+		r.Header.Set("Idempotency-Key", "order-42")
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got: %d", test.status, w.Code)
+		}
+		if moc.id != "order-42" {
+			t.Errorf("%s: expected idempotency ID order-42, got: %s", test.status, moc.id)
+		}
+		if body := strings.TrimSpace(w.Body.String()); body != test.expected {
+			t.Errorf("%s: expected: %s, got: %s", test.status, test.expected, body)
+		}
+	}
+}
+
+func TestHandlerAppendInvalidIdempotencyKey(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{}, vars)
+
+	r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	r.Header.Set("Idempotency-Key", strings.Repeat("x", maxIdempotencyKeySize+1))
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorInvalidIdempotencyKey, w)
+}
+
 func TestHandlerAppendMissingContentType(t *testing.T) {
 	h := NewAppendHandler(&MockWriteableKey{}, MockRequestVars{})
 
@@ -59,6 +112,85 @@ func TestHandlerAppendEmptyBody(t *testing.T) {
 	validateErrorResponse(t, ErrorEmptyBody, w)
 }
 
+func TestHandlerAppendEmptyChunkedBody(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{}, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.ContentLength = -1
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorEmptyBody, w)
+}
+
+func TestHandlerAppendTooLarge(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{err: astore.ErrContentTooLarge}, vars)
+
+	r, w := helpNewRequestResponse(bytes.NewBufferString(`{"too":"big"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorContentTooLarge, w)
+}
+
+func TestHandlerAppendQuotaExceeded(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{err: astore.ErrQuotaExceeded}, vars)
+
+	r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorQuotaExceeded, w)
+}
+
+func TestHandlerAppendKeyDeleted(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{err: astore.ErrKeyDeleted}, vars)
+
+	r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorKeyDeleted, w)
+}
+
+func TestHandlerAppendKeySealed(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{err: astore.ErrKeySealed}, vars)
+
+	r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorKeySealed, w)
+}
+
+func TestHandlerAppendStoreError(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "asdf"
+	h := NewAppendHandler(&MockWriteableKey{err: errors.New("boom")}, vars)
+
+	r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+
+	validateErrorResponse(t, ErrorStoreError, w)
+}
+
 func TestHandlerAppendInvalidMethod(t *testing.T) {
 
 	h := NewAppendHandler(&MockWriteableKey{}, MockRequestVars{})
@@ -88,9 +220,11 @@ func validateErrorResponse(t *testing.T, code ErrorResponseCode, w *httptest.Res
 }
 
 type MockWriteableKey struct {
-	key  string
-	data []byte
-	err  error
+	key    string
+	data   []byte
+	id     string
+	status astore.WriteStatus
+	err    error
 }
 
 func (wk *MockWriteableKey) WriteToKey(key string, data []byte) error {
@@ -99,8 +233,56 @@ func (wk *MockWriteableKey) WriteToKey(key string, data []byte) error {
 	return wk.err
 }
 
+func (wk *MockWriteableKey) WriteToKeyWithOptions(key string, data []byte, opts *astore.WriteOptions) (astore.WriteStatus, error) {
+	if opts != nil {
+		wk.id = opts.ID
+	}
+	return wk.status, wk.WriteToKey(key, data)
+}
+
+func (wk *MockWriteableKey) WriteStreamToKeyWithOptions(key string, r io.Reader, size int64, opts *astore.WriteOptions) (astore.WriteStatus, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return wk.status, err
+	}
+	if len(data) == 0 {
+		return wk.status, astore.ErrEmptyContent
+	}
+	return wk.WriteToKeyWithOptions(key, data, opts)
+}
+
+func (wk *MockWriteableKey) WriteStreamToKey(key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return astore.ErrEmptyContent
+	}
+	return wk.WriteToKey(key, data)
+}
+
 type MockRequestVars map[string]string
 
 func (rv MockRequestVars) Vars(r *http.Request) map[string]string {
 	return rv
 }
+
+func TestHandlerAppendPrefixKey(t *testing.T) {
+
+	for _, key := range []string{"tenant/42/", "jobs/stream", "docs/meta", "orders/seal"} {
+		vars := MockRequestVars{}
+		vars["key"] = key
+		moc := &MockWriteableKey{}
+		h := NewAppendHandler(moc, vars)
+
+		r, w := helpNewRequestResponse(bytes.NewBufferString(`{"foo":"bar"}`), &bytes.Buffer{})
+		r.Method = "POST"
+		h.ServeHTTP(w, r)
+
+		validateErrorResponse(t, ErrorInvalidKey, w)
+		if moc.data != nil {
+			t.Errorf("%s: expected nothing to be appended, got: %s", key, moc.data)
+		}
+	}
+}
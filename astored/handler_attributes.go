@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/skyec/astore"
+)
+
+// maxAttributesSize is the largest set of attributes that can be PUT.
+const maxAttributesSize = 64 * 1024
+
+type HandlerAttributes struct {
+	store astore.AttributableKey
+	vars  RequestVars
+}
+
+func NewAttributesHandler(st astore.AttributableKey, rv RequestVars) *HandlerAttributes {
+	return &HandlerAttributes{
+		store: st,
+		vars:  rv,
+	}
+}
+
+// ServeHTTP gets or replaces the attributes of the key. They're a JSON object of strings:
+//
+//	{"owner":"alice","schema":"orders-v2"}
+//
+// A PUT replaces every attribute; PUT {} to remove them.
+func (h *HandlerAttributes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+	if strings.HasSuffix(key, astore.KEY_SEPARATOR) {
+		writeErrorResponse(w, r, ErrorInvalidKey)
+		return
+	}
+
+	if r.Method != "PUT" {
+		attrs, err := h.store.GetKeyAttributes(key)
+		if err != nil {
+			log.Println("ERROR: reading key attributes:", err)
+			writeErrorResponse(w, r, ErrorStoreError)
+			return
+		}
+		writeOKResponse(w, r, attrs)
+		return
+	}
+
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || t != "application/json" {
+		writeErrorResponse(w, r, ErrorInvalidContentType)
+		return
+	}
+	if r.ContentLength > maxAttributesSize {
+		writeErrorResponse(w, r, ErrorContentTooLarge)
+		return
+	}
+	attrs := map[string]string{}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxAttributesSize))
+	if err = dec.Decode(&attrs); err != nil {
+		writeErrorResponse(w, r, ErrorInvalidAttributes)
+		return
+	}
+	if err = h.store.SetKeyAttributes(key, attrs); err != nil {
+		log.Println("ERROR: writing key attributes:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	writeOKResponse(w, r, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+type mockAttributableKey map[string]map[string]string
+
+func (ak mockAttributableKey) GetKeyAttributes(key string) (map[string]string, error) {
+	if attrs, ok := ak[key]; ok {
+		return attrs, nil
+	}
+	return map[string]string{}, nil
+}
+
+func (ak mockAttributableKey) SetKeyAttributes(key string, attrs map[string]string) error {
+	ak[key] = attrs
+	return nil
+}
+
+func TestHandlerAttributes(t *testing.T) {
+
+	moc := mockAttributableKey{}
+	vars := MockRequestVars{}
+	vars["key"] = "k"
+	h := NewAttributesHandler(moc, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "{}" {
+		t.Errorf("expected no attributes, got: %d %s", w.Code, w.Body)
+	}
+
+	r, w = helpNewRequestResponse(bytes.NewBufferString(`{"owner":"alice","schema":"v2"}`), &bytes.Buffer{})
+	r.Method = "PUT"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	expected := map[string]string{"owner": "alice", "schema": "v2"}
+	if !reflect.DeepEqual(moc["k"], expected) {
+		t.Errorf("unexpected attributes. Expected: %v, got: %v", expected, moc["k"])
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	if w.Body.String() != `{"owner":"alice","schema":"v2"}` {
+		t.Errorf("unexpected response: %s", w.Body)
+	}
+
+	for _, body := range []string{`{"owner":1}`, `["owner"]`, `{`} {
+		r, w = helpNewRequestResponse(bytes.NewBufferString(body), &bytes.Buffer{})
+		r.Method = "PUT"
+		h.ServeHTTP(w, r)
+		validateErrorResponse(t, ErrorInvalidAttributes, w)
+	}
+
+	r, w = helpNewRequestResponse(bytes.NewBufferString(`{}`), &bytes.Buffer{})
+	r.Method = "PUT"
+	r.Header.Del("Content-Type")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidContentType, w)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+// defaultChangesLimit is the size of a page of changes if the request doesn't set one.
+const defaultChangesLimit = 1000
+
+type HandlerChanges struct {
+	store astore.ChangeFeed
+}
+
+func NewChangesHandler(st astore.ChangeFeed) *HandlerChanges {
+	return &HandlerChanges{
+		store: st,
+	}
+}
+
+type changeResponse struct {
+	Seq   uint64    `json:"seq"`
+	Key   string    `json:"key"`
+	Index uint64    `json:"index"`
+	Hash  string    `json:"hash"`
+	Ts    time.Time `json:"ts"`
+}
+
+// ServeHTTP writes a page of the store's change feed as a JSON array. ?since=N starts after the
+// change with seq N and ?limit=N sets the size of the page. The Link header points at the next
+// page, which is empty until more records are appended:
+//
+//	Link: </v1/changes?since=<seq>&limit=N>; rel="next"
+func (h *HandlerChanges) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	since, err := parseUintParam(query, "since")
+	if err != nil {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+	limit, err := parseUintParam(query, "limit")
+	if err != nil {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+	if limit == 0 {
+		limit = defaultChangesLimit
+	}
+
+	changes := []*changeResponse{}
+	err = h.store.ReadChangesSince(since, int(limit), func(c *astore.Change) error {
+		changes = append(changes, &changeResponse{
+			Seq:   c.Seq,
+			Key:   c.Key,
+			Index: c.Index,
+			Hash:  c.Hash,
+			Ts:    c.Timestamp.UTC(),
+		})
+		return nil
+	})
+	if err != nil {
+		log.Println("ERROR: reading the change log:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+
+	next := since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].Seq
+	}
+	link := url.Values{}
+	link.Set("since", strconv.FormatUint(next, 10))
+	link.Set("limit", strconv.FormatUint(limit, 10))
+
+	buf, err := json.Marshal(changes)
+	if err != nil {
+		log.Println("ERROR: encoding changes:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, link.Encode()))
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(buf); err != nil {
+		log.Println("ERROR: failed while writing response:", err)
+		return
+	}
+	logRequest(r, http.StatusOK)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+type mockChangeFeed []astore.Change
+
+func (cf mockChangeFeed) ReadChangesSince(since uint64, limit int, f astore.ChangeFunc) error {
+	for i := since; i < uint64(len(cf)) && (limit <= 0 || i < since+uint64(limit)); i++ {
+		if err := f(&cf[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestHandlerChanges(t *testing.T) {
+
+	feed := mockChangeFeed{}
+	for i := uint64(1); i <= 3; i++ {
+		feed = append(feed, astore.Change{
+			Seq:       i,
+			Key:       "KEY",
+			Index:     i - 1,
+			Hash:      "HASH",
+			Timestamp: time.Unix(1433160000, 0),
+		})
+	}
+	h := NewChangesHandler(feed)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/changes?since=1&limit=1")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	expected := `[{"seq":2,"key":"KEY","index":1,"hash":"HASH","ts":"2015-06-01T12:00:00Z"}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+	if link := w.Header().Get("Link"); link != `</v1/changes?limit=1&since=2>; rel="next"` {
+		t.Errorf("invalid Link header: %s", link)
+	}
+
+	// caught up
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/changes?since=3")
+	h.ServeHTTP(w, r)
+	if w.Body.String() != "[]" {
+		t.Errorf("expected no changes, got: %s", w.Body)
+	}
+	if link := w.Header().Get("Link"); link != `</v1/changes?limit=1000&since=3>; rel="next"` {
+		t.Errorf("invalid Link header: %s", link)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/changes?since=x")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidParameter, w)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+type HandlerDeleteKey struct {
+	store astore.DeletableKey
+	vars  RequestVars
+}
+
+func NewDeleteKeyHandler(st astore.DeletableKey, rv RequestVars) *HandlerDeleteKey {
+	return &HandlerDeleteKey{
+		store: st,
+		vars:  rv,
+	}
+}
+
+// ServeHTTP deletes the key. It can be undeleted with a POST to /v1/deleted/{key} until it's
+// reclaimed. Keys without any records get a 404.
+func (h *HandlerDeleteKey) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+	if strings.HasSuffix(key, astore.KEY_SEPARATOR) {
+		writeErrorResponse(w, r, ErrorInvalidKey)
+		return
+	}
+
+	switch err := h.store.DeleteKey(key); err {
+	case nil:
+	case astore.ErrKeyNotFound:
+		writeErrorResponse(w, r, ErrorNotFound)
+		return
+	default:
+		log.Println("ERROR: deleting key:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	writeOKResponse(w, r, map[string]string{"status": "ok"})
+}
+
+type HandlerDeletedKeys struct {
+	store astore.DeletableKey
+	vars  RequestVars
+}
+
+func NewDeletedKeysHandler(st astore.DeletableKey, rv RequestVars) *HandlerDeletedKeys {
+	return &HandlerDeletedKeys{
+		store: st,
+		vars:  rv,
+	}
+}
+
+type deletedKeyResponse struct {
+	Name         string    `json:"name"`
+	Deleted      time.Time `json:"deleted"`
+	ReclaimAfter time.Time `json:"reclaimAfter"`
+}
+
+// ServeHTTP lists the deleted keys that haven't been reclaimed yet:
+//
+//	[{"name":"user-a","deleted":"2015-06-01T12:00:00Z","reclaimAfter":"2015-06-02T12:00:00Z"}]
+//
+// A POST with a key undeletes it. Keys that aren't deleted, or have already been reclaimed, get a
+// 404.
+func (h *HandlerDeletedKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != "POST" {
+		keys, err := h.store.ListDeletedKeys()
+		if err != nil {
+			log.Println("ERROR: listing deleted keys:", err)
+			writeErrorResponse(w, r, ErrorStoreError)
+			return
+		}
+		resp := make([]*deletedKeyResponse, len(keys))
+		for i, k := range keys {
+			resp[i] = &deletedKeyResponse{Name: k.Name, Deleted: k.Deleted, ReclaimAfter: k.ReclaimAfter}
+		}
+		writeOKResponse(w, r, resp)
+		return
+	}
+
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+	switch err := h.store.UndeleteKey(key); err {
+	case nil:
+	case astore.ErrKeyNotFound:
+		writeErrorResponse(w, r, ErrorNotFound)
+		return
+	default:
+		log.Println("ERROR: undeleting key:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	writeOKResponse(w, r, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+// mockDeletableKey holds the keys that exist and the ones that are deleted.
+type mockDeletableKey struct {
+	keys    map[string]bool
+	deleted map[string]bool
+	err     error
+}
+
+func (dk *mockDeletableKey) DeleteKey(key string) error {
+	if dk.err != nil {
+		return dk.err
+	}
+	if !dk.keys[key] {
+		return astore.ErrKeyNotFound
+	}
+	delete(dk.keys, key)
+	dk.deleted[key] = true
+	return nil
+}
+
+func (dk *mockDeletableKey) UndeleteKey(key string) error {
+	if !dk.deleted[key] {
+		return astore.ErrKeyNotFound
+	}
+	delete(dk.deleted, key)
+	dk.keys[key] = true
+	return nil
+}
+
+func (dk *mockDeletableKey) ListDeletedKeys() ([]*astore.DeletedKey, error) {
+	keys := []*astore.DeletedKey{}
+	ts := time.Date(2015, 6, 1, 12, 0, 0, 0, time.UTC)
+	for name := range dk.deleted {
+		keys = append(keys, &astore.DeletedKey{Name: name, Deleted: ts, ReclaimAfter: ts.Add(time.Hour)})
+	}
+	return keys, nil
+}
+
+func TestHandlerDeleteKey(t *testing.T) {
+
+	moc := &mockDeletableKey{keys: map[string]bool{"k": true}, deleted: map[string]bool{}}
+	vars := MockRequestVars{}
+	vars["key"] = "k"
+	h := NewDeleteKeyHandler(moc, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "DELETE"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	if moc.keys["k"] || !moc.deleted["k"] {
+		t.Error("expected the key to be deleted")
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "DELETE"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorNotFound, w)
+
+	vars["key"] = "tenant/"
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "DELETE"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidKey, w)
+
+	vars["key"] = "k"
+	moc.err = errors.New("boom")
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "DELETE"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorStoreError, w)
+}
+
+func TestHandlerDeletedKeys(t *testing.T) {
+
+	moc := &mockDeletableKey{keys: map[string]bool{}, deleted: map[string]bool{"k": true}}
+	vars := MockRequestVars{}
+	h := NewDeletedKeysHandler(moc, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	expected := `[{"name":"k","deleted":"2015-06-01T12:00:00Z","reclaimAfter":"2015-06-01T13:00:00Z"}]`
+	if w.Code != http.StatusOK || w.Body.String() != expected {
+		t.Errorf("unexpected response. Expected: %s, got: %d %s", expected, w.Code, w.Body)
+	}
+
+	vars["key"] = "k"
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	if !moc.keys["k"] || moc.deleted["k"] {
+		t.Error("expected the key to be undeleted")
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorNotFound, w)
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	if w.Body.String() != "[]" {
+		t.Errorf("expected no deleted keys, got: %s", w.Body)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+// defaultListKeysLimit is the size of a page of keys if the request doesn't set one.
+const defaultListKeysLimit = 1000
+
+type HandlerListKeys struct {
+	store astore.KeyLister
+}
+
+func NewListKeysHandler(st astore.KeyLister) *HandlerListKeys {
+	return &HandlerListKeys{
+		store: st,
+	}
+}
+
+type keyInfoResponse struct {
+	Name       string            `json:"name"`
+	Created    time.Time         `json:"created"`
+	Count      int               `json:"count"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// attrParamPrefix is the prefix of the query parameters that filter the keys by attribute.
+const attrParamPrefix = "attr."
+
+// ServeHTTP writes a page of the keys in the store, ordered by name, as a JSON array. ?prefix=
+// lists only the keys starting with it, ?after= starts after the named key and ?limit=N sets the
+// size of the page. If the page is full the Link header points at the next one:
+//
+//	Link: </v1/keys?after=<name>&limit=N&prefix=<prefix>>; rel="next"
+//
+// ?attr.<name>=<value> lists only the keys with the attribute set to value. Use it more than once to
+// match several attributes.
+//
+// ?delimiter=/ lists the children of prefix instead, as an array of names. Keys further down the
+// hierarchy are rolled up into a name ending in '/':
+//
+//	["tenant/42/orders/","tenant/42/profile"]
+func (h *HandlerListKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	query := r.URL.Query()
+	limit, err := parseUintParam(query, "limit")
+	if err != nil {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+	if limit == 0 {
+		limit = defaultListKeysLimit
+	}
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	if delimiter != "" && delimiter != astore.KEY_SEPARATOR {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+
+	match := map[string]string{}
+	for name, values := range query {
+		if strings.HasPrefix(name, attrParamPrefix) && len(name) > len(attrParamPrefix) {
+			match[name[len(attrParamPrefix):]] = values[0]
+		}
+	}
+	if delimiter != "" && len(match) > 0 {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+
+	var resp interface{}
+	var names []string
+	if delimiter != "" {
+		names, err = h.store.ListChildren(prefix, query.Get("after"), int(limit))
+		resp = names
+	} else {
+		var keys []*astore.KeyInfo
+		keys, err = h.store.ListKeysWithAttributes(prefix, query.Get("after"), int(limit), match)
+		infos := []*keyInfoResponse{}
+		for _, k := range keys {
+			names = append(names, k.Name)
+			infos = append(infos, &keyInfoResponse{
+				Name:       k.Name,
+				Created:    k.Created.UTC(),
+				Count:      k.Count,
+				Attributes: k.Attributes,
+			})
+		}
+		resp = infos
+	}
+	if err != nil {
+		log.Println("ERROR: listing keys:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	buf, err := json.Marshal(resp)
+	if err != nil {
+		log.Println("ERROR: encoding keys:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+
+	if uint64(len(names)) == limit {
+		link := url.Values{}
+		link.Set("after", names[len(names)-1])
+		link.Set("limit", strconv.FormatUint(limit, 10))
+		if prefix != "" {
+			link.Set("prefix", prefix)
+		}
+		if delimiter != "" {
+			link.Set("delimiter", delimiter)
+		}
+		for name, value := range match {
+			link.Set(attrParamPrefix+name, value)
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, link.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(buf); err != nil {
+		log.Println("ERROR: failed while writing response:", err)
+		return
+	}
+	logRequest(r, http.StatusOK)
+}
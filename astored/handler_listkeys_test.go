@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+type mockKeyLister []astore.KeyInfo
+
+func (kl mockKeyLister) ListKeys(prefix, after string, limit int) ([]*astore.KeyInfo, error) {
+	return kl.ListKeysWithAttributes(prefix, after, limit, nil)
+}
+
+func (kl mockKeyLister) ListKeysWithAttributes(prefix, after string, limit int, match map[string]string) ([]*astore.KeyInfo, error) {
+	keys := []*astore.KeyInfo{}
+	for i := range kl {
+		if !strings.HasPrefix(kl[i].Name, prefix) || kl[i].Name <= after {
+			continue
+		}
+		matched := true
+		for name, value := range match {
+			matched = matched && kl[i].Attributes[name] == value
+		}
+		if !matched {
+			continue
+		}
+		if limit > 0 && len(keys) == limit {
+			break
+		}
+		keys = append(keys, &kl[i])
+	}
+	return keys, nil
+}
+
+func (kl mockKeyLister) ListChildren(prefix, after string, limit int) ([]string, error) {
+	children := []string{}
+	for i := range kl {
+		name := kl[i].Name
+		if !strings.HasPrefix(name, prefix) || (after != "" && (name <= after || strings.HasPrefix(name, after))) {
+			continue
+		}
+		if j := strings.Index(name[len(prefix):], "/"); j >= 0 {
+			name = name[:len(prefix)+j+1]
+		}
+		if len(children) > 0 && children[len(children)-1] == name {
+			continue
+		}
+		if limit > 0 && len(children) == limit {
+			break
+		}
+		children = append(children, name)
+	}
+	return children, nil
+}
+
+func TestHandlerListKeys(t *testing.T) {
+
+	created := time.Unix(1433160000, 0)
+	h := NewListKeysHandler(mockKeyLister{
+		{Name: "order-1", Created: created, Count: 1},
+		{Name: "user-a", Created: created, Count: 2},
+		{Name: "user-b", Created: created, Count: 3},
+	})
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?prefix=user-&limit=1")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	expected := `[{"name":"user-a","created":"2015-06-01T12:00:00Z","count":2}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+	if link := w.Header().Get("Link"); link != `</v1/keys?after=user-a&limit=1&prefix=user->; rel="next"` {
+		t.Errorf("invalid Link header: %s", link)
+	}
+
+	// last page
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?prefix=user-&after=user-a")
+	h.ServeHTTP(w, r)
+	expected = `[{"name":"user-b","created":"2015-06-01T12:00:00Z","count":3}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+	if link := w.Header().Get("Link"); link != "" {
+		t.Errorf("expected no Link header on the last page, got: %s", link)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?limit=x")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidParameter, w)
+}
+
+func TestHandlerListChildren(t *testing.T) {
+
+	h := NewListKeysHandler(mockKeyLister{
+		{Name: "tenant/42/orders/7"},
+		{Name: "tenant/42/orders/8"},
+		{Name: "tenant/42/profile"},
+	})
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?prefix=tenant/42/&delimiter=/&limit=1")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	if w.Body.String() != `["tenant/42/orders/"]` {
+		t.Errorf("invalid response: %s", w.Body)
+	}
+	if link := w.Header().Get("Link"); link != `</v1/keys?after=tenant%2F42%2Forders%2F&delimiter=%2F&limit=1&prefix=tenant%2F42%2F>; rel="next"` {
+		t.Errorf("invalid Link header: %s", link)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?prefix=tenant/42/&delimiter=/&after=tenant/42/orders/")
+	h.ServeHTTP(w, r)
+	if w.Body.String() != `["tenant/42/profile"]` {
+		t.Errorf("invalid response: %s", w.Body)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?delimiter=-")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidParameter, w)
+}
+
+func TestHandlerListKeysByAttribute(t *testing.T) {
+
+	h := NewListKeysHandler(mockKeyLister{
+		{Name: "a", Attributes: map[string]string{"owner": "alice"}},
+		{Name: "b", Attributes: map[string]string{"owner": "bob"}},
+		{Name: "c", Attributes: map[string]string{"owner": "alice"}},
+	})
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?attr.owner=alice&limit=1")
+	h.ServeHTTP(w, r)
+
+	expected := `[{"name":"a","created":"0001-01-01T00:00:00Z","count":0,"attributes":{"owner":"alice"}}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+	if link := w.Header().Get("Link"); link != `</v1/keys?after=a&attr.owner=alice&limit=1>; rel="next"` {
+		t.Errorf("invalid Link header: %s", link)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?attr.owner=alice&after=a")
+	h.ServeHTTP(w, r)
+	expected = `[{"name":"c","created":"0001-01-01T00:00:00Z","count":0,"attributes":{"owner":"alice"}}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+type HandlerKeyMeta struct {
+	store astore.ReadableKey
+	vars  RequestVars
+}
+
+func NewKeyMetaHandler(st astore.ReadableKey, rv RequestVars) *HandlerKeyMeta {
+	return &HandlerKeyMeta{
+		store: st,
+		vars:  rv,
+	}
+}
+
+type keyMetaResponse struct {
+	Count       uint64     `json:"count"`
+	Effective   uint64     `json:"effective"`
+	Bytes       uint64     `json:"bytes"`
+	FirstAppend *time.Time `json:"firstAppend"`
+	LastAppend  *time.Time `json:"lastAppend"`
+	LastHash    string     `json:"lastHash"`
+	Version     uint8      `json:"version"`
+	Trimmed     uint64     `json:"trimmed,omitempty"`
+	Sealed      *time.Time `json:"sealed,omitempty"`
+}
+
+// ServeHTTP writes the stats of the key as JSON:
+//
+//	{"count":2,"effective":2,"bytes":52,"firstAppend":"2015-06-01T12:00:00Z","lastAppend":"2015-06-01T12:00:01Z","lastHash":"<SHA1>","version":3}
+//
+// count is every record in the key and effective leaves out the retracted records and the
+// retractions, which reads skip by default.
+// The append times are null for records written before timestamps were kept. Keys that have been
+// trimmed by a retention policy also have the number of records removed in "trimmed" and sealed
+// keys have when they were sealed in "sealed". A HEAD request returns the same stats as headers
+// instead. Keys without any records get a 404.
+func (h *HandlerKeyMeta) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+
+	st, err := h.store.GetStatsFromKey(key)
+	if err != nil {
+		log.Println("ERROR: reading key stats:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	if st.Count == 0 {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusNotFound)
+			logRequest(r, http.StatusNotFound)
+			return
+		}
+		writeErrorResponse(w, r, ErrorNotFound)
+		return
+	}
+
+	if r.Method == "HEAD" {
+		w.Header().Set("X-Record-Count", strconv.FormatUint(st.Count, 10))
+		w.Header().Set("X-Effective-Count", strconv.FormatUint(st.Effective, 10))
+		w.Header().Set("X-Record-Bytes", strconv.FormatUint(st.Bytes, 10))
+		w.Header().Set("X-Last-Hash", st.LastHash)
+		w.Header().Set("X-Format-Version", strconv.Itoa(int(st.Version)))
+		if !st.LastAppend.IsZero() {
+			w.Header().Set("Last-Modified", st.LastAppend.UTC().Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusOK)
+		logRequest(r, http.StatusOK)
+		return
+	}
+
+	resp := &keyMetaResponse{
+		Count:     st.Count,
+		Effective: st.Effective,
+		Bytes:     st.Bytes,
+		LastHash:  st.LastHash,
+		Version:   st.Version,
+		Trimmed:   st.Trimmed,
+	}
+	if !st.FirstAppend.IsZero() {
+		ts := st.FirstAppend.UTC()
+		resp.FirstAppend = &ts
+	}
+	if !st.LastAppend.IsZero() {
+		ts := st.LastAppend.UTC()
+		resp.LastAppend = &ts
+	}
+	if !st.Sealed.IsZero() {
+		ts := st.Sealed.UTC()
+		resp.Sealed = &ts
+	}
+	writeOKResponse(w, r, resp)
+}
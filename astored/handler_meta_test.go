@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestHandlerKeyMeta(t *testing.T) {
+
+	rk := newMockReadableKey()
+	rk.s["k"] = [][]byte{[]byte(`{"a":1}`), []byte(`{"b":22}`)}
+	vars := MockRequestVars{}
+	vars["key"] = "k"
+	h := NewKeyMetaHandler(rk, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	expected := fmt.Sprintf(`{"count":2,"effective":2,"bytes":15,"firstAppend":"2015-06-01T12:00:00Z",`+
+		`"lastAppend":"2015-06-01T12:00:00.000000001Z","lastHash":"%X","version":3}`, sha1.Sum([]byte(`{"b":22}`)))
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "HEAD"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("expected an empty 200, got: %d %s", w.Code, w.Body)
+	}
+	headers := map[string]string{
+		"X-Record-Count":    "2",
+		"X-Effective-Count": "2",
+		"X-Record-Bytes":    "15",
+		"X-Last-Hash":       fmt.Sprintf("%X", sha1.Sum([]byte(`{"b":22}`))),
+		"X-Format-Version":  "3",
+		"Last-Modified":     "Mon, 01 Jun 2015 12:00:00 GMT",
+	}
+	for name, value := range headers {
+		if got := w.Header().Get(name); got != value {
+			t.Errorf("expected %s: %s, got: %s", name, value, got)
+		}
+	}
+
+	// keys without records don't exist
+	vars["key"] = "missing"
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorNotFound, w)
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "HEAD"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got: %d", w.Code)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
 	"github.com/skyec/astore"
 )
@@ -20,52 +26,265 @@ func NewReadallHandler(st astore.ReadableKey, rv RequestVars) *HandlerReadAll {
 	}
 }
 
+// ServeHTTP writes the records at the key as a JSON array. With ?envelope=true each record is
+// wrapped in an object with the metadata stored with it:
+//
+//	{"seq":0,"ts":"2015-06-01T12:00:00.000000001Z","hash":"<SHA1>","data":<record>}
+//
+// ts is null for records written before timestamps were kept.
+//
+// ?offset=N starts at record N and ?after=<cursor> starts at the record after the page that
+// returned the cursor. ?limit=N returns at most N records along with a Link header pointing at
+// the next page:
+//
+//	Link: </v1/keys/<key>?after=<cursor>&limit=N>; rel="next"
+//
+// The next page of a key that's caught up with is empty until more records are appended.
+//
+// ?order=desc returns the newest records first, or the newest N with ?limit=N. It can't be combined
+// with offset or after.
+//
+// Records that have been retracted and the retractions themselves are left out unless
+// ?include_retracted=true is set. Their envelopes then have "retracted":true or "retraction":true.
+// Pages keep the same record numbers either way so a page can have fewer than limit records.
 func (h *HandlerReadAll) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	key := h.vars.Vars(r)["key"]
 	if key == "" {
 		writeErrorResponse(w, r, ErrorMissingKey)
 		return
 	}
+	query := r.URL.Query()
+	envelope := query.Get("envelope") == "true"
+	paged := query.Get("offset") != "" || query.Get("limit") != "" || query.Get("after") != ""
+	opts := &astore.ReadOptions{IncludeRetracted: query.Get("include_retracted") == "true"}
+
+	desc := false
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+	var limit uint64
+	if desc {
+		var err error
+		limit, err = parseUintParam(query, "limit")
+		if err != nil || query.Get("offset") != "" || query.Get("after") != "" {
+			writeErrorResponse(w, r, ErrorInvalidParameter)
+			return
+		}
+	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
 	wr := &recordWriter{
 		w: w,
 	}
 
-	wr.Write([]byte("["))
+	// The response is started with the first record so a bad cursor can still be reported.
+	count := 0
+	start := func() {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		wr.Write([]byte("["))
+	}
+	separate := func() {
+		if count == 0 {
+			start()
+		} else {
+			wr.Write([]byte(","))
+		}
+		count++
+	}
+	writeRecord := func(rec *astore.Record) error {
+		separate()
+		if envelope {
+			return writeEnvelope(wr, "", rec)
+		}
+		_, err := io.Copy(wr, rec.Data)
+		return err
+	}
 
-	contentCount, err := h.store.GetCountFromKey(key)
+	var err error
+	switch {
+	case desc:
+		err = h.store.ReadLastFromKey(key, int(limit), writeRecord, opts)
+	case paged:
+		var code ErrorResponseCode
+		code, err = h.readPage(w, r, key, writeRecord, opts)
+		if code != 0 {
+			if err != nil {
+				log.Println("ERROR: failed reading key:", err)
+			}
+			writeErrorResponse(w, r, code)
+			return
+		}
+	case envelope:
+		err = h.store.ReadEachRecordFromKey(key, writeRecord, opts)
+	default:
+		err = h.store.ReadEachFromKeyWithOptions(key, func(r io.Reader) error {
+			separate()
+			_, err := io.Copy(wr, r)
+			return err
+		}, opts)
+	}
 	if err != nil {
-		log.Printf("ERROR: failed to read the key count: %s", err)
-		writeErrorResponse(w, r, ErrorStoreError)
+		log.Println("ERROR: failed reading key:", key, err)
+		abortResponse(w, r, count > 0, err)
+		return
+	}
+	if count == 0 {
+		start()
+	}
+	wr.Write([]byte("]"))
+
+	if wr.err != nil {
+		log.Println("ERROR: failed while writing response:", wr.err)
 		return
 	}
+	logRequest(r, http.StatusOK)
+}
 
-	count := 0
-	err = h.store.ReadEachFromKey(key, func(r io.Reader) error {
-		_, err := io.Copy(wr, r)
+// abortResponse reports err, the error that stopped a read, to the client. If the response hasn't
+// started it gets an error response: ErrorCorruptBlock for a *astore.CorruptBlockError,
+// ErrorTooManyKeys for astore.ErrTooManyPrefixKeys and ErrorStoreError otherwise. Once it has, the 200 and part of the body have already been sent so
+// the connection is broken instead; the client sees an incomplete response rather than a
+// well-formed one that's missing records.
+func abortResponse(w http.ResponseWriter, r *http.Request, started bool, err error) {
+	if started {
+		logRequest(r, http.StatusInternalServerError)
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Del("Link")
+	if _, ok := err.(*astore.CorruptBlockError); ok {
+		writeErrorResponse(w, r, ErrorCorruptBlock)
+		return
+	}
+	if err == astore.ErrTooManyPrefixKeys {
+		writeErrorResponse(w, r, ErrorTooManyKeys)
+		return
+	}
+	writeErrorResponse(w, r, ErrorStoreError)
+}
+
+// errPageFull stops reading once a page has all of its records.
+var errPageFull = errors.New("page full")
+
+// readPage calls f for the records in the page selected by the offset, limit and after query
+// parameters and sets the Link header for the next page. An error code is returned for bad
+// parameters. Those are found before f is called.
+func (h *HandlerReadAll) readPage(w http.ResponseWriter, r *http.Request, key string, f astore.RecordFunc, opts *astore.ReadOptions) (ErrorResponseCode, error) {
+
+	query := r.URL.Query()
+	limit, err := parseUintParam(query, "limit")
+	if err != nil {
+		return ErrorInvalidParameter, nil
+	}
+
+	var from astore.Position
+	if after := query.Get("after"); after != "" {
+		from, err = astore.ParsePosition(after)
 		if err != nil {
-			return err
+			return ErrorInvalidCursor, nil
+		}
+	} else {
+		offset, err := parseUintParam(query, "offset")
+		if err != nil {
+			return ErrorInvalidParameter, nil
 		}
+		from, err = h.store.PositionAt(key, offset)
+		if err != nil {
+			return ErrorStoreError, err
+		}
+	}
 
-		if count < contentCount {
-			wr.Write([]byte(","))
+	// The end of the page is found up front so that records appended while it's being read are
+	// left for the next page.
+	var next astore.Position
+	if limit > 0 {
+		next, err = h.store.PositionAt(key, from.Seq+limit)
+		if err != nil {
+			return ErrorStoreError, err
 		}
-		count++
-		return err
-	})
-	wr.Write([]byte("]"))
+		link := url.Values{}
+		link.Set("after", next.String())
+		link.Set("limit", strconv.FormatUint(limit, 10))
+		for _, name := range []string{"envelope", "include_retracted"} {
+			if query.Get(name) != "" {
+				link.Set(name, query.Get(name))
+			}
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, link.Encode()))
+	}
+
+	started := false
+	_, err = h.store.ReadFromPosition(key, from, 0, func(rec *astore.Record) error {
+		if limit > 0 && rec.Seq >= next.Seq {
+			return errPageFull
+		}
+		started = true
+		return f(rec)
+	}, opts)
+	switch {
+	case err == errPageFull:
+		err = nil
+	case err == astore.ErrInvalidPosition && !started:
+		w.Header().Del("Link")
+		return ErrorInvalidCursor, nil
+	}
+	return 0, err
+}
 
+// parseUintParam returns the value of the query parameter name or 0 if it isn't set.
+func parseUintParam(query url.Values, name string) (uint64, error) {
+	v := query.Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+type recordEnvelope struct {
+	Key        string     `json:"key,omitempty"`
+	Seq        uint64     `json:"seq"`
+	Ts         *time.Time `json:"ts"`
+	Hash       string     `json:"hash"`
+	Retraction bool       `json:"retraction,omitempty"`
+	Retracted  bool       `json:"retracted,omitempty"`
+}
+
+// writeEnvelope writes rec to w wrapped in a recordEnvelope. The record is copied as is into the
+// data field. key is left out if it's empty.
+func writeEnvelope(w io.Writer, key string, rec *astore.Record) error {
+
+	env := &recordEnvelope{
+		Key:        key,
+		Seq:        rec.Seq,
+		Hash:       rec.Hash,
+		Retraction: rec.Retraction,
+		Retracted:  rec.Retracted,
+	}
+	if !rec.Timestamp.IsZero() {
+		ts := rec.Timestamp.UTC()
+		env.Ts = &ts
+	}
+	b, err := json.Marshal(env)
 	if err != nil {
-		log.Println("ERROR: failed while writing response:", err)
+		return err
 	}
 
-	if wr.err != nil {
-		log.Println("ERROR: failed while writing response:", err)
-		return
+	// replace the closing brace with the data field
+	if _, err = w.Write(b[:len(b)-1]); err != nil {
+		return err
 	}
-	logRequest(r, http.StatusOK)
+	if _, err = io.WriteString(w, `,"data":`); err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, rec.Data); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "}")
+	return err
 }
 
 // recordWriter is a utility to aid in making multiple writes and postponing
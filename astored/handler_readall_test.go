@@ -2,16 +2,24 @@ package main
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"fmt"
+	"io"
 	"mime"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/skyec/astore"
 )
 
 type mockReadableKey struct {
-	s   map[string][][]byte
-	err error
+	s       map[string][][]byte
+	corrupt map[string]int // the record of a key that's read as a corrupt block
+	err     error
 }
 
 func newMockReadableKey() mockReadableKey {
@@ -20,9 +28,20 @@ func newMockReadableKey() mockReadableKey {
 	return m
 }
 
+// corruptBlock returns the error for record i of key if it's the key's corrupt record.
+func (rk mockReadableKey) corruptBlock(key string, i int) error {
+	if at, ok := rk.corrupt[key]; ok && at == i {
+		return &astore.CorruptBlockError{Key: key, Index: i, Reason: "bad CRC64"}
+	}
+	return nil
+}
+
 func (rk mockReadableKey) ReadEachFromKey(key string, f astore.ReadFunc) error {
 
-	for _, rec := range rk.s[key] {
+	for i, rec := range rk.s[key] {
+		if err := rk.corruptBlock(key, i); err != nil {
+			return err
+		}
 		err := f(bytes.NewBuffer(rec))
 		if err != nil {
 			return err
@@ -31,6 +50,109 @@ func (rk mockReadableKey) ReadEachFromKey(key string, f astore.ReadFunc) error {
 	return nil
 }
 
+func (rk mockReadableKey) ReadEachFromKeyWithOptions(key string, f astore.ReadFunc, opts *astore.ReadOptions) error {
+	return rk.ReadEachFromKey(key, f)
+}
+
+func (rk mockReadableKey) ReadEachRecordFromKey(key string, f astore.RecordFunc, opts *astore.ReadOptions) error {
+
+	for i, rec := range rk.s[key] {
+		if err := rk.corruptBlock(key, i); err != nil {
+			return err
+		}
+		err := f(&astore.Record{
+			Seq:       uint64(i),
+			Timestamp: time.Unix(1433160000, int64(i)),
+			Hash:      fmt.Sprintf("%X", sha1.Sum(rec)),
+			Data:      bytes.NewBuffer(rec),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The mock's cursors use the record number as the offset.
+func (rk mockReadableKey) ReadRangeFromKey(key string, from uint64, limit int, f astore.RecordFunc, opts *astore.ReadOptions) (astore.Position, error) {
+	c, _ := rk.PositionAt(key, from)
+	return rk.ReadFromPosition(key, c, limit, f, opts)
+}
+
+func (rk mockReadableKey) ReadFromPosition(key string, c astore.Position, limit int, f astore.RecordFunc, opts *astore.ReadOptions) (astore.Position, error) {
+
+	if c.Offset != int64(c.Seq) || c.Seq > uint64(len(rk.s[key])) {
+		return c, astore.ErrInvalidPosition
+	}
+	for read := 0; c.Seq < uint64(len(rk.s[key])) && (limit <= 0 || read < limit); read++ {
+		rec := rk.s[key][c.Seq]
+		err := f(&astore.Record{
+			Seq:       c.Seq,
+			Timestamp: time.Unix(1433160000, int64(c.Seq)),
+			Hash:      fmt.Sprintf("%X", sha1.Sum(rec)),
+			Data:      bytes.NewBuffer(rec),
+		})
+		if err != nil {
+			return c, err
+		}
+		c.Seq++
+		c.Offset++
+	}
+	return c, nil
+}
+
+func (rk mockReadableKey) PositionAt(key string, index uint64) (astore.Position, error) {
+	if index > uint64(len(rk.s[key])) {
+		index = uint64(len(rk.s[key]))
+	}
+	return astore.Position{Offset: int64(index), Seq: index}, nil
+}
+
+func (rk mockReadableKey) OpenCursor(key string, start astore.Position) (astore.Cursor, error) {
+	if start.Offset != int64(start.Seq) || start.Seq > uint64(len(rk.s[key])) {
+		return nil, astore.ErrInvalidPosition
+	}
+	return &mockCursor{records: rk.s[key], next: int(start.Seq)}, nil
+}
+
+type mockCursor struct {
+	records [][]byte
+	next    int
+}
+
+func (c *mockCursor) Next() bool {
+	if c.next >= len(c.records) {
+		return false
+	}
+	c.next++
+	return true
+}
+
+func (c *mockCursor) Record() []byte    { return c.records[c.next-1] }
+func (c *mockCursor) Reader() io.Reader { return bytes.NewReader(c.Record()) }
+func (c *mockCursor) Err() error        { return nil }
+func (c *mockCursor) Close() error      { return nil }
+func (c *mockCursor) Position() astore.Position {
+	return astore.Position{Offset: int64(c.next), Seq: uint64(c.next)}
+}
+
+func (rk mockReadableKey) ReadLastFromKey(key string, n int, f astore.RecordFunc, opts *astore.ReadOptions) error {
+
+	records := rk.s[key]
+	for i := len(records) - 1; i >= 0 && (n <= 0 || i >= len(records)-n); i-- {
+		err := f(&astore.Record{
+			Seq:       uint64(i),
+			Timestamp: time.Unix(1433160000, int64(i)),
+			Hash:      fmt.Sprintf("%X", sha1.Sum(records[i])),
+			Data:      bytes.NewBuffer(records[i]),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (rk mockReadableKey) GetCountFromKey(key string) (int, error) {
 
 	if rk.err != nil {
@@ -39,6 +161,26 @@ func (rk mockReadableKey) GetCountFromKey(key string) (int, error) {
 	return len(rk.s), nil
 }
 
+func (rk mockReadableKey) GetStatsFromKey(key string) (*astore.KeyStats, error) {
+
+	if rk.err != nil {
+		return nil, rk.err
+	}
+	st := &astore.KeyStats{}
+	for i, rec := range rk.s[key] {
+		if i == 0 {
+			st.FirstAppend = time.Unix(1433160000, int64(i))
+		}
+		st.Count++
+		st.Effective++
+		st.Bytes += uint64(len(rec))
+		st.LastAppend = time.Unix(1433160000, int64(i))
+		st.LastHash = fmt.Sprintf("%X", sha1.Sum(rec))
+		st.Version = 3
+	}
+	return st, nil
+}
+
 func TestHandlerReadAll(t *testing.T) {
 	testKey := "test key"
 	testData := [][]byte{
@@ -79,6 +221,149 @@ func TestHandlerReadAll(t *testing.T) {
 	}
 }
 
+func TestHandlerReadAllEnvelope(t *testing.T) {
+	testKey := "test key"
+	testData := [][]byte{
+		[]byte(`{"test":"me"}`),
+		[]byte(`{"second":"record"}`),
+	}
+
+	vars := MockRequestVars{}
+	vars["key"] = testKey
+
+	store := newMockReadableKey()
+	store.s[testKey] = testData
+
+	h := NewReadallHandler(store, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys?envelope=true")
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+
+	expected := fmt.Sprintf(`[{"seq":0,"ts":"2015-06-01T12:00:00Z","hash":"%X","data":{"test":"me"}},`+
+		`{"seq":1,"ts":"2015-06-01T12:00:00.000000001Z","hash":"%X","data":{"second":"record"}}]`,
+		sha1.Sum(testData[0]), sha1.Sum(testData[1]))
+	if expected != w.Body.String() {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+}
+
+func TestHandlerReadAllPages(t *testing.T) {
+	testKey := "test key"
+	testData := [][]byte{
+		[]byte(`{"n":0}`),
+		[]byte(`{"n":1}`),
+		[]byte(`{"n":2}`),
+	}
+
+	vars := MockRequestVars{}
+	vars["key"] = testKey
+
+	store := newMockReadableKey()
+	store.s[testKey] = testData
+
+	h := NewReadallHandler(store, vars)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+		r.URL, _ = url.Parse("/v1/keys/test?" + query)
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("invalid response code for %s. Expected 200, got: %d", query, w.Code)
+		}
+		return w
+	}
+
+	w := get("offset=1")
+	if w.Body.String() != `[{"n":1},{"n":2}]` {
+		t.Errorf("invalid response for offset=1: %s", w.Body)
+	}
+	if w.Header().Get("Link") != "" {
+		t.Errorf("unexpected Link header without a limit: %s", w.Header().Get("Link"))
+	}
+
+	pages := []string{}
+	query := "limit=2"
+	for i := 0; i < 3; i++ {
+		w = get(query)
+		pages = append(pages, w.Body.String())
+
+		link := w.Header().Get("Link")
+		if !strings.HasSuffix(link, `>; rel="next"`) || !strings.HasPrefix(link, "</v1/keys/test?") {
+			t.Fatalf("invalid Link header: %s", link)
+		}
+		query = strings.TrimSuffix(strings.TrimPrefix(link, "</v1/keys/test?"), `>; rel="next"`)
+	}
+	expected := []string{`[{"n":0},{"n":1}]`, `[{"n":2}]`, `[]`}
+	for i := range expected {
+		if pages[i] != expected[i] {
+			t.Errorf("invalid page %d. Expected: %s, got: %s", i, expected[i], pages[i])
+		}
+	}
+
+	// a record appended after the last page shows up in the next one
+	store.s[testKey] = append(testData, []byte(`{"n":3}`))
+	if w = get(query); w.Body.String() != `[{"n":3}]` {
+		t.Errorf("invalid page after an append: %s", w.Body)
+	}
+}
+
+func TestHandlerReadAllDesc(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "test key"
+
+	store := newMockReadableKey()
+	store.s["test key"] = [][]byte{[]byte(`{"n":0}`), []byte(`{"n":1}`), []byte(`{"n":2}`)}
+	h := NewReadallHandler(store, vars)
+
+	cases := map[string]string{
+		"order=desc":         `[{"n":2},{"n":1},{"n":0}]`,
+		"order=desc&limit=2": `[{"n":2},{"n":1}]`,
+		"order=asc&limit=2":  `[{"n":0},{"n":1}]`,
+	}
+	for query, expected := range cases {
+		r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+		r.URL = &url.URL{Path: "/v1/keys/test", RawQuery: query}
+		h.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Errorf("invalid response code for %s. Expected 200, got: %d", query, w.Code)
+		}
+		if w.Body.String() != expected {
+			t.Errorf("invalid response for %s. Expected: %s, got: %s", query, expected, w.Body)
+		}
+	}
+}
+
+func TestHandlerReadAllBadPageParameters(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "test key"
+
+	store := newMockReadableKey()
+	store.s["test key"] = [][]byte{[]byte(`{}`)}
+	h := NewReadallHandler(store, vars)
+
+	cases := map[string]ErrorResponseCode{
+		"offset=-1":           ErrorInvalidParameter,
+		"limit=x":             ErrorInvalidParameter,
+		"after=AAAA":          ErrorInvalidCursor,
+		"order=up":            ErrorInvalidParameter,
+		"order=desc&offset=1": ErrorInvalidParameter,
+		"after=" + astore.Position{Offset: 5, Seq: 1}.String(): ErrorInvalidCursor,
+	}
+	for query, code := range cases {
+		r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+		r.URL = &url.URL{Path: "/v1/keys/test", RawQuery: query}
+		h.ServeHTTP(w, r)
+		validateErrorResponse(t, code, w)
+	}
+}
+
 // This case gets a 400 not a 404 because it's a user error to not include a key
 func TestHandlerReadallMissingKey(t *testing.T) {
 
@@ -112,3 +397,34 @@ func TestHandlerReadallEmptyResults(t *testing.T) {
 		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
 	}
 }
+
+func TestHandlerReadAllCorruptBlock(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "test key"
+	store := newMockReadableKey()
+	store.s["test key"] = [][]byte{[]byte(`{"a":1}`), []byte(`{"b":2}`)}
+
+	// The first record is corrupt so nothing has been sent yet
+	store.corrupt = map[string]int{"test key": 0}
+	h := NewReadallHandler(store, vars)
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorCorruptBlock, w)
+
+	// A later record is corrupt once the array has been started
+	store.corrupt = map[string]int{"test key": 1}
+	h = NewReadallHandler(store, vars)
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("expected the handler to abort the response, got: %v", p)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	}()
+	if body := w.Body.String(); body != `[{"a":1}` {
+		t.Errorf("expected an unterminated array, got: %s", body)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/skyec/astore"
+)
+
+type HandlerReadPrefix struct {
+	store astore.PrefixReader
+	vars  RequestVars
+}
+
+func NewReadPrefixHandler(st astore.PrefixReader, rv RequestVars) *HandlerReadPrefix {
+	return &HandlerReadPrefix{
+		store: st,
+		vars:  rv,
+	}
+}
+
+// ServeHTTP writes the records from every key under the prefix as a JSON array, in the order they
+// were appended. Each record is in an envelope with the name of its key:
+//
+//	{"key":"tenant/42/orders/7","seq":0,"ts":"2015-06-01T12:00:00.000000001Z","hash":"<SHA1>","data":<record>}
+//
+// ?limit=N returns the first N records. An error before the first record gets an error response
+// and one after it breaks the connection; see abortResponse.
+func (h *HandlerReadPrefix) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := h.vars.Vars(r)["prefix"]
+	if prefix == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+	limit, err := parseUintParam(r.URL.Query(), "limit")
+	if err != nil {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+
+	wr := &recordWriter{
+		w: w,
+	}
+
+	// The response is started with the first record so an error before it can still be reported.
+	count := 0
+	start := func() {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		wr.Write([]byte("["))
+	}
+	err = h.store.ReadPrefix(prefix, int(limit), func(key string, rec *astore.Record) error {
+		if count == 0 {
+			start()
+		} else {
+			wr.Write([]byte(","))
+		}
+		count++
+		return writeEnvelope(wr, key, rec)
+	})
+	if err != nil {
+		log.Println("ERROR: failed reading prefix:", prefix, err)
+		abortResponse(w, r, count > 0, err)
+		return
+	}
+	if count == 0 {
+		start()
+	}
+	wr.Write([]byte("]"))
+
+	if wr.err != nil {
+		log.Println("ERROR: failed while writing response:", wr.err)
+		return
+	}
+	logRequest(r, http.StatusOK)
+}
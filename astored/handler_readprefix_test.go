@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/skyec/astore"
+)
+
+type mockPrefixReader []struct {
+	key  string
+	data string
+}
+
+func (pr mockPrefixReader) ReadPrefix(prefix string, limit int, f astore.PrefixRecordFunc) error {
+	n := 0
+	for i, rec := range pr {
+		if !strings.HasPrefix(rec.key, prefix) {
+			continue
+		}
+		if limit > 0 && n == limit {
+			break
+		}
+		n++
+		err := f(rec.key, &astore.Record{Seq: uint64(i), Hash: "HASH", Data: strings.NewReader(rec.data)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// failingPrefixReader returns err after reading the first n records.
+type failingPrefixReader struct {
+	mockPrefixReader
+	n   int
+	err error
+}
+
+func (pr failingPrefixReader) ReadPrefix(prefix string, limit int, f astore.PrefixRecordFunc) error {
+	if pr.n > 0 {
+		if err := pr.mockPrefixReader.ReadPrefix(prefix, pr.n, f); err != nil {
+			return err
+		}
+	}
+	return pr.err
+}
+
+func TestHandlerReadPrefix(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["prefix"] = "tenant/42/"
+	h := NewReadPrefixHandler(mockPrefixReader{
+		{"tenant/42/orders/7", `{"a":1}`},
+		{"tenant/43/profile", `{"b":2}`},
+		{"tenant/42/profile", `{"c":3}`},
+	}, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	expected := `[{"key":"tenant/42/orders/7","seq":0,"ts":null,"hash":"HASH","data":{"a":1}},` +
+		`{"key":"tenant/42/profile","seq":2,"ts":null,"hash":"HASH","data":{"c":3}}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys/tenant/42/?limit=1")
+	h.ServeHTTP(w, r)
+	expected = `[{"key":"tenant/42/orders/7","seq":0,"ts":null,"hash":"HASH","data":{"a":1}}]`
+	if w.Body.String() != expected {
+		t.Errorf("invalid response. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.URL, _ = url.Parse("/v1/keys/tenant/42/?limit=x")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidParameter, w)
+}
+
+func TestHandlerReadPrefixError(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["prefix"] = "tenant/42/"
+	recs := mockPrefixReader{
+		{"tenant/42/orders/7", `{"a":1}`},
+		{"tenant/42/profile", `{"c":3}`},
+	}
+
+	h := NewReadPrefixHandler(failingPrefixReader{recs, 0, astore.ErrTooManyPrefixKeys}, vars)
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorTooManyKeys, w)
+
+	h = NewReadPrefixHandler(failingPrefixReader{recs, 0, &astore.CorruptBlockError{}}, vars)
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorCorruptBlock, w)
+
+	// Once the array has been started the response is aborted
+	h = NewReadPrefixHandler(failingPrefixReader{recs, 1, &astore.CorruptBlockError{}}, vars)
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	func() {
+		defer func() {
+			if p := recover(); p != http.ErrAbortHandler {
+				t.Errorf("expected the handler to abort the response, got: %v", p)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	}()
+	expected := `[{"key":"tenant/42/orders/7","seq":0,"ts":null,"hash":"HASH","data":{"a":1}}`
+	if w.Body.String() != expected {
+		t.Errorf("expected an unterminated array. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/skyec/astore"
+)
+
+// maxRetractionSize is the largest retraction request body that's read.
+const maxRetractionSize = 1024
+
+type HandlerRetract struct {
+	store astore.RetractableKey
+	vars  RequestVars
+}
+
+func NewRetractHandler(st astore.RetractableKey, rv RequestVars) *HandlerRetract {
+	return &HandlerRetract{
+		store: st,
+		vars:  rv,
+	}
+}
+
+type retractRequest struct {
+	Hash string `json:"hash"`
+}
+
+// ServeHTTP retracts the record in the key with the hash in the body:
+//
+//	{"hash":"<SHA1>"}
+//
+// The retraction is appended to the key and both records are left out of reads from then on,
+// unless they ask for ?include_retracted=true. Retracting a record that's already been retracted
+// does nothing. Records the key doesn't have get a 404 and sealed keys a 409.
+func (h *HandlerRetract) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+	if strings.HasSuffix(key, astore.KEY_SEPARATOR) {
+		writeErrorResponse(w, r, ErrorInvalidKey)
+		return
+	}
+
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || t != "application/json" {
+		writeErrorResponse(w, r, ErrorInvalidContentType)
+		return
+	}
+	req := &retractRequest{}
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxRetractionSize))
+	if err = dec.Decode(req); err != nil || !isRecordHash(req.Hash) {
+		writeErrorResponse(w, r, ErrorInvalidRetraction)
+		return
+	}
+
+	switch err = h.store.RetractRecord(key, req.Hash); err {
+	case nil:
+	case astore.ErrRecordNotFound:
+		writeErrorResponse(w, r, ErrorNotFound)
+		return
+	case astore.ErrKeySealed:
+		writeErrorResponse(w, r, ErrorKeySealed)
+		return
+	default:
+		log.Println("ERROR: retracting record:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	writeOKResponse(w, r, map[string]string{"status": "ok"})
+}
+
+// isRecordHash reports if s is a hex encoded SHA1.
+func isRecordHash(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == 20
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/skyec/astore"
+)
+
+type mockRetractableKey struct {
+	hashes    map[string]bool // hash -> retracted
+	sealed    bool
+	err       error
+	retracted []string
+}
+
+func (rk *mockRetractableKey) RetractRecord(key, hash string) error {
+	switch {
+	case rk.err != nil:
+		return rk.err
+	case rk.sealed:
+		return astore.ErrKeySealed
+	}
+	if _, ok := rk.hashes[hash]; !ok {
+		return astore.ErrRecordNotFound
+	}
+	rk.hashes[hash] = true
+	rk.retracted = append(rk.retracted, key+":"+hash)
+	return nil
+}
+
+func TestHandlerRetract(t *testing.T) {
+
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte(`{"a":1}`)))
+	moc := &mockRetractableKey{hashes: map[string]bool{hash: false}}
+	vars := MockRequestVars{}
+	vars["key"] = "k"
+	h := NewRetractHandler(moc, vars)
+
+	retract := func(body string) *bytes.Buffer {
+		return bytes.NewBufferString(body)
+	}
+	r, w := helpNewRequestResponse(retract(`{"hash":"`+hash+`"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	if len(moc.retracted) != 1 || moc.retracted[0] != "k:"+hash {
+		t.Errorf("expected the record to be retracted, got: %v", moc.retracted)
+	}
+
+	for _, body := range []string{``, `{}`, `{"hash":"ABC"}`, `{"hash":"` + hash + `0"}`, `[]`} {
+		r, w = helpNewRequestResponse(retract(body), &bytes.Buffer{})
+		r.Method = "POST"
+		h.ServeHTTP(w, r)
+		validateErrorResponse(t, ErrorInvalidRetraction, w)
+	}
+
+	r, w = helpNewRequestResponse(retract(`{"hash":"`+hash+`"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	r.Header.Del("Content-Type")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidContentType, w)
+
+	missing := fmt.Sprintf("%X", sha1.Sum([]byte(`{"b":2}`)))
+	r, w = helpNewRequestResponse(retract(`{"hash":"`+missing+`"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorNotFound, w)
+
+	moc.sealed = true
+	r, w = helpNewRequestResponse(retract(`{"hash":"`+hash+`"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorKeySealed, w)
+
+	moc.err = errors.New("boom")
+	r, w = helpNewRequestResponse(retract(`{"hash":"`+hash+`"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorStoreError, w)
+
+	vars["key"] = "tenant/"
+	r, w = helpNewRequestResponse(retract(`{"hash":"`+hash+`"}`), &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidKey, w)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/skyec/astore"
+)
+
+type HandlerSeal struct {
+	store astore.SealableKey
+	vars  RequestVars
+}
+
+func NewSealHandler(st astore.SealableKey, rv RequestVars) *HandlerSeal {
+	return &HandlerSeal{
+		store: st,
+		vars:  rv,
+	}
+}
+
+// ServeHTTP seals the key. Appends to a sealed key get a 409. Sealing a sealed key does nothing
+// and keys without any records get a 404.
+func (h *HandlerSeal) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+	if strings.HasSuffix(key, astore.KEY_SEPARATOR) {
+		writeErrorResponse(w, r, ErrorInvalidKey)
+		return
+	}
+
+	switch err := h.store.SealKey(key); err {
+	case nil:
+	case astore.ErrKeyNotFound:
+		writeErrorResponse(w, r, ErrorNotFound)
+		return
+	default:
+		log.Println("ERROR: sealing key:", err)
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+	writeOKResponse(w, r, map[string]string{"status": "ok"})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/skyec/astore"
+)
+
+type mockSealableKey struct {
+	sealed map[string]bool
+	err    error
+}
+
+func (sk *mockSealableKey) SealKey(key string) error {
+	if sk.err != nil {
+		return sk.err
+	}
+	if _, ok := sk.sealed[key]; !ok {
+		return astore.ErrKeyNotFound
+	}
+	sk.sealed[key] = true
+	return nil
+}
+
+func TestHandlerSeal(t *testing.T) {
+
+	moc := &mockSealableKey{sealed: map[string]bool{"k": false}}
+	vars := MockRequestVars{}
+	vars["key"] = "k"
+	h := NewSealHandler(moc, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	if !moc.sealed["k"] {
+		t.Error("expected the key to be sealed")
+	}
+
+	vars["key"] = "missing"
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorNotFound, w)
+
+	vars["key"] = "tenant/"
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidKey, w)
+
+	vars["key"] = "k"
+	moc.err = errors.New("boom")
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Method = "POST"
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorStoreError, w)
+}
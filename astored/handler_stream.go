@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+// streamKeepAlive is how often a comment is sent on an idle stream so proxies don't close it.
+const streamKeepAlive = 15 * time.Second
+
+type HandlerStream struct {
+	store astore.WatchableKey
+	vars  RequestVars
+}
+
+func NewStreamHandler(st astore.WatchableKey, rv RequestVars) *HandlerStream {
+	return &HandlerStream{
+		store: st,
+		vars:  rv,
+	}
+}
+
+// ServeHTTP sends the records at the key as Server-Sent Events, starting with the ones already in
+// the key and then new ones as they're appended. The id of each event is the record's sequence
+// number:
+//
+//	id: 0
+//	data: {"your":"record"}
+//
+// Retractions are sent too, as retraction events, so clients can drop the records they retract:
+//
+//	id: 7
+//	event: retraction
+//	data: {"retract":"<SHA1>"}
+//
+// A client that reconnects with a Last-Event-ID header picks up with the record after it.
+// ?from=N starts a new stream at record N. The stream ends if the client falls too far behind.
+func (h *HandlerStream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := h.vars.Vars(r)["key"]
+	if key == "" {
+		writeErrorResponse(w, r, ErrorMissingKey)
+		return
+	}
+
+	var from uint64
+	var err error
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		from, err = strconv.ParseUint(id, 10, 64)
+		from++
+	} else {
+		from, err = parseUintParam(r.URL.Query(), "from")
+	}
+	if err != nil {
+		writeErrorResponse(w, r, ErrorInvalidParameter)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErrorResponse(w, r, ErrorStoreError)
+		return
+	}
+
+	records, cancel := h.store.Watch(key, from)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	logRequest(r, http.StatusOK)
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case rec, ok := <-records:
+			if !ok {
+				return
+			}
+			if err = writeEvent(w, &rec); err != nil {
+				log.Println("ERROR: failed while writing stream:", err)
+				return
+			}
+		case <-keepAlive.C:
+			if _, err = w.Write([]byte(": keep-alive\n\n")); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes rec as an event. Each line of the record gets its own data field.
+func writeEvent(w http.ResponseWriter, rec *astore.Record) error {
+
+	data, err := ioutil.ReadAll(rec.Data)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	buf.WriteString("id: " + strconv.FormatUint(rec.Seq, 10) + "\n")
+	if rec.Retraction {
+		buf.WriteString("event: retraction\n")
+	}
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		buf.WriteString("data: ")
+		buf.Write(bytes.TrimSuffix(line, []byte("\r")))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("\n")
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/skyec/astore"
+)
+
+// mockWatchableKey sends the records from the watched index and then closes the channel.
+type mockWatchableKey map[string][][]byte
+
+func (wk mockWatchableKey) Watch(key string, from uint64) (<-chan astore.Record, astore.CancelFunc) {
+
+	ch := make(chan astore.Record, len(wk[key]))
+	for i := from; i < uint64(len(wk[key])); i++ {
+		ch <- astore.Record{
+			Seq:       i,
+			Timestamp: time.Unix(1433160000, int64(i)),
+			Data:      bytes.NewReader(wk[key][i]),
+		}
+	}
+	close(ch)
+	return ch, func() {}
+}
+
+func TestHandlerStream(t *testing.T) {
+
+	vars := MockRequestVars{}
+	vars["key"] = "test key"
+
+	store := mockWatchableKey{}
+	store["test key"] = [][]byte{
+		[]byte(`{"n":0}`),
+		[]byte("{\n\"n\":1\n}"),
+		[]byte(`{"n":2}`),
+	}
+	h := NewStreamHandler(store, vars)
+
+	r, w := helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Errorf("invalid response code. Expected 200, got: %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("invalid content type: %s", ct)
+	}
+	expected := "id: 0\ndata: {\"n\":0}\n\n" +
+		"id: 1\ndata: {\ndata: \"n\":1\ndata: }\n\n" +
+		"id: 2\ndata: {\"n\":2}\n\n"
+	if w.Body.String() != expected {
+		t.Errorf("invalid stream. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+
+	// resume after the last event the client saw
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Header.Set("Last-Event-ID", "1")
+	h.ServeHTTP(w, r)
+	if expected = "id: 2\ndata: {\"n\":2}\n\n"; w.Body.String() != expected {
+		t.Errorf("invalid resumed stream. Expected:\n%s\nGot:\n%s", expected, w.Body)
+	}
+
+	r, w = helpNewRequestResponse(&bytes.Buffer{}, &bytes.Buffer{})
+	r.Header.Set("Last-Event-ID", "nope")
+	h.ServeHTTP(w, r)
+	validateErrorResponse(t, ErrorInvalidParameter, w)
+}
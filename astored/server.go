@@ -7,7 +7,9 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/skyec/astore"
@@ -22,42 +24,94 @@ func main() {
 		kafkaBrokers *flagKafkaBrokers = &flagKafkaBrokers{}
 		kafkaTopic   string
 		purge        bool
+		purgeToken   string
+		txlogEnabled bool
+		codec        string
+		retention    *flagRetention = &flagRetention{}
+		dedupe       string
+		dedupePolicy *flagDedupe    = &flagDedupe{}
+		storeConf    *astore.Config = astore.NewConfig()
 	)
 
 	flag.StringVar(&storeDir, "s", "/var/astore", "Directory that contains the store data")
 	flag.BoolVar(&purge, "PURGE", false, "Purge the store of all data. WARNING: you can't recover from this!!")
+	flag.StringVar(&purgeToken, "purge-token", "", "Token confirming -PURGE. Run with -PURGE alone to get one")
 	flag.StringVar(&listenAddr, "l", ":9898", "Port the main service listens on")
 	flag.BoolVar(&kafkaEnabled, "K", false, "Enable consuming events from Kafka")
 	flag.StringVar(&kafkaTopic, "topic", "astore", "Kafka topic to consume events from")
 	flag.Var(kafkaBrokers, "brokers", "List of Kafka brokers if enabled e.g. kafka://b1:9092,b2:9092")
+	flag.BoolVar(&txlogEnabled, "T", false, "Write appends through the transaction log")
+	flag.DurationVar(&storeConf.TxLog.RotateInterval, "txlog-rotate", storeConf.TxLog.RotateInterval, "How often the transaction log is rotated and committed to the keys")
+	flag.IntVar(&storeConf.TxLog.Committers, "txlog-committers", storeConf.TxLog.Committers, "Number of goroutines committing the transaction log to the keys")
+	flag.BoolVar(&storeConf.Key.LockFiles, "lock-files", storeConf.Key.LockFiles, "Lock each key's lock file while changing it so other processes, like astore-fsck, wait")
+	flag.Int64Var(&storeConf.Key.SegmentSize, "segment-size", storeConf.Key.SegmentSize, "Size in bytes at which a key's content segment is sealed and a new one started")
+
+	flag.DurationVar(&storeConf.Watch.SendTimeout, "stream-timeout", storeConf.Watch.SendTimeout, "How long a stream subscriber can fall behind before it's disconnected; 0 waits forever")
+	flag.StringVar(&codec, "codec", storeConf.Key.Codec.String(), "Codec used to compress large records: none, snappy or gzip")
+	flag.Var(retention, "retention", "Retention policy e.g. prefix=logs/,max-records=1000,max-bytes=1048576,max-age=24h,mode=trim. Can be repeated")
+	flag.DurationVar(&storeConf.Retention.Interval, "retention-interval", storeConf.Retention.Interval, "How often keys are trimmed by the retention policies with mode=trim")
+	flag.StringVar(&dedupe, "dedupe", storeConf.Dedupe.Mode.String(), "Default dedupe mode: content, none, window or id")
+	flag.Uint64Var(&storeConf.Dedupe.Window, "dedupe-window", storeConf.Dedupe.Window, "Number of recent records checked by the window dedupe mode")
+	flag.Var(dedupePolicy, "dedupe-policy", "Dedupe policy e.g. prefix=events/,mode=window,window=50. Can be repeated")
+	flag.DurationVar(&storeConf.Delete.Grace, "delete-grace", storeConf.Delete.Grace, "How long a deleted key can be undeleted before its data is removed")
+	flag.IntVar(&storeConf.Read.MaxPrefixKeys, "max-prefix-keys", storeConf.Read.MaxPrefixKeys, "Most keys a read of a prefix merges; 0 doesn't limit them")
 
 	// TODO: add a flag for the list of partitions to consume. Right now only partion zero is consumed.
 
 	flag.Parse()
 
-	store, err := astore.NewReadWriteableStore(storeDir)
+	if txlogEnabled {
+		storeConf.WriteMode = astore.WRITE_MODE_TXLOG
+	}
+	c, err := astore.ParseCodec(codec)
 	if err != nil {
-		log.Fatalln("Error initializing the store:", err)
+		log.Fatalln("Invalid -codec:", err)
+	}
+	storeConf.Key.Codec = c
+	storeConf.Retention.Policies = retention.policies
+	if storeConf.Dedupe.Mode, err = astore.ParseDedupeMode(dedupe); err != nil {
+		log.Fatalln("Invalid -dedupe:", err)
 	}
+	storeConf.Dedupe.Policies = dedupePolicy.policies
 
+	// Purging doesn't open the store so it doesn't replay the tx log or wait on a running astored.
 	if purge {
+		if purgeToken == "" {
+			token, err := astore.RequestPurge(storeDir)
+			if err != nil {
+				log.Fatalln("Error getting a purge token:", err)
+			}
+			fmt.Println("Run again within 10 minutes with -purge-token", token, "to purge the datastore at:", storeDir)
+			os.Exit(0)
+		}
 		log.Println("Purging the datastore at:", storeDir)
-		store.Purge()
+		if err = astore.Purge(storeDir, purgeToken); err != nil {
+			log.Fatalln("Error purging the store:", err)
+		}
 		log.Println("Done.")
-		os.Exit(1)
+		os.Exit(0)
 	}
 
-	var vars MuxVars = mux.Vars
-
-	r := mux.NewRouter()
-	r.NotFoundHandler = Handle404{}
+	store, err := astore.NewReadWriteableStoreWithConfig(storeDir, storeConf)
+	if err != nil {
+		log.Fatalln("Error initializing the store:", err)
+	}
 
-	r.Handle("/v1/keys/{key}", NewAppendHandler(store, vars)).Methods("POST")
-	r.Handle("/v1/keys/{key}", NewReadallHandler(store, vars)).Methods("GET")
+	r := newRouter(store)
 
 	log.Println("Starting ...")
 	log.Println("Listening on:", listenAddr)
 	log.Println("Store directory:", storeDir)
+	if txlogEnabled {
+		log.Println("Writing through the transaction log. Rotate interval:", storeConf.TxLog.RotateInterval)
+	}
+	for _, p := range retention.policies {
+		log.Println("Retention policy:", p.String())
+	}
+	log.Println("Dedupe mode:", storeConf.Dedupe.Mode)
+	for _, p := range dedupePolicy.policies {
+		log.Println("Dedupe policy:", p.String())
+	}
 
 	if kafkaEnabled {
 		kafkaTopic = strings.TrimSpace(kafkaTopic)
@@ -88,6 +142,52 @@ func main() {
 
 }
 
+// newRouter returns the router for the API served from store.
+func newRouter(store astore.ReadWriteableStore) *mux.Router {
+
+	var vars MuxVars = mux.Vars
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = Handle404{}
+
+	r.Handle("/v1/keys", NewListKeysHandler(store)).Methods("GET")
+	// Keys can contain '/'. A path ending in '/' reads every key under it and the operations in
+	// reservedKeySuffixes are added to the key's path, so those routes have to come before the key's.
+	r.Handle("/v1/keys/{key:.+}/seal", NewSealHandler(store, vars)).Methods("POST")
+	r.Handle("/v1/keys/{key:.+}/retract", NewRetractHandler(store, vars)).Methods("POST")
+	r.Handle("/v1/keys/{key:.+}/stream", NewStreamHandler(store, vars)).Methods("GET")
+	r.Handle("/v1/keys/{key:.+}/meta", NewKeyMetaHandler(store, vars)).Methods("GET")
+	r.Handle("/v1/keys/{key:.+}/attributes", NewAttributesHandler(store, vars)).Methods("GET", "PUT")
+	r.Handle("/v1/keys/{prefix:.+/}", NewReadPrefixHandler(store, vars)).Methods("GET")
+	r.Handle("/v1/keys/{key:.+}", NewAppendHandler(store, vars)).Methods("POST")
+	r.Handle("/v1/keys/{key:.+}", NewReadallHandler(store, vars)).Methods("GET")
+	r.Handle("/v1/keys/{key:.+}", NewKeyMetaHandler(store, vars)).Methods("HEAD")
+	r.Handle("/v1/keys/{key:.+}", NewDeleteKeyHandler(store, vars)).Methods("DELETE")
+	r.Handle("/v1/deleted", NewDeletedKeysHandler(store, vars)).Methods("GET")
+	r.Handle("/v1/deleted/{key:.+}", NewDeletedKeysHandler(store, vars)).Methods("POST")
+	r.Handle("/v1/changes", NewChangesHandler(store)).Methods("GET")
+	return r
+}
+
+// reservedKeySuffixes are the operations on a key that are added to its path, like
+// /v1/keys/{key}/meta. Their routes shadow the keys whose last element is one of them, so those
+// keys can't be appended to.
+var reservedKeySuffixes = []string{"seal", "retract", "stream", "meta", "attributes"}
+
+// validKey reports if key can be appended to. Keys ending in '/' name a prefix and keys ending in
+// one of the reservedKeySuffixes can't be read back.
+func validKey(key string) bool {
+	if strings.HasSuffix(key, astore.KEY_SEPARATOR) {
+		return false
+	}
+	for _, suffix := range reservedKeySuffixes {
+		if strings.HasSuffix(key, astore.KEY_SEPARATOR+suffix) {
+			return false
+		}
+	}
+	return true
+}
+
 // RequestVars is the interface implemented by objects that know how to parse parameters
 // out of the request (URL etc)
 type RequestVars interface {
@@ -166,3 +266,123 @@ func (fkb *flagKafkaBrokers) Set(value string) error {
 func (fkb *flagKafkaBrokers) String() string {
 	return strings.Join(fkb.brokers, KAFKA_BROKER_SEP)
 }
+
+// flagRetention implements the flag.Value interface to collect retention policies from repeated
+// commandline flags.
+type flagRetention struct {
+	policies []astore.RetentionPolicy
+}
+
+// Set expects the format of value to be "key=name" or "prefix=p" followed by the limits, all
+// separated by commas e.g. "prefix=logs/,max-records=1000,max-bytes=1048576,max-age=24h,mode=trim".
+// The mode is reject if it isn't set.
+func (fr *flagRetention) Set(value string) error {
+	p := astore.RetentionPolicy{}
+	named := false
+	for _, field := range strings.Split(strings.TrimSpace(value), ",") {
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Invalid retention field '%s': missing '='", field)
+		}
+		var err error
+		switch kv[0] {
+		case "key":
+			p.Key, named = kv[1], true
+		case "prefix":
+			p.Prefix, named = kv[1], true
+		case "max-records":
+			p.MaxRecords, err = strconv.ParseUint(kv[1], 10, 64)
+		case "max-bytes":
+			p.MaxBytes, err = strconv.ParseUint(kv[1], 10, 64)
+		case "max-age":
+			p.MaxAge, err = time.ParseDuration(kv[1])
+		case "mode":
+			p.Mode, err = astore.ParseRetentionMode(kv[1])
+		default:
+			return fmt.Errorf("Unknown retention field '%s'", kv[0])
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid retention field '%s': %s", field, err)
+		}
+	}
+	if !named {
+		return fmt.Errorf("Retention policy needs a key or prefix")
+	}
+	if p.MaxRecords == 0 && p.MaxBytes == 0 && p.MaxAge == 0 {
+		return fmt.Errorf("Retention policy has no limits")
+	}
+	if p.Mode == astore.RETENTION_REJECT && p.MaxAge > 0 {
+		return fmt.Errorf("max-age is only enforced with mode=trim")
+	}
+	fr.policies = append(fr.policies, p)
+	return nil
+}
+
+func (fr *flagRetention) String() string {
+	policies := make([]string, len(fr.policies))
+	for i := range fr.policies {
+		policies[i] = fr.policies[i].String()
+	}
+	return strings.Join(policies, "; ")
+}
+
+// flagDedupe implements the flag.Value interface to collect dedupe policies from repeated
+// commandline flags.
+type flagDedupe struct {
+	policies []astore.DedupePolicy
+}
+
+// Set expects the format of value to be "key=name" or "prefix=p" followed by the mode and, for
+// the window mode, the window, all separated by commas e.g. "prefix=events/,mode=window,window=50".
+func (fd *flagDedupe) Set(value string) error {
+	p := astore.DedupePolicy{}
+	named, moded := false, false
+	for _, field := range strings.Split(strings.TrimSpace(value), ",") {
+		if field == "" {
+			continue
+		}
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("Invalid dedupe field '%s': missing '='", field)
+		}
+		var err error
+		switch kv[0] {
+		case "key":
+			p.Key, named = kv[1], true
+		case "prefix":
+			p.Prefix, named = kv[1], true
+		case "mode":
+			p.Mode, err = astore.ParseDedupeMode(kv[1])
+			moded = true
+		case "window":
+			p.Window, err = strconv.ParseUint(kv[1], 10, 64)
+		default:
+			return fmt.Errorf("Unknown dedupe field '%s'", kv[0])
+		}
+		if err != nil {
+			return fmt.Errorf("Invalid dedupe field '%s': %s", field, err)
+		}
+	}
+	if !named {
+		return fmt.Errorf("Dedupe policy needs a key or prefix")
+	}
+	if !moded {
+		return fmt.Errorf("Dedupe policy needs a mode")
+	}
+	if p.Window > 0 && p.Mode != astore.DEDUPE_WINDOW {
+		return fmt.Errorf("window is only used with mode=window")
+	}
+	fd.policies = append(fd.policies, p)
+	return nil
+}
+
+func (fd *flagDedupe) String() string {
+	policies := make([]string, len(fd.policies))
+	for i := range fd.policies {
+		policies[i] = fd.policies[i].String()
+	}
+	return strings.Join(policies, "; ")
+}
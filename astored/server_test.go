@@ -1,8 +1,15 @@
 package main
 
 import (
+	"fmt"
+	"net/http"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/skyec/astore"
 )
 
 type fixtureBroker struct {
@@ -43,3 +50,130 @@ func TestKafkaBrokersFlag(t *testing.T) {
 		}
 	}
 }
+
+func TestRetentionFlag(t *testing.T) {
+	fixtures := []struct {
+		in          string
+		out         astore.RetentionPolicy
+		expectError bool
+	}{
+		{"", astore.RetentionPolicy{}, true},
+		{"prefix=logs/", astore.RetentionPolicy{}, true},
+		{"max-records=10", astore.RetentionPolicy{}, true},
+		{"prefix=logs/,max-records=ten", astore.RetentionPolicy{}, true},
+		{"prefix=logs/,size=10", astore.RetentionPolicy{}, true},
+		{"prefix=logs/,max-records", astore.RetentionPolicy{}, true},
+		{"prefix=logs/,max-age=1h", astore.RetentionPolicy{}, true},
+		{"prefix=logs/,max-records=10,mode=drop", astore.RetentionPolicy{}, true},
+		{"key=a,max-records=10", astore.RetentionPolicy{Key: "a", MaxRecords: 10}, false},
+		{" prefix=logs/,max-bytes=1024,max-age=24h,mode=trim ",
+			astore.RetentionPolicy{Prefix: "logs/", MaxBytes: 1024, MaxAge: 24 * time.Hour, Mode: astore.RETENTION_TRIM}, false},
+		{"prefix=,max-records=5,mode=reject", astore.RetentionPolicy{MaxRecords: 5}, false},
+	}
+
+	for _, fix := range fixtures {
+		fr := &flagRetention{}
+		err := fr.Set(fix.in)
+		if fix.expectError {
+			if err == nil {
+				t.Errorf("Failed: '%s'. Expected error, got none.", fix.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed: '%s'. Unexpected error: %s", fix.in, err)
+			continue
+		}
+		if len(fr.policies) != 1 || fr.policies[0] != fix.out {
+			t.Errorf("Failed: '%s'. Expected: %+v, got: %+v", fix.in, fix.out, fr.policies)
+		}
+	}
+}
+
+func TestDedupeFlag(t *testing.T) {
+	fixtures := []struct {
+		in          string
+		out         astore.DedupePolicy
+		expectError bool
+	}{
+		{"", astore.DedupePolicy{}, true},
+		{"prefix=events/", astore.DedupePolicy{}, true},
+		{"mode=none", astore.DedupePolicy{}, true},
+		{"prefix=events/,mode=sometimes", astore.DedupePolicy{}, true},
+		{"prefix=events/,mode=window,window=ten", astore.DedupePolicy{}, true},
+		{"prefix=events/,mode=id,window=10", astore.DedupePolicy{}, true},
+		{"prefix=events/,mode", astore.DedupePolicy{}, true},
+		{"prefix=events/,ttl=1h", astore.DedupePolicy{}, true},
+		{"key=a,mode=none", astore.DedupePolicy{Key: "a", Mode: astore.DEDUPE_NONE}, false},
+		{" prefix=events/,mode=window,window=50 ",
+			astore.DedupePolicy{Prefix: "events/", Mode: astore.DEDUPE_WINDOW, Window: 50}, false},
+		{"prefix=orders/,mode=id", astore.DedupePolicy{Prefix: "orders/", Mode: astore.DEDUPE_ID}, false},
+	}
+
+	for _, fix := range fixtures {
+		fd := &flagDedupe{}
+		err := fd.Set(fix.in)
+		if fix.expectError {
+			if err == nil {
+				t.Errorf("Failed: '%s'. Expected error, got none.", fix.in)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Failed: '%s'. Unexpected error: %s", fix.in, err)
+			continue
+		}
+		if len(fd.policies) != 1 || fd.policies[0] != fix.out {
+			t.Errorf("Failed: '%s'. Expected: %+v, got: %+v", fix.in, fix.out, fd.policies)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	r := newRouter(nil)
+
+	fixtures := []struct {
+		method, path string
+		handler      string
+		vars         map[string]string
+	}{
+		{"POST", "/v1/keys/tenant/42/orders/7", "*main.AppendHandler", map[string]string{"key": "tenant/42/orders/7"}},
+		{"GET", "/v1/keys/tenant/42/orders/7", "*main.HandlerReadAll", map[string]string{"key": "tenant/42/orders/7"}},
+		{"GET", "/v1/keys/plain", "*main.HandlerReadAll", map[string]string{"key": "plain"}},
+		{"GET", "/v1/keys/tenant/42/", "*main.HandlerReadPrefix", map[string]string{"prefix": "tenant/42/"}},
+		{"GET", "/v1/keys/tenant/42/orders/7/stream", "*main.HandlerStream", map[string]string{"key": "tenant/42/orders/7"}},
+		{"GET", "/v1/keys/tenant/42/orders/7/meta", "*main.HandlerKeyMeta", map[string]string{"key": "tenant/42/orders/7"}},
+		{"HEAD", "/v1/keys/tenant/42/orders/7", "*main.HandlerKeyMeta", map[string]string{"key": "tenant/42/orders/7"}},
+		{"PUT", "/v1/keys/tenant/42/attributes", "*main.HandlerAttributes", map[string]string{"key": "tenant/42"}},
+		{"GET", "/v1/keys", "*main.HandlerListKeys", map[string]string{}},
+		{"POST", "/v1/keys/tenant/42/orders/7/seal", "*main.HandlerSeal", map[string]string{"key": "tenant/42/orders/7"}},
+		{"DELETE", "/v1/keys/tenant/42/orders/7", "*main.HandlerDeleteKey", map[string]string{"key": "tenant/42/orders/7"}},
+		{"GET", "/v1/deleted", "*main.HandlerDeletedKeys", map[string]string{}},
+		{"POST", "/v1/deleted/tenant/42/orders/7", "*main.HandlerDeletedKeys", map[string]string{"key": "tenant/42/orders/7"}},
+		{"POST", "/v1/keys/tenant/42/orders/7/retract", "*main.HandlerRetract", map[string]string{"key": "tenant/42/orders/7"}},
+		{"GET", "/v1/keys/tenant/42/attributes", "*main.HandlerAttributes", map[string]string{"key": "tenant/42"}},
+		// the operations' suffixes are reserved; a key ending in one can't be reached
+		{"GET", "/v1/keys/jobs/stream", "*main.HandlerStream", map[string]string{"key": "jobs"}},
+		{"GET", "/v1/keys/docs/meta", "*main.HandlerKeyMeta", map[string]string{"key": "docs"}},
+		{"GET", "/v1/keys/users/attributes", "*main.HandlerAttributes", map[string]string{"key": "users"}},
+		{"POST", "/v1/keys/envelopes/seal", "*main.HandlerSeal", map[string]string{"key": "envelopes"}},
+		{"POST", "/v1/keys/orders/retract", "*main.HandlerRetract", map[string]string{"key": "orders"}},
+		// but a key that's just the name of one is fine
+		{"GET", "/v1/keys/stream", "*main.HandlerReadAll", map[string]string{"key": "stream"}},
+		{"POST", "/v1/keys/seal", "*main.AppendHandler", map[string]string{"key": "seal"}},
+	}
+	for _, fix := range fixtures {
+		req, _ := http.NewRequest(fix.method, "http://localhost"+fix.path, nil)
+		match := &mux.RouteMatch{}
+		if !r.Match(req, match) {
+			t.Errorf("%s %s didn't match a route", fix.method, fix.path)
+			continue
+		}
+		if h := fmt.Sprintf("%T", match.Handler); h != fix.handler {
+			t.Errorf("%s %s: expected handler %s, got: %s", fix.method, fix.path, fix.handler, h)
+		}
+		if !reflect.DeepEqual(match.Vars, fix.vars) {
+			t.Errorf("%s %s: expected vars %v, got: %v", fix.method, fix.path, fix.vars, match.Vars)
+		}
+	}
+}
@@ -13,6 +13,9 @@ func helpNewRequestResponse(rb, wb *bytes.Buffer) (*http.Request, *httptest.Resp
 
 	r := &http.Request{}
 	r.Body = ioutil.NopCloser(rb)
+	if rb != nil {
+		r.ContentLength = int64(rb.Len())
+	}
 
 	if r.Header == nil {
 		r.Header = map[string][]string{}
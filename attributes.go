@@ -0,0 +1,59 @@
+package astore
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/skyec/astore/metastore"
+)
+
+// ATTRIBUTES_PREFIX is the prefix of the metastore keys that hold the attributes of a key. The
+// rest of the metastore key is the hash of the key's name.
+const ATTRIBUTES_PREFIX = "attributes/"
+
+// keyAttributes keeps the attributes of the keys in the store's metastore. Attributes are
+// name/value pairs like owner or schema that can be changed at any time, unlike the records.
+type keyAttributes struct {
+	kv metastore.KVStore
+}
+
+func attributesKey(hk hashableKey) []byte {
+	return []byte(ATTRIBUTES_PREFIX + hk.String())
+}
+
+// get returns the attributes of hk. A key without attributes gets an empty map.
+func (ka *keyAttributes) get(hk hashableKey) (map[string]string, error) {
+
+	attrs := map[string]string{}
+	b, err := ka.kv.Get(attributesKey(hk))
+	if err != nil || len(b) == 0 {
+		return attrs, err
+	}
+	if err = json.Unmarshal(b, &attrs); err != nil {
+		return nil, fmt.Errorf("bad attributes for key %s: %s", hk, err)
+	}
+	return attrs, nil
+}
+
+// set replaces the attributes of hk.
+func (ka *keyAttributes) set(hk hashableKey, attrs map[string]string) error {
+
+	if len(attrs) == 0 {
+		return ka.kv.Delete(attributesKey(hk))
+	}
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	return ka.kv.Put(attributesKey(hk), b)
+}
+
+// attributesMatch reports if attrs has every name/value pair in match.
+func attributesMatch(attrs, match map[string]string) bool {
+	for name, value := range match {
+		if v, ok := attrs[name]; !ok || v != value {
+			return false
+		}
+	}
+	return true
+}
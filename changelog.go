@@ -0,0 +1,309 @@
+package astore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const changeEntrySize = 64
+
+// A commit whose change can't be added to the feed retries after changeLogRetryMin, doubling the
+// wait up to changeLogRetryMax.
+const (
+	changeLogRetryMin = 10 * time.Millisecond
+	changeLogRetryMax = 5 * time.Second
+)
+
+// changeLogRecoveryWindow is how long before the last change in the feed a record left out of it
+// by a crash can have been appended. See store.recoverChanges.
+const changeLogRecoveryWindow = time.Minute
+
+// errChangeLogClosed is returned for changes added after the change log was closed.
+var errChangeLogClosed = errors.New("the change log is closed")
+
+// Change is an entry in the store's change feed. Every record appended to the store gets one.
+type Change struct {
+	Seq       uint64    // position in the feed, starting at 1
+	Key       string    // hex encoded SHA1 of the key the record was appended to
+	Index     uint64    // sequence number of the record in its key
+	Hash      string    // hex encoded SHA1 of the record
+	Timestamp time.Time // when the record was appended
+}
+
+// ChangeFunc is called with each change read from the change feed.
+type ChangeFunc func(c *Change) error
+
+// changeEntry is the on disk form of a Change.
+type changeEntry struct {
+	Seq       uint64
+	Key       [20]byte
+	Index     uint64
+	Hash      [20]byte
+	Timestamp int64
+}
+
+// changeLog is the store's change feed. It's a file of fixed size entries, in the order the records
+// were committed to their keys, so the change with sequence number N is entry N-1.
+//
+// An entry is added after the record has been committed to its key. The records a crash in between
+// leaves out of the feed are added when the store is opened again. Entries are written under mu but synced outside of it so that
+// concurrent commits share an fsync, and readers only see the entries that have been synced.
+type changeLog struct {
+	fileName    string
+	syncEnabled bool
+	mu          sync.Mutex
+	file        *os.File
+	next        uint64 // sequence number of the next change
+	torn        bool   // a failed write may have left part of an entry at the end of file
+
+	syncMu sync.Mutex
+	synced uint64 // sequence number of the newest change that's on disk
+}
+
+// openChangeLog opens the change feed in fileName. A partial entry left at the end by a crash is
+// removed.
+func openChangeLog(fileName string) (*changeLog, error) {
+
+	cl := &changeLog{
+		fileName:    fileName,
+		syncEnabled: len(os.Getenv("DISABLE_ASTORE_FSYNC")) == 0,
+	}
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_RDWR, defaultFilePermisions)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	size := fi.Size() - fi.Size()%changeEntrySize
+	if size != fi.Size() {
+		if err = file.Truncate(size); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("error truncating the change log: %s", err)
+		}
+	}
+	if _, err = file.Seek(size, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	cl.file = file
+	cl.next = uint64(size/changeEntrySize) + 1
+	cl.synced = cl.next - 1
+	return cl, nil
+}
+
+// add assigns the next sequence number to rec and adds it to the feed. It returns once the entry
+// is on disk.
+func (cl *changeLog) add(rec *appendedRecord) (uint64, error) {
+
+	entry := &changeEntry{
+		Index:     rec.seq,
+		Timestamp: rec.timestamp.UnixNano(),
+	}
+	copy(entry.Key[:], rec.key.Get())
+	if _, err := hex.Decode(entry.Hash[:], []byte(rec.hash)); err != nil {
+		return 0, fmt.Errorf("error decoding record hash: %s", err)
+	}
+
+	cl.mu.Lock()
+	if cl.file == nil {
+		cl.mu.Unlock()
+		return 0, errChangeLogClosed
+	}
+	entry.Seq = cl.next
+	buf := &bytes.Buffer{}
+	binary.Write(buf, binary.LittleEndian, entry)
+	err := cl.trimTorn()
+	if err == nil {
+		if _, err = cl.file.Write(buf.Bytes()); err != nil {
+			cl.torn = true
+			cl.trimTorn()
+		}
+	}
+	if err != nil {
+		cl.mu.Unlock()
+		return 0, fmt.Errorf("error writing the change log: %s", err)
+	}
+	cl.next++
+	cl.mu.Unlock()
+
+	if err = cl.sync(entry.Seq); err != nil {
+		return 0, fmt.Errorf("error syncing the change log: %s", err)
+	}
+	return entry.Seq, nil
+}
+
+// trimTorn drops whatever part of an entry a failed write left at the end of the file so that the
+// next one starts on an entry boundary. It's called with mu held.
+func (cl *changeLog) trimTorn() error {
+	if !cl.torn {
+		return nil
+	}
+	offset := int64(cl.next-1) * changeEntrySize
+	if err := cl.file.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err := cl.file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	cl.torn = false
+	return nil
+}
+
+// sync makes sure the changes up to seq are on disk. Whoever gets syncMu first syncs every entry
+// written so far, so the commits waiting behind it usually find their entries already synced.
+func (cl *changeLog) sync(seq uint64) error {
+	cl.syncMu.Lock()
+	defer cl.syncMu.Unlock()
+
+	if cl.synced >= seq {
+		return nil
+	}
+	cl.mu.Lock()
+	upto, file := cl.next-1, cl.file
+	cl.mu.Unlock()
+	if file == nil {
+		return errChangeLogClosed
+	}
+	if cl.syncEnabled {
+		if err := file.Sync(); err != nil {
+			return err
+		}
+	}
+	cl.synced = upto
+	return nil
+}
+
+// last returns the sequence number of the newest change that's on disk, 0 if there aren't any.
+func (cl *changeLog) last() uint64 {
+	cl.syncMu.Lock()
+	defer cl.syncMu.Unlock()
+	return cl.synced
+}
+
+// lastEntry returns the newest change that's on disk, nil if there aren't any.
+func (cl *changeLog) lastEntry() (*changeEntry, error) {
+
+	last := cl.last()
+	if last == 0 {
+		return nil, nil
+	}
+	entries, err := cl.readEntries(last-1, 1)
+	if err != nil {
+		return nil, err
+	}
+	return entries[0], nil
+}
+
+// readBack calls f with the changes on disk, newest first, until it gets to one from before since.
+func (cl *changeLog) readBack(since time.Time, f func(e *changeEntry) error) error {
+
+	const batch = 1024
+	for end := cl.last(); end > 0; {
+		start := uint64(0)
+		if end > batch {
+			start = end - batch
+		}
+		entries, err := cl.readEntries(start, int(end-start))
+		if err != nil {
+			return err
+		}
+		for i := len(entries) - 1; i >= 0; i-- {
+			if entries[i].Timestamp < since.UnixNano() {
+				return nil
+			}
+			if err = f(entries[i]); err != nil {
+				return err
+			}
+		}
+		end = start
+	}
+	return nil
+}
+
+// readEntries reads n entries starting with entry i, the change with sequence number i+1.
+func (cl *changeLog) readEntries(i uint64, n int) ([]*changeEntry, error) {
+
+	cl.mu.Lock()
+	file := cl.file
+	cl.mu.Unlock()
+	if file == nil {
+		return nil, errChangeLogClosed
+	}
+	b := make([]byte, n*changeEntrySize)
+	if _, err := file.ReadAt(b, int64(i)*changeEntrySize); err != nil {
+		return nil, fmt.Errorf("error reading the change log: %s", err)
+	}
+	r := bytes.NewReader(b)
+	entries := make([]*changeEntry, n)
+	for j := range entries {
+		entries[j] = &changeEntry{}
+		if err := binary.Read(r, binary.LittleEndian, entries[j]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// close closes the change log's file. Changes can't be added after that.
+func (cl *changeLog) close() error {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+
+	if cl.file == nil {
+		return nil
+	}
+	err := cl.file.Close()
+	cl.file = nil
+	return err
+}
+
+// readSince calls f for up to limit changes after the change with sequence number since. A limit
+// <= 0 reads to the end of the feed.
+func (cl *changeLog) readSince(since uint64, limit int, f ChangeFunc) error {
+
+	// Only synced entries are read; later ones may be being written.
+	end := cl.last()
+	if since >= end {
+		return nil
+	}
+
+	file, err := os.Open(cl.fileName)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(int64(since)*changeEntrySize, io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(file)
+	entry := &changeEntry{}
+	for n := 0; since+uint64(n) < end && (limit <= 0 || n < limit); n++ {
+		if err = binary.Read(r, binary.LittleEndian, entry); err != nil {
+			return fmt.Errorf("error reading the change log: %s", err)
+		}
+		err = f(&Change{
+			Seq:       entry.Seq,
+			Key:       strings.ToUpper(hex.EncodeToString(entry.Key[:])),
+			Index:     entry.Index,
+			Hash:      strings.ToUpper(hex.EncodeToString(entry.Hash[:])),
+			Timestamp: time.Unix(0, entry.Timestamp),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package astore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	"github.com/golang/snappy/snappy"
+)
+
+// Codec identifies how a content block's payload is compressed on disk.
+type Codec uint8
+
+const (
+	CODEC_NONE   Codec = iota // stored as is
+	CODEC_SNAPPY              // snappy framing format
+	CODEC_GZIP                // gzip
+)
+
+var codecNames = map[Codec]string{
+	CODEC_NONE:   "none",
+	CODEC_SNAPPY: "snappy",
+	CODEC_GZIP:   "gzip",
+}
+
+func (c Codec) String() string {
+	if name, ok := codecNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("codec(%d)", uint8(c))
+}
+
+// ParseCodec returns the Codec called name.
+func ParseCodec(name string) (Codec, error) {
+	for c, n := range codecNames {
+		if n == name {
+			return c, nil
+		}
+	}
+	return CODEC_NONE, fmt.Errorf("unknown codec: %s", name)
+}
+
+// encodeBlock compresses data with codec if it's larger than MIN_GZ_SIZE. The payload to store
+// and the codec it was stored with are returned. Payloads that don't get smaller are stored
+// as is.
+func encodeBlock(codec Codec, data []byte) ([]byte, Codec, error) {
+
+	if codec == CODEC_NONE || len(data) <= MIN_GZ_SIZE {
+		return data, CODEC_NONE, nil
+	}
+
+	buff := &bytes.Buffer{}
+	w, err := newBlockEncoder(codec, buff)
+	if err != nil {
+		return nil, CODEC_NONE, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, CODEC_NONE, fmt.Errorf("error compressing content: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		return nil, CODEC_NONE, fmt.Errorf("error compressing content: %s", err)
+	}
+	if buff.Len() >= len(data) {
+		return data, CODEC_NONE, nil
+	}
+	return buff.Bytes(), codec, nil
+}
+
+// newBlockEncoder returns a writer that compresses to w with codec. It must be closed to flush
+// the compressed stream.
+func newBlockEncoder(codec Codec, w io.Writer) (io.WriteCloser, error) {
+	switch codec {
+	case CODEC_NONE:
+		return nopWriteCloser{w}, nil
+	case CODEC_SNAPPY:
+		return nopWriteCloser{snappy.NewWriter(w)}, nil
+	case CODEC_GZIP:
+		return gzip.NewWriter(w), nil
+	}
+	return nil, fmt.Errorf("unknown codec: %s", codec)
+}
+
+// decodeBlock returns a reader for the uncompressed payload of a block stored with codec.
+func decodeBlock(codec Codec, stored []byte) (io.Reader, error) {
+	switch codec {
+	case CODEC_NONE:
+		return bytes.NewReader(stored), nil
+	case CODEC_SNAPPY:
+		return snappy.NewReader(bytes.NewReader(stored)), nil
+	case CODEC_GZIP:
+		return gzip.NewReader(bytes.NewReader(stored))
+	}
+	return nil, fmt.Errorf("unknown codec: %s", codec)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,92 @@
+package astore
+
+import "time"
+
+// WriteMode selects the path appends take on their way to a key.
+type WriteMode int
+
+const (
+	WRITE_MODE_DIRECT WriteMode = iota // appends are written straight to the key
+	WRITE_MODE_TXLOG                   // appends are written to the transaction log and committed later
+)
+
+const (
+	defaultTxLogRotateInterval = 100 * time.Millisecond
+	defaultTxLogCommitters     = 4
+	defaultTxLogWriteBuffer    = 256
+	defaultKeySegmentSize      = 64 * 1024 * 1024
+	defaultWatchBuffer         = 64
+	defaultWatchSendTimeout    = 30 * time.Second
+	defaultRetentionInterval   = time.Minute
+	defaultReclaimInterval     = time.Minute
+	defaultDedupeWindow        = 100
+	defaultMaxPrefixKeys       = 1000
+)
+
+// Config holds the settings used to open a store. Use NewConfig to get a Config populated
+// with the defaults.
+type Config struct {
+	WriteMode WriteMode
+
+	// Settings for the transaction log write path. See WRITELOG.md.
+	TxLog struct {
+		RotateInterval time.Duration // how often writing/tx.log is rotated and handed to the committers
+		Committers     int           // number of key committer goroutines
+		WriteBuffer    int           // size of the buffered write channel; also the largest write batch
+	}
+
+	// Settings for the data files of each key.
+	Key struct {
+		SegmentSize int64 // a content segment is sealed and a new one started once it reaches this size
+		Codec       Codec // codec used to compress payloads larger than MIN_GZ_SIZE
+		LockFiles   bool  // changes to a key also take an flock on its lock file so other processes wait
+	}
+
+	// Settings for Watch subscriptions.
+	Watch struct {
+		Buffer      int           // number of records buffered for each subscriber
+		SendTimeout time.Duration // a subscriber whose buffer stays full this long is dropped; 0 waits forever
+	}
+
+	// Retention policies limiting the size of keys. See RetentionPolicy.
+	Retention struct {
+		Policies []RetentionPolicy
+		Interval time.Duration // how often the keys with a RETENTION_TRIM policy are trimmed
+	}
+
+	// How appends are checked for duplicates. See DedupePolicy.
+	Dedupe struct {
+		Mode     DedupeMode // for keys without a policy
+		Window   uint64     // number of records DEDUPE_WINDOW checks unless the policy sets it
+		Policies []DedupePolicy
+	}
+
+	// Settings for deleted keys. See store.DeleteKey.
+	Delete struct {
+		Grace           time.Duration // how long a deleted key can be undeleted before it's reclaimed
+		ReclaimInterval time.Duration // how often the directories of deleted keys are reclaimed
+	}
+
+	// Settings for reads.
+	Read struct {
+		MaxPrefixKeys int // most keys ReadPrefix merges; more fail with ErrTooManyPrefixKeys. 0 doesn't limit them
+	}
+}
+
+func NewConfig() *Config {
+	conf := &Config{
+		WriteMode: WRITE_MODE_DIRECT,
+	}
+	conf.TxLog.RotateInterval = defaultTxLogRotateInterval
+	conf.TxLog.Committers = defaultTxLogCommitters
+	conf.TxLog.WriteBuffer = defaultTxLogWriteBuffer
+	conf.Key.SegmentSize = defaultKeySegmentSize
+	conf.Key.Codec = CODEC_SNAPPY
+	conf.Watch.Buffer = defaultWatchBuffer
+	conf.Watch.SendTimeout = defaultWatchSendTimeout
+	conf.Retention.Interval = defaultRetentionInterval
+	conf.Dedupe.Window = defaultDedupeWindow
+	conf.Delete.ReclaimInterval = defaultReclaimInterval
+	conf.Read.MaxPrefixKeys = defaultMaxPrefixKeys
+	return conf
+}
@@ -76,7 +76,8 @@ func (k *Kafka) Run() (err error) {
 			case msg := <-k.pconsumer.Messages():
 
 				logConsummedMessage(msg)
-				err := k.store.WriteToKey(string(msg.Key), msg.Value)
+				// Keys with a DEDUPE_ID policy skip messages that are delivered again
+				_, err := k.store.WriteToKeyWithOptions(string(msg.Key), msg.Value, &astore.WriteOptions{ID: messageID(msg)})
 
 				// TODO: what to do with failed message?
 				//       - don't store the offset
@@ -111,6 +112,12 @@ func (k *Kafka) Offset() int64 {
 	return k.offset
 }
 
+// messageID returns the idempotency ID of msg. A message redelivered after a restart has the same
+// topic, partition and offset.
+func messageID(msg *sarama.ConsumerMessage) string {
+	return fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset)
+}
+
 func logConsummedMessage(msg *sarama.ConsumerMessage) {
 	log.Printf("CONSUMED '%s' %d %d '%s'",
 		msg.Topic,
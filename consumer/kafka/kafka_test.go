@@ -2,17 +2,22 @@ package kafka
 
 import (
 	"bytes"
+	"io"
+	"io/ioutil"
 	"log"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/Shopify/sarama"
 	"github.com/Shopify/sarama/mocks"
+	"github.com/skyec/astore"
 )
 
 // implements the WriteableStore interface
 type mocStore struct {
 	data map[string][][]byte
+	ids  map[string][]string
 	err  error
 	kv   map[string][]byte
 }
@@ -34,6 +39,35 @@ func (s *mocStore) WriteToKey(key string, data []byte) error {
 	return s.err
 }
 
+func (s *mocStore) WriteStreamToKey(key string, r io.Reader, size int64) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return s.WriteToKey(key, data)
+}
+
+func (s *mocStore) WriteToKeyWithOptions(key string, data []byte, opts *astore.WriteOptions) (astore.WriteStatus, error) {
+	if err := s.WriteToKey(key, data); err != nil {
+		return astore.WRITE_APPENDED, err
+	}
+	if s.ids == nil {
+		s.ids = map[string][]string{}
+	}
+	if opts != nil {
+		s.ids[key] = append(s.ids[key], opts.ID)
+	}
+	return astore.WRITE_APPENDED, nil
+}
+
+func (s *mocStore) WriteStreamToKeyWithOptions(key string, r io.Reader, size int64, opts *astore.WriteOptions) (astore.WriteStatus, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return astore.WRITE_APPENDED, err
+	}
+	return s.WriteToKeyWithOptions(key, data, opts)
+}
+
 func (s *mocStore) GetMeta(key []byte) []byte {
 	if s.kv == nil {
 		return []byte{}
@@ -49,8 +83,12 @@ func (s *mocStore) PutMeta(key, value []byte) {
 	s.kv[string(key)] = value
 }
 
-func (s *mocStore) Purge() {
+func (s *mocStore) Purge(token string) error {
+	return nil
+}
 
+func (s *mocStore) RequestPurge() (string, error) {
+	return "", nil
 }
 
 func (s *mocStore) Initialize() error {
@@ -159,6 +197,10 @@ func TestKafkaConsumer(t *testing.T) {
 	if !bytes.Equal(keyData[0], value) {
 		t.Errorf("expected: %s\ngot: %s", value, keyData[0])
 	}
+	// the mock consumer sets the offsets
+	if ids := store.ids[key]; len(ids) != 1 || !strings.HasPrefix(ids[0], "test1/0/") {
+		t.Errorf("expected idempotency ID test1/0/<offset>, got: %v", ids)
+	}
 }
 
 func TestPersistOffset(t *testing.T) {
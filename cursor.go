@@ -0,0 +1,448 @@
+package astore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+// Cursor reads the records in a key in the order they were appended:
+//
+//	c, err := st.OpenCursor(key, astore.Position{})
+//	...
+//	defer c.Close()
+//	for c.Next() {
+//		process(c.Record())
+//	}
+//	if err = c.Err(); err != nil {
+//		...
+//	}
+//
+// A Cursor stops at the end of the key as it was when the end was reached. Open a new one at
+// Position() to pick up records appended after that.
+type Cursor interface {
+	// Next advances to the next record. It returns false at the end of the key or if reading
+	// fails; Err tells the two apart.
+	Next() bool
+
+	// Record returns the payload of the current record.
+	Record() []byte
+
+	// Reader returns a reader for the payload of the current record. It's only valid until Next
+	// is called.
+	Reader() io.Reader
+
+	// Position returns the position after the current record. A cursor opened there starts with
+	// the record after this one.
+	Position() Position
+
+	// Err returns the error that stopped the cursor, if any.
+	Err() error
+
+	// Close releases the files held by the cursor.
+	Close() error
+}
+
+// errSkipped is returned by readBlock for a corrupt block that was skipped.
+var errSkipped = errors.New("corrupt block skipped")
+
+// keyCursor is the Cursor for a Key. It reads the content segments in order, along with the
+// segment's hash log to fill in the hash of each record.
+type keyCursor struct {
+	k       *Key
+	opts    *ReadOptions
+	pos     Position
+	last    int    // last segment when the cursor was opened
+	end     uint64 // number of records in the key when the cursor was opened
+	file    *os.File
+	size    int64 // size of file when it was opened
+	r       *bufio.Reader
+	hlog    *os.File
+	hashes  *bufio.Reader // nil if the hash log can't be read
+	base    uint64        // number of records in the segments before the open one
+	entry   []byte
+	payload []byte
+	table   *crc64.Table
+	hidden  map[uint64]bool // records that have been retracted; nil if there aren't any
+	rec     *Record
+	done    bool
+	err     error
+}
+
+// OpenCursor returns a Cursor that reads the records in the key starting at start. The zero
+// Position is the start of the key. Blocks are verified the same way as ReadEachWithOptions does.
+// Retracted records and the retractions are skipped unless opts.IncludeRetracted is set.
+func (k *Key) OpenCursor(start Position, opts *ReadOptions) (Cursor, error) {
+	return k.openCursor(start, opts)
+}
+
+func (k *Key) openCursor(start Position, opts *ReadOptions) (*keyCursor, error) {
+	if opts == nil {
+		opts = &ReadOptions{}
+	}
+	if start == (Position{}) {
+		// the start of the key, which is later than record 0 if records have been trimmed
+		first, err := k.firstSeq()
+		if err != nil {
+			return nil, err
+		}
+		start.Seq = first
+	} else if err := k.checkPosition(start); err != nil {
+		return nil, err
+	}
+
+	// Records are only read up to the count from the hash log. A record being appended may
+	// already be in the content.
+	end, err := k.nextSeq()
+	if err != nil {
+		return nil, err
+	}
+	last, err := k.lastSegment()
+	if err != nil {
+		return nil, err
+	}
+	hidden, err := k.retractedSeqs()
+	if err != nil {
+		return nil, err
+	}
+	return &keyCursor{
+		k:      k,
+		opts:   opts,
+		pos:    start,
+		last:   last,
+		end:    end,
+		entry:  make([]byte, hashLogEntrySize),
+		table:  crc64.MakeTable(crc64.ISO),
+		hidden: hidden,
+	}, nil
+}
+
+func (c *keyCursor) Next() bool {
+	if c.done {
+		return false
+	}
+	c.rec = nil
+
+	for c.pos.Seq < c.end {
+		rec, err := c.readBlock()
+		switch {
+		case err == io.EOF:
+			if c.pos.Segment >= c.last {
+				c.done = true
+				return false
+			}
+			c.closeSegment()
+			c.pos = Position{Segment: c.pos.Segment + 1, Seq: c.pos.Seq}
+		case err == errSkipped:
+		case err != nil:
+			c.err = err
+			c.done = true
+			return false
+		case c.skip(rec):
+		default:
+			c.rec = rec
+			return true
+		}
+	}
+	c.done = true
+	return false
+}
+
+func (c *keyCursor) Record() []byte {
+	if c.rec == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(c.rec.Data)
+	if err != nil {
+		c.err = err
+		c.done = true
+		return nil
+	}
+	return data
+}
+
+func (c *keyCursor) Reader() io.Reader {
+	if c.rec == nil {
+		return nil
+	}
+	return c.rec.Data
+}
+
+func (c *keyCursor) Position() Position {
+	return c.pos
+}
+
+func (c *keyCursor) Err() error {
+	return c.err
+}
+
+func (c *keyCursor) Close() error {
+	c.done = true
+	return c.closeSegment()
+}
+
+// openSegment opens the segment at the cursor's position. It returns io.EOF if the segment
+// doesn't exist.
+func (c *keyCursor) openSegment() error {
+
+	file, err := os.Open(c.k.contentSegmentName(c.pos.Segment))
+	if os.IsNotExist(err) {
+		return io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err == nil {
+		_, err = file.Seek(c.pos.Offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	c.file, c.size, c.r = file, fi.Size(), bufio.NewReader(file)
+
+	// The segment's hash log has the hash of each block, in the same order.
+	hlog, err := os.Open(c.k.hashLogSegmentName(c.pos.Segment))
+	if err != nil {
+		return nil
+	}
+	c.hlog = hlog
+	if c.base, err = c.k.segmentBase(c.pos.Segment); err == nil {
+		c.seekHashLog()
+	}
+	return nil
+}
+
+func (c *keyCursor) seekHashLog() {
+	c.hashes = nil
+	if c.pos.Seq < c.base {
+		return
+	}
+	if _, err := c.hlog.Seek(int64(c.pos.Seq-c.base)*hashLogEntrySize, io.SeekStart); err == nil {
+		c.hashes = bufio.NewReader(c.hlog)
+	}
+}
+
+// seek moves the cursor to pos. The open segment is kept if pos is in it.
+func (c *keyCursor) seek(pos Position) error {
+
+	if c.file == nil || pos.Segment != c.pos.Segment {
+		c.pos = pos
+		return c.closeSegment()
+	}
+	if _, err := c.file.Seek(pos.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	c.r.Reset(c.file)
+	c.pos = pos
+	if c.hlog != nil {
+		c.seekHashLog()
+	}
+	return nil
+}
+
+func (c *keyCursor) closeSegment() error {
+	var err error
+	if c.hlog != nil {
+		c.hlog.Close()
+	}
+	if c.file != nil {
+		err = c.file.Close()
+	}
+	c.file, c.r, c.hlog, c.hashes = nil, nil, nil, nil
+	return err
+}
+
+// skip marks rec if it has been retracted and reports if it should be skipped.
+func (c *keyCursor) skip(rec *Record) bool {
+	rec.Retracted = c.hidden[rec.Seq]
+	return (rec.Retracted || rec.Retraction) && !c.opts.IncludeRetracted
+}
+
+// readBlock reads the block at the cursor's position and moves the position past it. It returns
+// io.EOF at the end of the segment and errSkipped if the block was corrupt and skipped.
+func (c *keyCursor) readBlock() (*Record, error) {
+
+	if c.r == nil {
+		if err := c.openSegment(); err != nil {
+			return nil, err
+		}
+	}
+
+	offset, index := c.pos.Offset, c.pos.Seq
+	corrupt := func(reason string) *CorruptBlockError {
+		return &CorruptBlockError{
+			Key:    c.k.keyName.String(),
+			File:   c.file.Name(),
+			Offset: offset,
+			Index:  int(index),
+			Reason: reason,
+		}
+	}
+
+	header, err := readBlockHeader(c.r)
+	switch {
+	case err == io.EOF:
+		return nil, io.EOF
+	case err == io.ErrUnexpectedEOF:
+		return nil, corrupt("short header")
+	case err == errBadMagic:
+		return nil, corrupt(fmt.Sprintf("magic %X doesn't match magic number: %X", header.Magic, magicNumberVersioned))
+	case err == errBadVersion:
+		return nil, corrupt(fmt.Sprintf("unsupported block version: %d", header.Version))
+	case err != nil:
+		return nil, err
+	}
+	if header.Length > uint64(c.size-offset-header.size) {
+		return nil, corrupt(fmt.Sprintf("length %d is past the end of the file", header.Length))
+	}
+
+	if uint64(cap(c.payload)) < header.Length {
+		c.payload = make([]byte, header.Length)
+	}
+	c.payload = c.payload[:header.Length]
+	if _, err = io.ReadFull(c.r, c.payload); err != nil {
+		return nil, err
+	}
+
+	hash := ""
+	if c.hashes != nil {
+		if _, err = io.ReadFull(c.hashes, c.entry); err == nil {
+			hash = string(c.entry[:hashLogEntrySize-1])
+		} else {
+			c.hashes = nil
+		}
+	}
+
+	c.pos.Offset += header.size + int64(header.Length)
+	c.pos.Seq++
+
+	if crc64.Checksum(c.payload, c.table) != header.CRC64 {
+		cerr := corrupt("CRC64 mismatch")
+		if !c.opts.SkipCorrupt {
+			return nil, cerr
+		}
+		log.Println("WARNING: skipping", cerr)
+		return nil, errSkipped
+	}
+
+	content, err := decodeBlock(header.Codec, c.payload)
+	if err != nil {
+		return nil, corrupt(err.Error())
+	}
+	rec := &Record{
+		Seq:     index,
+		Hash:    hash,
+		Version: header.Version,
+		Data:    content,
+
+		Retraction: header.Flags&blockFlagRetraction != 0,
+	}
+	if header.Version >= 3 {
+		rec.Seq = header.Seq
+		if header.Timestamp != 0 {
+			rec.Timestamp = time.Unix(0, header.Timestamp)
+		}
+	}
+	return rec, nil
+}
+
+// ReadFrom calls f for up to limit records starting at the position start. A limit <= 0 reads to
+// the end of the key. The returned position is the one after the last record that was read.
+func (k *Key) ReadFrom(start Position, limit int, f RecordFunc, opts *ReadOptions) (Position, error) {
+
+	c, err := k.openCursor(start, opts)
+	if err != nil {
+		return start, err
+	}
+	defer c.Close()
+
+	for n := 0; (limit <= 0 || n < limit) && c.Next(); n++ {
+		if err = f(c.rec); err != nil {
+			return c.pos, err
+		}
+	}
+	return c.pos, c.Err()
+}
+
+// ReadRange calls f for up to limit records starting at record from. See ReadFrom.
+func (k *Key) ReadRange(from uint64, limit int, f RecordFunc, opts *ReadOptions) (Position, error) {
+	start, err := k.PositionAt(from)
+	if err != nil {
+		return start, err
+	}
+	return k.ReadFrom(start, limit, f, opts)
+}
+
+// ReadLast calls f for the last n records in the key, newest first. A n <= 0 reads every record.
+// The records are found with the offset index so only the ones passed to f are read. Records
+// skipped because they've been retracted are made up for with earlier ones.
+func (k *Key) ReadLast(n int, f RecordFunc, opts *ReadOptions) error {
+
+	count, err := k.nextSeq()
+	if err != nil {
+		return err
+	}
+	first, err := k.firstSeq()
+	if err != nil || count == first {
+		return err
+	}
+	if err = k.syncOffsets(count); err != nil {
+		return err
+	}
+	c, err := k.openCursor(Position{}, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var hidden map[uint64]bool
+	if !c.opts.IncludeRetracted {
+		if hidden, err = k.hiddenSeqs(); err != nil {
+			return err
+		}
+	}
+	from := first
+	if n > 0 {
+		from = count
+		for left := n; from > first && left > 0; {
+			from--
+			if !hidden[from] {
+				left--
+			}
+		}
+	}
+	entries, err := k.offsets.read(from-first, count-from)
+	if err != nil {
+		return err
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		pos := Position{Segment: int(entries[i].Segment), Offset: int64(entries[i].Offset), Seq: from + uint64(i)}
+		if err = c.seek(pos); err != nil {
+			return err
+		}
+		rec, err := c.readBlock()
+		switch {
+		case err == errSkipped:
+			continue
+		case err == io.EOF:
+			return fmt.Errorf("offset index points past the end of segment %d", pos.Segment)
+		case err != nil:
+			return err
+		case c.skip(rec):
+			continue
+		}
+		if err = f(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+package astore
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/skyec/astore/fluentio"
+)
+
+// DedupeMode is how an append is checked against the records already in its key.
+type DedupeMode int
+
+const (
+	DEDUPE_CONTENT DedupeMode = iota // drop records with the same content as any record in the key
+	DEDUPE_NONE                      // append every record
+	DEDUPE_WINDOW                    // drop records with the same content as one of the last Window records
+	DEDUPE_ID                        // drop records with the same idempotency ID as an earlier record
+)
+
+var dedupeModeNames = map[DedupeMode]string{
+	DEDUPE_CONTENT: "content",
+	DEDUPE_NONE:    "none",
+	DEDUPE_WINDOW:  "window",
+	DEDUPE_ID:      "id",
+}
+
+func (m DedupeMode) String() string {
+	if name, ok := dedupeModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("dedupe(%d)", int(m))
+}
+
+// ParseDedupeMode returns the DedupeMode called name.
+func ParseDedupeMode(name string) (DedupeMode, error) {
+	for m, n := range dedupeModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return DEDUPE_CONTENT, fmt.Errorf("unknown dedupe mode: %s", name)
+}
+
+// DedupePolicy sets the dedupe mode of the keys it applies to. Like a RetentionPolicy, it applies
+// to the key named Key or, if Key isn't set, to every key whose name starts with Prefix. Keys
+// without a policy use Config.Dedupe.Mode.
+//
+// DEDUPE_ID only drops records written with an idempotency ID (see WriteOptions) that was already
+// used in the key; records without one are always appended. The IDs are kept after the records
+// they came with are trimmed.
+type DedupePolicy struct {
+	Key    string
+	Prefix string
+	Mode   DedupeMode
+	Window uint64 // number of records DEDUPE_WINDOW checks; 0 uses Config.Dedupe.Window
+}
+
+func (p *DedupePolicy) String() string {
+	name := "prefix " + p.Prefix
+	if p.Key != "" {
+		name = "key " + p.Key
+	}
+	if p.Mode == DEDUPE_WINDOW {
+		return fmt.Sprintf("%s: mode: %s, window: %d", name, p.Mode, p.Window)
+	}
+	return fmt.Sprintf("%s: mode: %s", name, p.Mode)
+}
+
+// dedupePolicy returns the policy for the key named name. Keys without one get the store's
+// default.
+func (conf *Config) dedupePolicy(name string) DedupePolicy {
+
+	p := DedupePolicy{Mode: conf.Dedupe.Mode}
+	if match := conf.matchDedupePolicy(name); match != nil {
+		p = *match
+	}
+	if p.Window == 0 {
+		p.Window = conf.Dedupe.Window
+	}
+	return p
+}
+
+// matchDedupePolicy returns the policy that names the key or has the longest matching prefix.
+func (conf *Config) matchDedupePolicy(name string) *DedupePolicy {
+
+	var match *DedupePolicy
+	for i := range conf.Dedupe.Policies {
+		p := &conf.Dedupe.Policies[i]
+		switch {
+		case p.Key != "":
+			if p.Key == name {
+				return p
+			}
+		case strings.HasPrefix(name, p.Prefix):
+			if match == nil || len(p.Prefix) > len(match.Prefix) {
+				match = p
+			}
+		}
+	}
+	return match
+}
+
+// WriteOptions change how a record is written to the store. A nil *WriteOptions uses the defaults.
+type WriteOptions struct {
+	ID string // idempotency ID checked by keys with a DEDUPE_ID policy
+}
+
+// AppendOptions change how a record is appended to a key. A nil *AppendOptions dedupes by content.
+type AppendOptions struct {
+	Dedupe DedupeMode
+	Window uint64 // number of records DEDUPE_WINDOW checks
+	ID     string // idempotency ID checked by DEDUPE_ID
+
+	txPos string // position of the record in the tx log it's committed from; see txApplied
+}
+
+// appendOptions returns the options for appending to the key named key with opts.
+func (conf *Config) appendOptions(key string, opts *WriteOptions) *AppendOptions {
+	p := conf.dedupePolicy(key)
+	ao := &AppendOptions{Dedupe: p.Mode, Window: p.Window}
+	if opts != nil {
+		ao.ID = opts.ID
+	}
+	return ao
+}
+
+// WriteStatus says what became of a write.
+type WriteStatus int
+
+const (
+	WRITE_APPENDED  WriteStatus = iota // the record was appended to the key
+	WRITE_DUPLICATE                    // the record was a duplicate and was dropped
+	WRITE_PENDING                      // the record is in the tx log; duplicates are dropped when it's committed
+)
+
+var writeStatusNames = map[WriteStatus]string{
+	WRITE_APPENDED:  "appended",
+	WRITE_DUPLICATE: "duplicate",
+	WRITE_PENDING:   "pending",
+}
+
+func (s WriteStatus) String() string {
+	if name, ok := writeStatusNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("status(%d)", int(s))
+}
+
+// isDuplicate reports if a record with hash is dropped by the dedupe mode in opts.
+func (k *Key) isDuplicate(hash string, opts *AppendOptions) (bool, error) {
+
+	if opts == nil {
+		return k.hashExists(hash)
+	}
+	if opts.txPos != "" {
+		applied, err := k.txApplied(opts.txPos)
+		if err != nil || applied {
+			return applied, err
+		}
+	}
+	switch opts.Dedupe {
+	case DEDUPE_CONTENT:
+		return k.hashExists(hash)
+	case DEDUPE_WINDOW:
+		return k.recentHash(hash, opts.Window)
+	case DEDUPE_ID:
+		if opts.ID == "" {
+			return false, nil
+		}
+		return k.ids.contains(idHash(opts.ID))
+	}
+	return false, nil
+}
+
+// appendMarker is what's needed to find the duplicates of a record appended with a DEDUPE_ID
+// policy or from the tx log, besides its content: its hashed idempotency ID and its position in
+// the tx log. It's written to the key's marker file, and synced, before the record; see
+// recoverMarker.
+type appendMarker struct {
+	Seq   uint64 `json:"seq"`
+	Hash  string `json:"hash"`
+	ID    string `json:"id,omitempty"`
+	TxPos string `json:"txPos,omitempty"`
+}
+
+// markerFor returns the marker of the record with hash appended with opts, without its sequence
+// number. It's nil if the hash log already covers the record's duplicates.
+func markerFor(hash string, opts *AppendOptions) *appendMarker {
+
+	if opts == nil {
+		return nil
+	}
+	m := &appendMarker{Hash: hash, TxPos: opts.txPos}
+	if opts.Dedupe == DEDUPE_ID && opts.ID != "" {
+		m.ID = idHash(opts.ID)
+	}
+	if m.ID == "" && m.TxPos == "" {
+		return nil
+	}
+	return m
+}
+
+// writeMarker writes m to the key's marker file ahead of its record.
+func (k *Key) writeMarker(m *appendMarker) error {
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	if err = fluentio.OpenFile(k.markerFileName, os.O_WRONLY|os.O_TRUNC|os.O_CREATE, defaultFilePermisions).
+		Write(b).
+		Flush().
+		Sync(k.syncEnabled).
+		Close(); err != nil {
+		return fmt.Errorf("error writing the append marker: %s", err)
+	}
+	return nil
+}
+
+// appendedWith records the idempotency ID and tx log position in m once its record has been
+// appended. A nil m has nothing to record.
+func (k *Key) appendedWith(m *appendMarker) error {
+
+	if m == nil {
+		return nil
+	}
+	if m.ID != "" {
+		if err := k.writeIDLog(m.ID); err != nil {
+			return fmt.Errorf("error writing idempotency ID: %s", err)
+		}
+	}
+	if m.TxPos != "" {
+		if err := ioutil.WriteFile(k.txAppliedFileName, []byte(m.TxPos), defaultFilePermisions); err != nil {
+			return fmt.Errorf("error writing tx log position: %s", err)
+		}
+	}
+	return nil
+}
+
+// recoverMarker finishes recording the marker of the last append after a crash, or an error,
+// between the record and appendedWith. The marker is only trusted if it's for the last record in
+// the key. One that isn't was either written for a record that never made it to the key or was
+// already recovered before the records after it were appended, so it's removed.
+func (k *Key) recoverMarker() error {
+
+	b, err := ioutil.ReadFile(k.markerFileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	m := &appendMarker{}
+	last := false
+	if json.Unmarshal(b, m) == nil {
+		next, err := k.nextSeq()
+		if err != nil {
+			return err
+		}
+		if next == m.Seq+1 {
+			if last, err = k.recentHash(m.Hash, 1); err != nil {
+				return err
+			}
+		}
+	}
+	if !last {
+		return os.Remove(k.markerFileName)
+	}
+
+	if m.ID != "" {
+		found, err := k.ids.contains(m.ID)
+		if err != nil {
+			return err
+		}
+		if found {
+			m.ID = ""
+		}
+	}
+	if m.TxPos != "" {
+		applied, err := k.txApplied(m.TxPos)
+		if err != nil {
+			return err
+		}
+		if applied {
+			m.TxPos = ""
+		}
+	}
+	if m.ID != "" || m.TxPos != "" {
+		log.Printf("WARNING: recovering the dedupe state of the last record in key: %s", k.keyName)
+	}
+	return k.appendedWith(m)
+}
+
+// recentHash reports if hash is one of the last n entries in the key's hash log.
+func (k *Key) recentHash(hash string, n uint64) (bool, error) {
+
+	last, err := k.lastSegment()
+	if err != nil {
+		return false, err
+	}
+	entry := make([]byte, hashLogEntrySize)
+	for seg := last; seg >= 0 && n > 0; seg-- {
+		file, err := os.Open(k.hashLogSegmentName(seg))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		fi, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return false, err
+		}
+		for i := fi.Size() / hashLogEntrySize; i > 0 && n > 0; i, n = i-1, n-1 {
+			if _, err = file.ReadAt(entry, (i-1)*hashLogEntrySize); err != nil {
+				file.Close()
+				return false, err
+			}
+			if string(entry[:hashLogEntrySize-1]) == hash {
+				file.Close()
+				return true, nil
+			}
+		}
+		file.Close()
+	}
+	return false, nil
+}
+
+// idHash returns the hash an idempotency ID is kept as. IDs are hashed so that they have the same
+// form as the hash log entries and can be indexed the same way.
+func idHash(id string) string {
+	return fmt.Sprintf("%X", sha1.Sum([]byte(id)))
+}
+
+func (k *Key) writeIDLog(hash string) error {
+	if err := fluentio.OpenFile(k.idLogFileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultFilePermisions).
+		Write([]byte(hash + "\n")).
+		Flush().
+		Sync(k.syncEnabled).
+		Close(); err != nil {
+		return err
+	}
+	return k.ids.add(hash)
+}
+
+// txApplied reports if the tx log block at pos has already been committed to the key. Content
+// dedupe skips the blocks of a tx log that's applied again after a crash; for the other modes the
+// position of the last block committed to the key is kept instead. Tx log names sort in the order
+// they were rotated and the offsets are fixed width so the positions compare as strings. The
+// committers hold back a key's later blocks once one fails so no position is passed over.
+func (k *Key) txApplied(pos string) (bool, error) {
+
+	b, err := ioutil.ReadFile(k.txAppliedFileName)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return pos <= string(b), nil
+}
+
+// copyDedupe copies the idempotency IDs and tx log position of k to nk, the copy of k made by
+// trim. The marker of the last append is recovered first since its record might not be kept.
+func (k *Key) copyDedupe(nk *Key) error {
+
+	if err := k.recoverMarker(); err != nil {
+		return err
+	}
+	for _, names := range [][2]string{{k.idLogFileName, nk.idLogFileName}, {k.txAppliedFileName, nk.txAppliedFileName}} {
+		b, err := ioutil.ReadFile(names[0])
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err == nil {
+			err = ioutil.WriteFile(names[1], b, defaultFilePermisions)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,262 @@
+package astore
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A deleted key's directory is renamed to <hash>.deleted, which is also the key's tombstone: the
+// key can't be appended to, by the API or the tx log committers, while it's there. It's kept for
+// Config.Delete.Grace so the key can be undeleted and until every append that was in the tx log
+// when the key was deleted has been dropped. Then it's renamed to <hash>.reclaim and removed.
+const (
+	deletedSuffix     = ".deleted"
+	reclaimSuffix     = ".reclaim"
+	tombstoneFileName = "tombstone"
+)
+
+var (
+	ErrKeyNotFound       = errors.New("key not found")
+	ErrKeyDeleted        = errors.New("key has been deleted")
+	ErrInvalidPurgeToken = errors.New("invalid purge token")
+)
+
+// tombstone is written to a deleted key's directory.
+type tombstone struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"` // from the key registry; zero if the key wasn't registered
+	Deleted time.Time `json:"deleted"`
+}
+
+// DeletedKey describes a key that has been deleted but not reclaimed yet.
+type DeletedKey struct {
+	Name         string    // the key's name
+	Deleted      time.Time // when the key was deleted
+	ReclaimAfter time.Time // when the grace period ends and the key can't be undeleted anymore
+}
+
+func readTombstone(dir string) (*tombstone, error) {
+
+	b, err := ioutil.ReadFile(dir + "/" + tombstoneFileName)
+	if err != nil {
+		return nil, err
+	}
+	ts := &tombstone{}
+	if err = json.Unmarshal(b, ts); err != nil {
+		return nil, fmt.Errorf("bad tombstone in: %s: %s", dir, err)
+	}
+	return ts, nil
+}
+
+// delete moves the key's directory out of the way, leaving ts in it. ErrKeyNotFound is returned
+// if the key doesn't have a directory.
+func (k *Key) delete(ts *tombstone) error {
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(k.keyDir); err != nil {
+		if os.IsNotExist(err) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	b, err := json.Marshal(ts)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(k.keyDir+"/"+tombstoneFileName, b, defaultFilePermisions); err != nil {
+		return err
+	}
+	return os.Rename(k.keyDir, k.keyDir+deletedSuffix)
+}
+
+// undelete moves a deleted key's directory back and returns its tombstone. ErrKeyNotFound is
+// returned if the key isn't deleted or has already been reclaimed.
+func (k *Key) undelete() (*tombstone, error) {
+
+	unlock, err := k.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	deleted := k.keyDir + deletedSuffix
+	ts, err := readTombstone(deleted)
+	if os.IsNotExist(err) {
+		return nil, ErrKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = os.Rename(deleted, k.keyDir); err != nil {
+		return nil, err
+	}
+	if err = os.Remove(k.keyDir + "/" + tombstoneFileName); err != nil {
+		log.Println("WARNING: removing the tombstone of an undeleted key:", k.keyName, err)
+	}
+	return ts, nil
+}
+
+// DeleteKey deletes key. Its records can't be read from then on and appends to it fail with
+// ErrKeyDeleted until it's reclaimed, Config.Delete.Grace later at the earliest. Until then
+// UndeleteKey brings it back. Appends that were still in the tx log when the key was deleted are
+// dropped. ErrKeyNotFound is returned if the key doesn't have any records yet.
+func (s *store) DeleteKey(key string) error {
+
+	created, err := s.registry.created(key)
+	if err != nil {
+		return err
+	}
+	k, err := OpenKeyWithConfig(s.GetKeyPath(), newSha1Key(key), s.conf)
+	if err != nil {
+		return err
+	}
+	if err = k.delete(&tombstone{Name: key, Created: created, Deleted: time.Now()}); err != nil {
+		return err
+	}
+	if err = s.registry.unregister(key); err != nil {
+		log.Println("ERROR: removing a deleted key from the registry:", key, err)
+	}
+	return nil
+}
+
+// UndeleteKey restores a deleted key that hasn't been reclaimed yet. ErrKeyNotFound is returned
+// if there isn't one.
+func (s *store) UndeleteKey(key string) error {
+
+	k, err := OpenKeyWithConfig(s.GetKeyPath(), newSha1Key(key), s.conf)
+	if err != nil {
+		return err
+	}
+	ts, err := k.undelete()
+	if err != nil {
+		return err
+	}
+	created := ts.Created
+	if created.IsZero() {
+		created = time.Now()
+	}
+	if err = s.registry.registerAt(key, created); err != nil {
+		log.Println("ERROR: registering an undeleted key:", key, err)
+	}
+	return nil
+}
+
+// ListDeletedKeys returns the deleted keys that haven't been reclaimed yet, ordered by name.
+func (s *store) ListDeletedKeys() ([]*DeletedKey, error) {
+
+	dirs, err := filepath.Glob(s.GetKeyPath() + "/*/*/*/*" + deletedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	keys := []*DeletedKey{}
+	for _, dir := range dirs {
+		ts, err := readTombstone(dir)
+		if os.IsNotExist(err) {
+			// reclaimed or undeleted since the glob
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, &DeletedKey{
+			Name:         ts.Name,
+			Deleted:      ts.Deleted,
+			ReclaimAfter: ts.Deleted.Add(s.conf.Delete.Grace),
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Name < keys[j].Name })
+	return keys, nil
+}
+
+// appliedThrough returns the time before which every append has been committed to its key.
+func (s *store) appliedThrough() time.Time {
+	if w, ok := s.keyWriter.(deferredAppendableKey); ok {
+		return w.appliedThrough()
+	}
+	// direct appends are in their key when they return
+	return time.Now()
+}
+
+// reclaimDeleted removes the directories of the keys deleted longer than Config.Delete.Grace
+// before now once the appends that were in the tx log when they were deleted have been dropped.
+func (s *store) reclaimDeleted(now time.Time) error {
+
+	// finish removing the keys that were being reclaimed when the store was last closed
+	dirs, err := filepath.Glob(s.GetKeyPath() + "/*/*/*/*" + reclaimSuffix)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		if err = os.RemoveAll(dir); err != nil {
+			return err
+		}
+	}
+
+	dirs, err = filepath.Glob(s.GetKeyPath() + "/*/*/*/*" + deletedSuffix)
+	if err != nil {
+		return err
+	}
+	applied := s.appliedThrough()
+	for _, dir := range dirs {
+		ts, err := readTombstone(dir)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			log.Println("ERROR: reclaiming deleted key:", err)
+			continue
+		}
+		if now.Before(ts.Deleted.Add(s.conf.Delete.Grace)) || !applied.After(ts.Deleted) {
+			continue
+		}
+		hash, err := hex.DecodeString(strings.TrimSuffix(filepath.Base(dir), deletedSuffix))
+		if err != nil || len(hash) != sha1.Size {
+			log.Println("ERROR: reclaiming deleted key: unexpected directory:", dir)
+			continue
+		}
+		if err = s.reclaim(newSha1KeyFromHash(hash), dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reclaim removes the directory of the deleted key hk and its attributes. The key can be
+// appended to again once the directory has been moved out of the way.
+func (s *store) reclaim(hk hashableKey, dir string) error {
+
+	keyDir := strings.TrimSuffix(dir, deletedSuffix)
+	unlock, err := lockKey(hk, keyDir, s.conf.Key.LockFiles)
+	if err != nil {
+		return err
+	}
+	reclaimed := keyDir + reclaimSuffix
+	err = os.Rename(dir, reclaimed)
+	unlock()
+	if os.IsNotExist(err) {
+		// undeleted since the glob
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err = s.attrs.set(hk, nil); err != nil {
+		return err
+	}
+	return os.RemoveAll(reclaimed)
+}
@@ -0,0 +1,34 @@
+package astore
+
+import "fmt"
+
+// CorruptBlockError is returned when a block read from disk fails validation: the magic number
+// doesn't match, the block is cut short or the payload doesn't match its CRC64.
+type CorruptBlockError struct {
+	Key    string // hash of the key the block belongs to
+	File   string // file the block was read from
+	Offset int64  // byte offset of the block's header in File
+	Index  int    // index of the block in File; the record index for content files
+	Reason string
+}
+
+func (e *CorruptBlockError) Error() string {
+	return fmt.Sprintf("corrupt block: key: %s, file: %s, offset: %d, index: %d: %s",
+		e.Key, e.File, e.Offset, e.Index, e.Reason)
+}
+
+// StoreFormatError is returned when a store was written in a format this version can't open.
+// Older stores can be brought up to date with astore-upgrade.
+type StoreFormatError struct {
+	Path   string
+	Format int // format found in the store
+}
+
+func (e *StoreFormatError) Error() string {
+	if e.Format > STORE_FORMAT_VERSION {
+		return fmt.Sprintf("store %s is format %d which is newer than this version supports: %d",
+			e.Path, e.Format, STORE_FORMAT_VERSION)
+	}
+	return fmt.Sprintf("store %s is format %d, run astore-upgrade to upgrade it to format %d",
+		e.Path, e.Format, STORE_FORMAT_VERSION)
+}
@@ -0,0 +1,431 @@
+package astore
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// FsckOptions control what Fsck does with the problems it finds.
+type FsckOptions struct {
+	Repair bool // truncate torn tails and rebuild hash logs from content
+}
+
+// FsckProblem describes a single problem found by Fsck.
+type FsckProblem struct {
+	File     string
+	Offset   int64
+	Problem  string
+	Repaired bool
+}
+
+func (p *FsckProblem) String() string {
+	s := fmt.Sprintf("%s: offset %d: %s", p.File, p.Offset, p.Problem)
+	if p.Repaired {
+		s += " [repaired]"
+	}
+	return s
+}
+
+// FsckReport summarizes a run of Fsck.
+type FsckReport struct {
+	Keys        int  // number of keys checked
+	Blocks      int  // number of content blocks checked
+	TxLogs      int  // number of tx logs checked
+	TxLogBlocks int  // number of tx log blocks checked
+	InUse       bool // the store was open so the tx logs weren't repaired; see Fsck
+	Problems    []*FsckProblem
+}
+
+// Unrepaired returns the number of problems that are still outstanding.
+func (r *FsckReport) Unrepaired() int {
+	n := 0
+	for _, p := range r.Problems {
+		if !p.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *FsckReport) add(file string, offset int64, problem string, repaired bool) {
+	r.Problems = append(r.Problems, &FsckProblem{file, offset, problem, repaired})
+}
+
+// Fsck checks the store at path. Stores in a format older than MIN_STORE_FORMAT_VERSION aren't
+// checked; a *StoreFormatError is returned for them.
+//
+// Every key's content blocks are parsed and checked for a valid magic number and CRC64, and the
+// key's hash log is compared with the content. A half-written block at the end of a content
+// file or a tx log is reported as a torn tail. With opts.Repair set, torn tails are truncated and
+// hash logs that don't match the content are rebuilt from it. Corrupt blocks in the middle of a
+// file are reported but left alone. Tx logs that astored quarantined are reported and checked too.
+//
+// Fsck can run while astored has the store open as long as astored takes the key lock files (see
+// Config.Key.LockFiles); each key is checked while holding its lock file so it's checked between
+// appends. The tx logs can't be locked. While the store is open they are checked but not repaired,
+// and writing/tx.log, which astored is appending to, isn't checked; report.InUse is set. Fsck locks
+// the store while it runs otherwise, so astored can't open it until Fsck is done.
+func Fsck(path string, opts *FsckOptions) (*FsckReport, error) {
+	if opts == nil {
+		opts = &FsckOptions{}
+	}
+
+	// older formats lay keys out differently; astore-upgrade checks those as it rewrites them
+	m, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if m != nil && (m.Format < MIN_STORE_FORMAT_VERSION || m.Format > STORE_FORMAT_VERSION) {
+		return nil, &StoreFormatError{Path: path, Format: m.Format}
+	}
+
+	report := &FsckReport{}
+	file, err := lockStore(path, syscall.LOCK_EX)
+	switch {
+	case err == syscall.EWOULDBLOCK:
+		report.InUse = true
+	case err != nil:
+		return nil, err
+	default:
+		defer file.Close()
+	}
+
+	keyPath := path + "/keys"
+	conf := NewConfig()
+	conf.Key.LockFiles = true
+	keyDirs, err := filepath.Glob(keyPath + "/*/*/*/*")
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range keyDirs {
+		switch filepath.Ext(dir) {
+		case deletedSuffix, reclaimSuffix:
+			// deleted keys are reclaimed by astored
+			continue
+		case lockSuffix:
+			continue
+		case ".trim", ".old":
+			// astored moves a finished trim into place when it opens the key and removes an old
+			// directory the next time it trims it
+			report.add(dir, 0, "left over from an interrupted trim", false)
+			continue
+		}
+		hash, err := hex.DecodeString(filepath.Base(dir))
+		if err != nil || len(hash) != sha1.Size {
+			report.add(dir, 0, "unexpected entry in the keys directory", false)
+			continue
+		}
+		k := openKeyDir(keyPath, dir, newSha1KeyFromHash(hash), conf)
+		unlock, err := k.lock()
+		if err != nil {
+			return report, err
+		}
+		err = fsckKey(k, opts, report)
+		unlock()
+		if err != nil {
+			return report, err
+		}
+	}
+
+	logs, err := filepath.Glob(path + "/txlog/reading/*.log")
+	if err != nil {
+		return report, err
+	}
+	txLogOpts := opts
+	if report.InUse {
+		txLogOpts = &FsckOptions{}
+	} else {
+		logs = append(logs, path+"/txlog/writing/tx.log")
+	}
+	quarantined, err := filepath.Glob(path + "/txlog/quarantine/*.log")
+	if err != nil {
+		return report, err
+	}
+	for _, logName := range quarantined {
+		report.add(logName, 0, "quarantined, move it to txlog/reading to apply it again", false)
+	}
+	logs = append(logs, quarantined...)
+	for _, logName := range logs {
+		if err = fsckTxLog(logName, txLogOpts, report); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// fsckBlock is a content block found by fsckScan.
+type fsckBlock struct {
+	offset  int64
+	hash    string // sha1 of the payload
+	corrupt bool
+}
+
+// fsckScan reads the blocks in a content file. It returns the blocks it could parse, the offset
+// where the last of them ends and, if there are bytes after that which aren't a complete block,
+// the reason they're not.
+func fsckScan(fileName string, report *FsckReport) ([]*fsckBlock, int64, string, error) {
+
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return nil, 0, "", nil
+	}
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	blocks := []*fsckBlock{}
+	table := crc64.MakeTable(crc64.ISO)
+	r := bufio.NewReader(file)
+	var offset int64
+	var payload []byte
+	for {
+		header, err := readBlockHeader(r)
+		switch {
+		case err == io.EOF:
+			return blocks, offset, "", nil
+		case err == io.ErrUnexpectedEOF:
+			return blocks, offset, "torn tail: short header", nil
+		case err == errBadMagic && header.Magic == 0:
+			// the reserved header of a streamed append that never finished
+			return blocks, offset, "torn tail: unfinished block", nil
+		case err == errBadMagic:
+			return blocks, offset, fmt.Sprintf("bad magic number: %X", header.Magic), nil
+		case err == errBadVersion:
+			return blocks, offset, fmt.Sprintf("unsupported block version: %d", header.Version), nil
+		case err != nil:
+			return nil, 0, "", err
+		case header.Length > uint64(fi.Size()-offset-header.size):
+			return blocks, offset, "torn tail: payload past the end of the file", nil
+		}
+
+		if uint64(cap(payload)) < header.Length {
+			payload = make([]byte, header.Length)
+		}
+		payload = payload[:header.Length]
+		if _, err = io.ReadFull(r, payload); err != nil {
+			return nil, 0, "", err
+		}
+		b := &fsckBlock{
+			offset:  offset,
+			corrupt: crc64.Checksum(payload, table) != header.CRC64,
+		}
+		if !b.corrupt {
+			// the hash log has the hash of the uncompressed payload
+			b.hash, err = fsckHash(header.Codec, payload)
+			if err != nil {
+				b.corrupt = true
+				report.add(fileName, offset, fmt.Sprintf("can't decompress: %s", err), false)
+			}
+		} else {
+			report.add(fileName, offset, "CRC64 mismatch", false)
+		}
+		blocks = append(blocks, b)
+		offset += header.size + int64(header.Length)
+	}
+}
+
+func fsckHash(codec Codec, payload []byte) (string, error) {
+	content, err := decodeBlock(codec, payload)
+	if err != nil {
+		return "", err
+	}
+	hasher := sha1.New()
+	if _, err = io.Copy(hasher, content); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", hasher.Sum(nil)), nil
+}
+
+func fsckKey(k *Key, opts *FsckOptions, report *FsckReport) error {
+
+	report.Keys++
+	last, err := k.lastSegment()
+	if err != nil {
+		return err
+	}
+	rebuilt := false
+	for seg := 0; seg <= last; seg++ {
+		r, err := fsckSegment(k, seg, seg == last, opts, report)
+		if err != nil {
+			return err
+		}
+		rebuilt = rebuilt || r
+	}
+	if !rebuilt {
+		return nil
+	}
+
+	// The indexes and stats are rebuilt from the new hash logs and content the next time they're used.
+	for _, name := range []string{k.hashIdx.fileName, k.offsets.fileName, k.metaFileName} {
+		if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// fsckSegment checks a content segment against its hash log. Torn tails are only truncated in
+// the last segment; the others are sealed. It reports whether the hash log was rebuilt.
+func fsckSegment(k *Key, seg int, last bool, opts *FsckOptions, report *FsckReport) (bool, error) {
+
+	contentFile := k.contentSegmentName(seg)
+	blocks, end, tail, err := fsckScan(contentFile, report)
+	if err != nil {
+		return false, err
+	}
+	report.Blocks += len(blocks)
+
+	if tail != "" {
+		repaired := false
+		// Only torn tails are truncated. Anything else could be hiding good blocks after it.
+		if opts.Repair && last && strings.HasPrefix(tail, "torn") {
+			if err = os.Truncate(contentFile, end); err != nil {
+				return false, err
+			}
+			repaired = true
+		}
+		report.add(contentFile, end, tail, repaired)
+	}
+
+	hashes, err := k.readHashLog(seg)
+	if err != nil {
+		return false, err
+	}
+	mismatch := len(hashes) != len(blocks)
+	for i := 0; !mismatch && i < len(blocks); i++ {
+		mismatch = !blocks[i].corrupt && blocks[i].hash != hashes[i]
+	}
+	if !mismatch {
+		return false, nil
+	}
+
+	hashLog := k.hashLogSegmentName(seg)
+	problem := fmt.Sprintf("hash log doesn't match content: %d hashes, %d blocks", len(hashes), len(blocks))
+	if !opts.Repair {
+		report.add(hashLog, 0, problem, false)
+		return false, nil
+	}
+	perm := os.FileMode(defaultFilePermisions)
+	if !last {
+		perm = sealedFilePermissions
+	}
+	if err = fsckRebuildHashLog(hashLog, perm, hashes, blocks); err != nil {
+		return false, err
+	}
+	report.add(hashLog, 0, problem, true)
+	return true, nil
+}
+
+// fsckRebuildHashLog replaces a hash log with the hashes of the blocks. The hash of a corrupt
+// block can't be trusted so the old hash log's entry is kept for those when there is one.
+func fsckRebuildHashLog(hashLog string, perm os.FileMode, hashes []string, blocks []*fsckBlock) error {
+
+	tmpName := hashLog + ".fsck"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	for i, b := range blocks {
+		hash := b.hash
+		if b.corrupt && i < len(hashes) {
+			hash = hashes[i]
+		}
+		if _, err = w.WriteString(hash + "\n"); err != nil {
+			file.Close()
+			return err
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, hashLog)
+}
+
+func fsckTxLog(logName string, opts *FsckOptions, report *FsckReport) error {
+
+	file, err := os.Open(logName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	report.TxLogs++
+	table := crc64.MakeTable(crc64.ISO)
+	r := bufio.NewReader(file)
+	var offset int64
+	tail := ""
+	for tail == "" {
+		header, err := readTxLogHeader(r)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			tail = "torn tail: short header"
+			continue
+		case err == errBadMagic:
+			report.add(logName, offset, fmt.Sprintf("bad magic number: %X", header.Magic), false)
+			return nil
+		case err == errBadVersion:
+			report.add(logName, offset, fmt.Sprintf("unsupported block version: %d", header.Version), false)
+			return nil
+		case err != nil:
+			return err
+		case header.Len > uint64(fi.Size()-offset-header.size):
+			tail = "torn tail: payload past the end of the file"
+			continue
+		}
+
+		crc := crc64.New(table)
+		if header.opts != nil {
+			crc.Write([]byte(header.opts.ID))
+		}
+		if _, err = io.CopyN(crc, r, int64(header.Len)); err != nil {
+			return err
+		}
+		if crc.Sum64() != header.CRC64 {
+			report.add(logName, offset, "CRC64 mismatch", false)
+		}
+		report.TxLogBlocks++
+		offset += header.size + int64(header.Len)
+	}
+
+	repaired := false
+	if opts.Repair {
+		if err = os.Truncate(logName, offset); err != nil {
+			return err
+		}
+		repaired = true
+	}
+	report.add(logName, offset, tail, repaired)
+	return nil
+}
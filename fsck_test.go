@@ -0,0 +1,220 @@
+package astore
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestFsckCleanStore(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	helpFsckStore(t, testDir)
+
+	report, err := Fsck(testDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems, got: %v", report.Problems)
+	}
+	if report.Keys != 2 || report.Blocks != 3 {
+		t.Errorf("expected 2 keys and 3 blocks, got: %d keys, %d blocks", report.Keys, report.Blocks)
+	}
+}
+
+func TestFsckRepair(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	keys := helpFsckStore(t, testDir)
+
+	// torn tail on the first key and a missing hash log on the second
+	fi, _ := os.Stat(keys[0].contentSegmentName(0))
+	if err := os.Truncate(keys[0].contentSegmentName(0), fi.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(keys[1].keyHashLogFileName); err != nil {
+		t.Fatal(err)
+	}
+
+	// and a torn tx log
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	klog.Append(newSha1Key("a"), []byte("pending"), nil)
+	fi, _ = os.Stat(klog.writeLogName)
+	os.Truncate(klog.writeLogName, fi.Size()-1)
+
+	report, err := Fsck(testDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// torn content, a hash log with more hashes than blocks, a hash log with no hashes, torn tx log
+	if len(report.Problems) != 4 || report.Unrepaired() != 4 {
+		t.Fatalf("expected 4 unrepaired problems, got: %v", report.Problems)
+	}
+
+	report, err = Fsck(testDir, &FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 4 || report.Unrepaired() != 0 {
+		t.Fatalf("expected 4 repaired problems, got: %v", report.Problems)
+	}
+
+	report, err = Fsck(testDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 {
+		t.Errorf("expected no problems after repair, got: %v", report.Problems)
+	}
+
+	hashes, _ := ioutil.ReadFile(keys[1].keyHashLogFileName)
+	if n := strings.Count(string(hashes), "\n"); n != 1 {
+		t.Errorf("expected the rebuilt hash log to have 1 hash, got: %d", n)
+	}
+	if n, _ := keys[0].Count(); n != 1 {
+		t.Errorf("expected the torn block to be removed from the first key, got %d records", n)
+	}
+}
+
+func TestFsckReportsCorruptBlocks(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	keys := helpFsckStore(t, testDir)
+
+	file, err := os.OpenFile(keys[0].contentSegmentName(0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("X"), headerSizeV3)
+	file.Close()
+
+	report, err := Fsck(testDir, &FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 || report.Problems[0].Problem != "CRC64 mismatch" || report.Problems[0].Repaired {
+		t.Errorf("expected a single unrepaired CRC64 mismatch, got: %v", report.Problems)
+	}
+}
+
+func TestFsckRefusesOldFormats(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	hexKey := newSha1Key("hex")
+	helpWriteV1Key(t, keyDirName(testDir+"/keys", hexKey), "h1")
+	before := helpListDir(t, testDir)
+
+	report, err := Fsck(testDir, &FsckOptions{Repair: true})
+	if _, ok := err.(*StoreFormatError); !ok {
+		t.Fatalf("expected a *StoreFormatError, got: %v, %v", report, err)
+	}
+	if after := helpListDir(t, testDir); strings.Join(after, "\n") != strings.Join(before, "\n") {
+		t.Errorf("expected fsck to leave the store alone, got: %v, was: %v", after, before)
+	}
+}
+
+func TestFsckInUse(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	s, err := NewReadWriteableStore(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a torn tail in a rotated tx log isn't repaired while the store is open
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a1", "a2"} {
+		if _, err = klog.Append(newSha1Key("a"), []byte(v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logName, err := klog.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(logName)
+	size := fi.Size() - 1
+	os.Truncate(logName, size)
+
+	report, err := Fsck(testDir, &FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.InUse || report.Unrepaired() != 1 {
+		t.Errorf("expected an unrepaired torn tail in a store in use, got: %+v", report)
+	}
+	if fi, _ = os.Stat(logName); fi.Size() != size {
+		t.Errorf("expected the tx log to be left alone, size: %d, expected: %d", fi.Size(), size)
+	}
+
+	s.(*store).Close()
+	report, err = Fsck(testDir, &FsckOptions{Repair: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.InUse || report.Unrepaired() != 0 {
+		t.Errorf("expected the torn tail to be repaired once the store is closed, got: %+v", report)
+	}
+
+	// and the store can't be opened while fsck has it locked
+	file, err := lockStore(testDir, syscall.LOCK_EX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err = NewReadWriteableStore(testDir); err == nil {
+		t.Error("expected opening a store locked by fsck to fail")
+	}
+}
+
+// helpListDir returns the names of everything under dir.
+func helpListDir(t *testing.T, dir string) []string {
+	names := []string{}
+	err := filepath.Walk(dir, func(name string, fi os.FileInfo, err error) error {
+		names = append(names, name)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+// helpFsckStore creates two keys in dir; the first with two records and the second with one.
+func helpFsckStore(t *testing.T, dir string) []*Key {
+	if err := writeManifest(dir, &manifest{Format: STORE_FORMAT_VERSION}); err != nil {
+		t.Fatal(err)
+	}
+	return []*Key{
+		helpFsckKey(t, dir, "a", "a1", "a2"),
+		helpFsckKey(t, dir, "b", "b1"),
+	}
+}
+
+func helpFsckKey(t *testing.T, dir, name string, records ...string) *Key {
+	k, err := OpenKey(dir+"/keys", newSha1Key(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range records {
+		if err = k.Append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return k
+}
@@ -0,0 +1,320 @@
+package astore
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+const (
+	hashLogEntrySize    = 2*sha1.Size + 1 // hex encoded hash + newline
+	hashIndexMagic      = uint32(0xff00ff11)
+	hashIndexHeaderSize = 24 // magic(4) + reserved(4) + covered(8) + entries(8)
+	hashIndexMergeSize  = 1024
+)
+
+type hashIndexHeader struct {
+	Magic    uint32
+	Reserved uint32
+	Covered  uint64 // number of hash log entries merged into the index
+	Entries  uint64 // number of sorted hashes in the index
+}
+
+// hashIndex is a persistent index over a key's hash log so duplicates can be found without
+// reading the whole log.
+//
+// The index file holds the raw hashes of the first Covered hash log entries, sorted so they can
+// be binary searched on disk. Entries are counted across the hash log segments in order. Entries
+// appended to the hash log after that make up the tail which is read into memory when the index
+// is opened. Once the tail reaches hashIndexMergeSize entries
+// it is merged into the sorted file. The hash log stays the source of truth: a missing
+// or stale index is rebuilt from it.
+type hashIndex struct {
+	fileName string
+	logNames func() ([]string, error) // the hash log segments, in order
+	covered  int64                    // hash log entries covered by the sorted file
+	entries  int64                    // hashes in the sorted file
+	tail     map[string]struct{}
+	tailLen  int64 // hash log entries in the tail, including duplicates
+	loaded   bool
+}
+
+func newHashIndex(fileName string, logNames func() ([]string, error)) *hashIndex {
+	return &hashIndex{
+		fileName: fileName,
+		logNames: logNames,
+	}
+}
+
+// load reads the index header and the hash log entries it doesn't cover yet.
+func (hi *hashIndex) load() error {
+
+	hi.covered = 0
+	hi.entries = 0
+	hi.tail = map[string]struct{}{}
+	hi.tailLen = 0
+
+	header, err := hi.readHeader()
+	if err != nil {
+		return err
+	}
+	entries, err := hi.logEntries()
+	if err != nil {
+		return err
+	}
+	if header != nil && int64(header.Covered) <= entries {
+		hi.covered = int64(header.Covered)
+		hi.entries = int64(header.Entries)
+	}
+
+	if err = hi.readTail(); err != nil {
+		return err
+	}
+	hi.loaded = true
+
+	if hi.tailLen >= hashIndexMergeSize {
+		return hi.merge()
+	}
+	return nil
+}
+
+func (hi *hashIndex) readHeader() (*hashIndexHeader, error) {
+
+	file, err := os.Open(hi.fileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	header := &hashIndexHeader{}
+	err = binary.Read(file, binary.LittleEndian, header)
+	if err == io.EOF || err == io.ErrUnexpectedEOF || (err == nil && header.Magic != hashIndexMagic) {
+		// unusable; it will be rebuilt from the hash log
+		return nil, nil
+	}
+	return header, err
+}
+
+// logEntries returns the number of complete entries in all of the hash log segments.
+func (hi *hashIndex) logEntries() (int64, error) {
+
+	names, err := hi.logNames()
+	if err != nil {
+		return 0, err
+	}
+	var entries int64
+	for _, name := range names {
+		fi, err := os.Stat(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		entries += fi.Size() / hashLogEntrySize
+	}
+	return entries, nil
+}
+
+func (hi *hashIndex) readTail() error {
+
+	names, err := hi.logNames()
+	if err != nil {
+		return err
+	}
+	skip := hi.covered
+	for _, name := range names {
+		if err = hi.readLogTail(name, &skip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readLogTail adds the entries in a hash log segment to the tail, skipping the first skip
+// entries. skip is reduced by the number of entries that were skipped.
+func (hi *hashIndex) readLogTail(name string, skip *int64) error {
+
+	file, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	entries := fi.Size() / hashLogEntrySize
+	if *skip >= entries {
+		*skip -= entries
+		return nil
+	}
+
+	if _, err = file.Seek(*skip*hashLogEntrySize, io.SeekStart); err != nil {
+		return err
+	}
+	*skip = 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hi.tail[scanner.Text()] = struct{}{}
+		hi.tailLen++
+	}
+	return scanner.Err()
+}
+
+// contains reports if hash (hex encoded) is in the hash log.
+func (hi *hashIndex) contains(hash string) (bool, error) {
+
+	if !hi.loaded {
+		if err := hi.load(); err != nil {
+			return false, err
+		}
+	}
+	if _, ok := hi.tail[hash]; ok {
+		return true, nil
+	}
+	if hi.entries == 0 {
+		return false, nil
+	}
+
+	raw, err := hex.DecodeString(hash)
+	if err != nil {
+		return false, err
+	}
+
+	file, err := os.Open(hi.fileName)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	entry := make([]byte, sha1.Size)
+	i := sort.Search(int(hi.entries), func(i int) bool {
+		if err != nil {
+			return true
+		}
+		_, err = file.ReadAt(entry, hashIndexHeaderSize+int64(i)*sha1.Size)
+		return bytes.Compare(entry, raw) >= 0
+	})
+	if err != nil {
+		return false, fmt.Errorf("error reading hash index: %s", err)
+	}
+	if i == int(hi.entries) {
+		return false, nil
+	}
+	if _, err = file.ReadAt(entry, hashIndexHeaderSize+int64(i)*sha1.Size); err != nil {
+		return false, fmt.Errorf("error reading hash index: %s", err)
+	}
+	return bytes.Equal(entry, raw), nil
+}
+
+// add records a hash that was just appended to the hash log.
+func (hi *hashIndex) add(hash string) error {
+
+	if !hi.loaded {
+		// the hash is already in the log so loading picks it up
+		return hi.load()
+	}
+	hi.tail[hash] = struct{}{}
+	hi.tailLen++
+	if hi.tailLen >= hashIndexMergeSize {
+		return hi.merge()
+	}
+	return nil
+}
+
+// merge rewrites the sorted index file with the tail merged in. The new file is written next to
+// the old one and renamed over it.
+func (hi *hashIndex) merge() error {
+
+	tail := make([][]byte, 0, len(hi.tail))
+	for hash := range hi.tail {
+		raw, err := hex.DecodeString(hash)
+		if err != nil {
+			return fmt.Errorf("invalid hash in hash log: %s", hash)
+		}
+		tail = append(tail, raw)
+	}
+	sort.Sort(byteSlices(tail))
+
+	var old *bufio.Reader
+	if hi.entries > 0 {
+		file, err := os.Open(hi.fileName)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err = file.Seek(hashIndexHeaderSize, io.SeekStart); err != nil {
+			return err
+		}
+		old = bufio.NewReader(file)
+	}
+
+	tmpName := hi.fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return err
+	}
+	header := &hashIndexHeader{Magic: hashIndexMagic, Covered: uint64(hi.covered + hi.tailLen)}
+	header.Entries = uint64(hi.entries) + uint64(len(tail))
+
+	w := bufio.NewWriter(file)
+	err = binary.Write(w, binary.LittleEndian, header)
+
+	entry := make([]byte, sha1.Size)
+	for i := int64(0); err == nil && i < hi.entries; i++ {
+		if _, err = io.ReadFull(old, entry); err != nil {
+			break
+		}
+		for len(tail) > 0 && bytes.Compare(tail[0], entry) < 0 && err == nil {
+			_, err = w.Write(tail[0])
+			tail = tail[1:]
+		}
+		if err == nil {
+			_, err = w.Write(entry)
+		}
+	}
+	for len(tail) > 0 && err == nil {
+		_, err = w.Write(tail[0])
+		tail = tail[1:]
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("error writing hash index: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpName, hi.fileName); err != nil {
+		return err
+	}
+
+	hi.covered = int64(header.Covered)
+	hi.entries = int64(header.Entries)
+	hi.tail = map[string]struct{}{}
+	hi.tailLen = 0
+	return nil
+}
+
+type byteSlices [][]byte
+
+func (b byteSlices) Len() int           { return len(b) }
+func (b byteSlices) Less(i, j int) bool { return bytes.Compare(b[i], b[j]) < 0 }
+func (b byteSlices) Swap(i, j int)      { b[i], b[j] = b[j], b[i] }
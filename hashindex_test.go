@@ -0,0 +1,102 @@
+package astore
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestHashIndex(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	logName := testDir + "/txlog"
+	idxName := testDir + "/hashidx"
+	logNames := func() ([]string, error) { return []string{logName}, nil }
+
+	// enough entries to merge the tail into the sorted file and start a new tail
+	n := hashIndexMergeSize + 10
+	hi := newHashIndex(idxName, logNames)
+	for i := 0; i < n; i++ {
+		hash := helpHashIndexAppend(t, logName, i)
+		if err := hi.add(hash); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if hi.covered != int64(hashIndexMergeSize) || len(hi.tail) != 10 {
+		t.Errorf("expected %d covered entries and a tail of 10, got: %d, %d", hashIndexMergeSize, hi.covered, len(hi.tail))
+	}
+
+	// a new index over the same files picks up where the last one left off
+	for _, hi := range []*hashIndex{hi, newHashIndex(idxName, logNames)} {
+		for i := 0; i < n; i++ {
+			if ok, err := hi.contains(helpHashIndexHash(i)); !ok || err != nil {
+				t.Fatalf("expected hash %d to be in the index, got: %v, %v", i, ok, err)
+			}
+		}
+		if ok, _ := hi.contains(helpHashIndexHash(n)); ok {
+			t.Error("didn't expect a hash that was never added to be in the index")
+		}
+		if entries, _ := hi.logEntries(); entries != int64(n) {
+			t.Errorf("expected %d log entries, got: %d", n, entries)
+		}
+	}
+
+	// an index that covers more than the hash log is rebuilt from the log
+	os.Truncate(logName, 5*hashLogEntrySize)
+	hi = newHashIndex(idxName, logNames)
+	if ok, _ := hi.contains(helpHashIndexHash(5)); ok {
+		t.Error("didn't expect a hash that's no longer in the hash log to be in the index")
+	}
+	if ok, _ := hi.contains(helpHashIndexHash(4)); !ok {
+		t.Error("expected a hash in the hash log to be in the rebuilt index")
+	}
+}
+
+func helpHashIndexHash(i int) string {
+	return fmt.Sprintf("%X", sha1.Sum([]byte(fmt.Sprint(i))))
+}
+
+func helpHashIndexAppend(t *testing.T, logName string, i int) string {
+	hash := helpHashIndexHash(i)
+	f, err := os.OpenFile(logName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString(hash + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// A key that keeps getting the same payload still merges its hash log into the index; the tail
+// counts hash log entries, not distinct hashes.
+func TestHashIndexMergesRepeatedHashes(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.syncEnabled = false
+	opts := &AppendOptions{Dedupe: DEDUPE_NONE}
+	for i := 0; i <= hashIndexMergeSize; i++ {
+		if err = k.AppendWithOptions([]byte("same"), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	header, err := k.hashIdx.readHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header == nil || header.Covered != hashIndexMergeSize {
+		t.Fatalf("expected the index to cover %d entries, got: %+v", hashIndexMergeSize, header)
+	}
+	if ok, err := k.hashExists(fmt.Sprintf("%X", sha1.Sum([]byte("same")))); !ok || err != nil {
+		t.Errorf("expected the hash to be in the index, got: %v, %v", ok, err)
+	}
+}
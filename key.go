@@ -2,12 +2,17 @@ package astore
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc64"
 	"io"
+	"io/ioutil"
+	"log"
 	"os"
+	"time"
 
 	"github.com/skyec/astore/fluentio"
 )
@@ -15,9 +20,15 @@ import (
 const (
 	defaultDirPermissions = 0755
 	defaultFilePermisions = 0644
-	MAX_HASH_LOG_SIZE     = 41 * 1024 * 1024 // 1 million hashes
+	sealedFilePermissions = 0444
+	MAX_HASH_LOG_SIZE     = 41 * 1024 * 1024 // 1 million hashes per segment
 	MAX_CONTENT_FILE_SIZE = 500 * 1024
-	MIN_GZ_SIZE           = 160
+	MIN_GZ_SIZE           = 160 // payloads up to this size aren't compressed
+)
+
+var (
+	ErrEmptyContent    = errors.New("content is empty")
+	ErrContentTooLarge = errors.New("content is larger than the maximum allowed size")
 )
 
 type hashableKey interface {
@@ -25,16 +36,24 @@ type hashableKey interface {
 	Set(original string)
 	Get() []byte
 	Len() int
+	Original() string
 }
 
 type sha1Key struct {
-	bits []byte
+	bits     []byte
+	original string // empty if the key was made from its hash
 }
 
 func (sk *sha1Key) Set(original string) {
 	sum := sha1.Sum([]byte(original))
 	sk.bits = make([]byte, len(sum))
 	copy(sk.bits, sum[:])
+	sk.original = original
+}
+
+// Original returns the name the key was made from, if it's known.
+func (sk *sha1Key) Original() string {
+	return sk.original
 }
 
 func (sk *sha1Key) Get() []byte {
@@ -64,79 +83,356 @@ func newSha1KeyFromHash(hash []byte) *sha1Key {
 }
 
 type Key struct {
-	keyName            hashableKey // the key
-	originalKeyName    string      // original name of the key
-	baseDir            string      // base directory for the keys
-	keyDir             string      // directory where the data for a single key lives
-	keyDataDir         string      // directory in the key where the data files live
-	keyHashLogFileName string      // file name the hash log
-	initialized        bool        // flag indicating if the key directory has been initialized
-	maxHlogSz          uint        // maximum size of the hashlog; usually MAX_HASH_LOG_SIZE
-	maxContentSz       uint        // maximum size of a single append payload; usually MAX_CONTENT_FILE_SIZE
-	hashes             []string    // array of hashes of the stored parts for this key
-	syncEnabled        bool        // calls os.File.Sync for every write if enabled
-
+	keyName            hashableKey     // the key
+	originalKeyName    string          // original name of the key
+	baseDir            string          // base directory for the keys
+	keyDir             string          // directory where the data for a single key lives
+	keyDataDir         string          // directory in the key where the data files live
+	keyHashLogFileName string          // file name the hash log
+	metaFileName       string          // file name of the key's stats
+	firstFileName      string          // file name of the sequence number of the first record
+	sealedFileName     string          // file name of when the key was sealed; see Seal
+	retractedFileName  string          // file name of the key's retractions; see Retract
+	idLogFileName      string          // file name of the hashed idempotency IDs of the records; see DEDUPE_ID
+	txAppliedFileName  string          // file name of the position of the last tx log block committed; see txApplied
+	markerFileName     string          // file name of the marker of the last append; see recoverMarker
+	first              int64           // sequence number of the first record; -1 until it has been read
+	hashIdx            *hashIndex      // index of the hashes in the hash log
+	ids                *hashIndex      // index of the idempotency ID log
+	offsets            *offsetIndex    // location of each record in the content segments
+	initialized        bool            // flag indicating if the key directory has been initialized
+	segment            int             // the segment being appended to; -1 until it has been looked up
+	maxHlogSz          uint            // maximum size of a hash log segment; usually MAX_HASH_LOG_SIZE
+	maxSegmentSz       int64           // size at which a content segment is sealed; see Config.Key.SegmentSize
+	maxContentSz       uint            // maximum size of a single append payload; usually MAX_CONTENT_FILE_SIZE
+	codec              Codec           // codec used to compress payloads larger than MIN_GZ_SIZE
+	syncEnabled        bool            // calls os.File.Sync for every write if enabled
+	lockFiles          bool            // lock() also takes the key's lock file; see Config.Key.LockFiles
+	appended           *appendedRecord // the record added by the last append; nil if it was a duplicate
 }
 
 // OpenKey opens the key, keyName and basePath. A *Key or error is returned. If the DISABLE_ASTORE_FSYNC
 // environment variable is set (to any value - even zero), then writes are made without calling os.File.Sync.
 // This improves performance significantly but increases the risk of data corruption.
 func OpenKey(basePath string, hkey hashableKey) (*Key, error) {
+	return OpenKeyWithConfig(basePath, hkey, NewConfig())
+}
 
-	key := &Key{
-		keyName:      hkey,
-		baseDir:      basePath,
-		maxHlogSz:    MAX_HASH_LOG_SIZE,
-		maxContentSz: MAX_CONTENT_FILE_SIZE,
-		syncEnabled:  true,
+// OpenKeyWithConfig is OpenKey using the key settings in conf.
+func OpenKeyWithConfig(basePath string, hkey hashableKey, conf *Config) (*Key, error) {
+	k := openKeyDir(basePath, keyDirName(basePath, hkey), hkey, conf)
+	if _, err := os.Stat(k.keyDir); os.IsNotExist(err) {
+		// Either the key doesn't exist yet or a trim was interrupted
+		unlock, err := k.lock()
+		if err != nil {
+			return nil, err
+		}
+		err = k.finishTrim()
+		unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// keyDirName returns the directory of hkey under basePath.
+func keyDirName(basePath string, hkey hashableKey) string {
+	return fmt.Sprintf("%s/%02X/%02X/%02X/%s",
+		basePath,
+		hkey.Get()[0],
+		hkey.Get()[1],
+		hkey.Get()[2],
+		hkey,
+	)
+}
+
+// openKeyDir opens the key that keeps its data in keyDir. Normally that's keyDirName(basePath, hkey)
+// but astore-upgrade also uses it to read keys from the older layout.
+func openKeyDir(basePath, keyDir string, hkey hashableKey, conf *Config) *Key {
 
-		keyDir: fmt.Sprintf("%s/%s/%s/%s/%s",
-			basePath,
-			hkey.Get()[0],
-			hkey.Get()[1],
-			hkey.Get()[2],
-			hkey,
-		),
+	key := &Key{
+		keyName:         hkey,
+		originalKeyName: hkey.Original(),
+		baseDir:         basePath,
+		keyDir:          keyDir,
+		segment:         -1,
+		first:           -1,
+		maxHlogSz:       MAX_HASH_LOG_SIZE,
+		maxSegmentSz:    conf.Key.SegmentSize,
+		maxContentSz:    MAX_CONTENT_FILE_SIZE,
+		codec:           conf.Key.Codec,
+		syncEnabled:     true,
+		lockFiles:       conf.Key.LockFiles,
 	}
 
 	key.keyDataDir = fmt.Sprintf("%s/data", key.keyDir)
 	key.keyHashLogFileName = fmt.Sprintf("%s/txlog", key.keyDir)
+	key.hashIdx = newHashIndex(fmt.Sprintf("%s/hashidx", key.keyDir), key.hashLogNames)
+	key.offsets = newOffsetIndex(fmt.Sprintf("%s/offsets", key.keyDir))
+	key.metaFileName = fmt.Sprintf("%s/meta", key.keyDir)
+	key.firstFileName = fmt.Sprintf("%s/first", key.keyDir)
+	key.sealedFileName = fmt.Sprintf("%s/%s", key.keyDir, sealedFile)
+	key.retractedFileName = fmt.Sprintf("%s/%s", key.keyDir, retractedFile)
+	key.idLogFileName = fmt.Sprintf("%s/ids", key.keyDir)
+	key.ids = newHashIndex(fmt.Sprintf("%s/ididx", key.keyDir), func() ([]string, error) {
+		return []string{key.idLogFileName}, nil
+	})
+	key.txAppliedFileName = fmt.Sprintf("%s/txapplied", key.keyDir)
+	key.markerFileName = fmt.Sprintf("%s/marker", key.keyDir)
 
 	if len(os.Getenv("DISABLE_ASTORE_FSYNC")) > 0 {
 		key.syncEnabled = false
 	}
-	return key, nil
+	return key
 }
 
-// TODO: add an interface that takes an io.Reader to stream larger messags
-
-func (k *Key) checkFileSzFn(fi os.FileInfo) error {
-	if uint(fi.Size()) >= k.maxHlogSz {
-		return fmt.Errorf("reached the max hlog size: %d", k.maxHlogSz)
-	}
-	return nil
+// appendedRecord describes a record that was appended to a key.
+type appendedRecord struct {
+	key       hashableKey
+	seq       uint64 // sequence number of the record in the key
+	hash      string
+	timestamp time.Time
 }
 
 func (k *Key) writeHashLog(hash string) error {
-	if err := fluentio.OpenFile(k.keyHashLogFileName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultFilePermisions).
-		Stat(k.checkFileSzFn).
+	if err := fluentio.OpenFile(k.hashLogSegmentName(k.segment), os.O_WRONLY|os.O_APPEND|os.O_CREATE, defaultFilePermisions).
 		Write([]byte(hash + "\n")).
 		Flush().
 		Sync(k.syncEnabled).
 		Close(); err != nil {
 		return err
 	}
-	k.hashes = append(k.hashes, hash)
-	return nil
+	return k.hashIdx.add(hash)
 }
 
+// Append adds data to the key unless a record with the same content is already there.
 func (k *Key) Append(data []byte) error {
+	return k.AppendWithOptions(data, nil)
+}
+
+// AppendWithOptions adds data to the key unless the dedupe mode in opts finds that it's a
+// duplicate. Duplicates are dropped without an error; k.appended is left nil for them.
+func (k *Key) AppendWithOptions(data []byte, opts *AppendOptions) error {
 
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	k.appended = nil
 	cSize := uint(len(data))
 	if cSize > k.maxContentSz {
-		return fmt.Errorf("content size (%d) is greater than the maximum (%d)", len(data), k.maxContentSz)
+		return ErrContentTooLarge
+	}
+
+	if err := k.prepareAppend(); err != nil {
+		return err
+	}
+
+	hash := fmt.Sprintf("%X", sha1.Sum(data))
+	exists, err := k.isDuplicate(hash, opts)
+	if err != nil {
+		return err
+	}
+	if exists {
+		// TODO: add a counter for duplicate hits
+		return nil
+	}
+
+	marker := markerFor(hash, opts)
+	if marker != nil {
+		if marker.Seq, err = k.nextSeq(); err != nil {
+			return err
+		}
+		if err = k.writeMarker(marker); err != nil {
+			return err
+		}
+	}
+	ts := time.Now()
+	seq, err := k.writeRecord(data, hash, ts, 0)
+	if err != nil {
+		return err
+	}
+	if err = k.appendedWith(marker); err != nil {
+		return err
+	}
+	k.appended = &appendedRecord{key: k.keyName, seq: seq, hash: hash, timestamp: ts}
+	k.updateStats(k.appended, int64(len(data)))
+	return nil
+}
+
+// AppendFrom streams a payload of size bytes from r to the key. Use a size < 0 if the size isn't
+// known up front. The payload is hashed, compressed and check-summed as it is copied to the content
+// file so it is never held in memory. Since the hash isn't known until the payload has been
+// written, duplicates are detected afterwards and the block is rolled back by truncating the
+// content file. Payloads of a known size up to MIN_GZ_SIZE aren't compressed.
+//
+// ErrContentTooLarge is returned as soon as more than the maximum content size has been read and
+// ErrEmptyContent is returned if r doesn't contain anything.
+func (k *Key) AppendFrom(r io.Reader, size int64) error {
+	return k.AppendFromWithOptions(r, size, nil)
+}
+
+// AppendFromWithOptions is AppendFrom with the duplicates found by the dedupe mode in opts. See
+// AppendWithOptions.
+func (k *Key) AppendFromWithOptions(r io.Reader, size int64, opts *AppendOptions) error {
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	k.appended = nil
+	if size > int64(k.maxContentSz) {
+		return ErrContentTooLarge
+	}
+
+	if err := k.prepareAppend(); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(k.contentSegmentName(k.segment), os.O_CREATE|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	start, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	// Reserve space for the header. It's filled in once the CRC and length are known.
+	buff := bufio.NewWriter(file)
+	if _, err = buff.Write(make([]byte, headerSizeV3)); err != nil {
+		return err
+	}
+
+	codec := k.codec
+	if size >= 0 && size <= MIN_GZ_SIZE {
+		codec = CODEC_NONE
+	}
+	crc := crc64.New(crc64.MakeTable(crc64.ISO))
+	stored := &countingWriter{w: io.MultiWriter(buff, crc)}
+	enc, err := newBlockEncoder(codec, stored)
+	if err != nil {
+		return k.rollback(file, start, err)
 	}
 
+	hasher := sha1.New()
+	tee := io.TeeReader(io.LimitReader(r, int64(k.maxContentSz)+1), hasher)
+	n, err := io.Copy(enc, tee)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err == nil {
+		err = buff.Flush()
+	}
+
+	switch {
+	case err != nil:
+	case n > int64(k.maxContentSz):
+		err = ErrContentTooLarge
+	case n == 0:
+		err = ErrEmptyContent
+	case size >= 0 && n != size:
+		err = fmt.Errorf("short content: expected: %d bytes, got: %d", size, n)
+	}
+	if err != nil {
+		return k.rollback(file, start, err)
+	}
+
+	hash := fmt.Sprintf("%X", hasher.Sum(nil))
+	exists, err := k.isDuplicate(hash, opts)
+	if err != nil || exists {
+		return k.rollback(file, start, err)
+	}
+	seq, err := k.nextSeq()
+	if err != nil {
+		return k.rollback(file, start, err)
+	}
+	marker := markerFor(hash, opts)
+	if marker != nil {
+		marker.Seq = seq
+		if err = k.writeMarker(marker); err != nil {
+			return k.rollback(file, start, err)
+		}
+	}
+	ts := time.Now()
+
+	header := &bytes.Buffer{}
+	err = binary.Write(header, binary.LittleEndian, &contentHeaderV3{
+		Magic:     magicNumberVersioned,
+		Version:   blockVersion,
+		Codec:     codec,
+		CRC64:     crc.Sum64(),
+		Length:    uint64(stored.n),
+		RawLength: uint64(n),
+		Timestamp: ts.UnixNano(),
+		Seq:       seq,
+	})
+	if err != nil {
+		return k.rollback(file, start, fmt.Errorf("error encoding header: %s", err))
+	}
+	if _, err = file.WriteAt(header.Bytes(), start); err != nil {
+		return k.rollback(file, start, fmt.Errorf("error writing header: %s", err))
+	}
+	if k.syncEnabled {
+		if err = file.Sync(); err != nil {
+			return fmt.Errorf("error syncing content: %s", err)
+		}
+	}
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("error closing content: %s", err)
+	}
+	compression.add(n, stored.n)
+	if err = k.writeHashLog(hash); err != nil {
+		return err
+	}
+	if err = k.indexOffset(seq, k.segment, start); err != nil {
+		return err
+	}
+	if err = k.appendedWith(marker); err != nil {
+		return err
+	}
+	k.appended = &appendedRecord{key: k.keyName, seq: seq, hash: hash, timestamp: ts}
+	k.updateStats(k.appended, n)
+	return nil
+}
+
+// writeRecord adds a record to the current segment: the content block, the hash log entry and the
+// offset index entry. It returns the record's sequence number. A zero ts is written for records
+// that don't have a timestamp. flags are stored in the block header.
+func (k *Key) writeRecord(data []byte, hash string, ts time.Time, flags uint16) (uint64, error) {
+
+	seq, offset, err := k.writeContent(data, ts, flags)
+	if err != nil {
+		return 0, err
+	}
+	if err = k.writeHashLog(hash); err != nil {
+		return 0, err
+	}
+	if err = k.indexOffset(seq, k.segment, offset); err != nil {
+		return 0, err
+	}
+	return seq, nil
+}
+
+// rollback truncates the content file back to where the current block started. cause is
+// returned unless the truncate fails.
+func (k *Key) rollback(file *os.File, start int64, cause error) error {
+	if err := file.Truncate(start); err != nil {
+		return fmt.Errorf("error rolling back content to %d: %s (cause: %v)", start, err, cause)
+	}
+	return cause
+}
+
+// prepareAppend makes sure the key directory exists, that the key isn't sealed, that the current
+// segment doesn't end in an unfinished block, that the last append's marker has been recorded and
+// that the segment has room.
+func (k *Key) prepareAppend() error {
+
 	if !k.initialized {
 		_, err := k.initalizeDirectory()
 		if err != nil {
@@ -144,31 +440,102 @@ func (k *Key) Append(data []byte) error {
 		}
 
 	}
-	if k.hashes == nil {
-		if err := k.loadHashes(); err != nil {
-			return err
-		}
+	sealed, err := k.sealedAt()
+	if err != nil {
+		return err
+	}
+	if !sealed.IsZero() {
+		return ErrKeySealed
+	}
+	if _, err := k.lastSegment(); err != nil {
+		return err
 	}
+	if err := k.truncateTail(); err != nil {
+		return err
+	}
+	if err := k.recoverMarker(); err != nil {
+		return err
+	}
+	return k.rollSegment()
+}
 
-	hash := fmt.Sprintf("%X", sha1.Sum(data))
-	if k.hashExists(hash) {
-		// TODO: add a counter for duplicate hits
+// truncateTail removes content written to the current segment after the last record in the hash
+// log. It's left by an append that didn't finish, like the zeroed header AppendFrom reserves
+// before it streams the payload. The next append would go after it and readers stop at it.
+func (k *Key) truncateTail() error {
+
+	fi, err := os.Stat(k.contentSegmentName(k.segment))
+	if os.IsNotExist(err) {
 		return nil
 	}
-
-	if err := k.writeContent(data); err != nil {
+	if err != nil {
+		return err
+	}
+	count, err := k.nextSeq()
+	if err != nil {
+		return err
+	}
+	end, err := k.endPosition(count)
+	if err != nil {
 		return err
 	}
-	return k.writeHashLog(hash)
+	if end.Segment != k.segment {
+		// the records all end in earlier segments
+		end.Offset = 0
+	}
+	if fi.Size() <= end.Offset {
+		return nil
+	}
+	log.Printf("WARNING: truncating %d bytes of unfinished content from key: %s", fi.Size()-end.Offset, k.keyName)
+	return os.Truncate(k.contentSegmentName(k.segment), end.Offset)
 }
 
-func (k *Key) hashExists(hash string) bool {
-	for _, h := range k.hashes {
-		if h == hash {
-			return true
-		}
+func (k *Key) hashExists(hash string) (bool, error) {
+	return k.hashIdx.contains(hash)
+}
+
+// nextSeq returns the sequence number of the next record appended to the key.
+func (k *Key) nextSeq() (uint64, error) {
+	first, err := k.firstSeq()
+	if err != nil {
+		return 0, err
 	}
-	return false
+	n, err := k.hashIdx.logEntries()
+	return first + uint64(n), err
+}
+
+// firstSeq returns the sequence number of the first record in the key. It's 0 unless records
+// have been trimmed from the key by a retention policy; the records keep their sequence numbers.
+func (k *Key) firstSeq() (uint64, error) {
+
+	if k.first >= 0 {
+		return uint64(k.first), nil
+	}
+	b, err := ioutil.ReadFile(k.firstFileName)
+	switch {
+	case os.IsNotExist(err):
+		k.first = 0
+	case err != nil:
+		return 0, err
+	case len(b) != 8:
+		return 0, fmt.Errorf("bad first sequence number file: %s", k.firstFileName)
+	default:
+		k.first = int64(binary.LittleEndian.Uint64(b))
+	}
+	return uint64(k.first), nil
+}
+
+// setFirstSeq records the sequence number of the first record in a key that doesn't have any
+// records yet.
+func (k *Key) setFirstSeq(first uint64) error {
+
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, first)
+	if err := ioutil.WriteFile(k.firstFileName, b, defaultFilePermisions); err != nil {
+		return err
+	}
+	k.first = int64(first)
+	return nil
 }
 
 const magicNumber uint32 = 0xff00ff00
@@ -179,69 +546,226 @@ type contentHeader struct {
 	Length uint64
 }
 
-func (k *Key) writeContent(data []byte) error {
+// Content blocks are written with a versioned header that records how the payload is compressed
+// and, from version 3, when the block was appended and its sequence number in the key. Blocks
+// with the original header (magicNumber) are still read; their payload is never compressed.
+const (
+	magicNumberVersioned uint32 = 0xff00ff02
+	blockVersion         uint8  = 3
+	headerSizeV2                = 32 // magic(4) + version(1) + codec(1) + flags(2) + crc64(8) + length(8) + raw length(8)
+	headerSizeV3                = 48 // headerSizeV2 + timestamp(8) + seq(8)
+)
+
+type contentHeaderV3 struct {
+	Magic     uint32
+	Version   uint8
+	Codec     Codec
+	Flags     uint16 // blockFlagRetraction for a retraction; the other bits are reserved
+	CRC64     uint64 // CRC64 of the payload as stored
+	Length    uint64 // length of the payload as stored
+	RawLength uint64 // length of the payload before it was compressed
+	Timestamp int64  // when the block was appended; Unix time in nanoseconds or 0 if it isn't known
+	Seq       uint64 // position of the record in the key, starting at 0
+}
+
+var (
+	errBadMagic   = errors.New("bad magic number")
+	errBadVersion = errors.New("unsupported block version")
+)
+
+// blockHeader is a content block header of any supported version. Fields that aren't in the
+// version that was read are zero.
+type blockHeader struct {
+	contentHeaderV3
+	size int64 // size of the header on disk
+}
+
+// readBlockHeader reads the next block header from r. io.EOF is returned if r is at the end
+// and io.ErrUnexpectedEOF if the header is cut short. The header is returned with errBadMagic
+// and errBadVersion so that the caller can report what it found.
+func readBlockHeader(r io.Reader) (*blockHeader, error) {
 
-	header := &contentHeader{magicNumber, crc64.Checksum(data, crc64.MakeTable(crc64.ISO)), uint64(len(data))}
+	h := &blockHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &h.Magic); err != nil {
+		return h, err
+	}
+
+	var err error
+	switch h.Magic {
+	case magicNumber:
+		v1 := struct{ CRC64, Length uint64 }{}
+		err = binary.Read(r, binary.LittleEndian, &v1)
+		h.Version = 1
+		h.CRC64 = v1.CRC64
+		h.Length = v1.Length
+		h.RawLength = v1.Length
+		h.size = headerSize
+	case magicNumberVersioned:
+		err = binary.Read(r, binary.LittleEndian, &h.Version)
+		if err == nil && (h.Version < 2 || h.Version > blockVersion) {
+			return h, errBadVersion
+		}
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, &h.Codec)
+		}
+		v2 := struct {
+			Flags                    uint16
+			CRC64, Length, RawLength uint64
+		}{}
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, &v2)
+		}
+		h.Flags = v2.Flags
+		h.CRC64 = v2.CRC64
+		h.Length = v2.Length
+		h.RawLength = v2.RawLength
+		h.size = headerSizeV2
+		if err == nil && h.Version >= 3 {
+			v3 := struct {
+				Timestamp int64
+				Seq       uint64
+			}{}
+			err = binary.Read(r, binary.LittleEndian, &v3)
+			h.Timestamp = v3.Timestamp
+			h.Seq = v3.Seq
+			h.size = headerSizeV3
+		}
+	default:
+		return h, errBadMagic
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return h, err
+}
+
+// writeContent appends a block with data to the current segment. It returns the sequence number
+// of the record and the offset of the block in the segment.
+func (k *Key) writeContent(data []byte, ts time.Time, flags uint16) (uint64, int64, error) {
+
+	stored, codec, err := encodeBlock(k.codec, data)
+	if err != nil {
+		return 0, 0, err
+	}
+	seq, err := k.nextSeq()
+	if err != nil {
+		return 0, 0, err
+	}
+	header := &contentHeaderV3{
+		Magic:     magicNumberVersioned,
+		Version:   blockVersion,
+		Codec:     codec,
+		Flags:     flags,
+		CRC64:     crc64.Checksum(stored, crc64.MakeTable(crc64.ISO)),
+		Length:    uint64(len(stored)),
+		RawLength: uint64(len(data)),
+		Timestamp: unixNano(ts),
+		Seq:       seq,
+	}
 
-	file, err := os.OpenFile(fmt.Sprintf("%s/content.dat", k.keyDataDir), os.O_CREATE|os.O_APPEND|os.O_WRONLY, defaultFilePermisions)
+	file, err := os.OpenFile(k.contentSegmentName(k.segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error opening content: %s", err)
+	}
+	defer file.Close()
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, 0, err
+	}
 	buff := bufio.NewWriter(file)
 	err = binary.Write(buff, binary.LittleEndian, header)
 	if err != nil {
-		return fmt.Errorf("error encoding header: %s", err)
+		return 0, 0, fmt.Errorf("error encoding header: %s", err)
 	}
-	n, err := buff.Write(data)
+	n, err := buff.Write(stored)
 	if err != nil {
-		return fmt.Errorf("error buffering content: %s", err)
+		return 0, 0, fmt.Errorf("error buffering content: %s", err)
 	}
-	if n < len(data) {
-		return fmt.Errorf("short write buffering content: expected: %d, got: %d", len(data), n)
+	if n < len(stored) {
+		return 0, 0, fmt.Errorf("short write buffering content: expected: %d, got: %d", len(stored), n)
 	}
 	err = buff.Flush()
 	if err != nil {
-		return fmt.Errorf("error committing content: %s", err)
+		return 0, 0, fmt.Errorf("error committing content: %s", err)
 	}
 	if k.syncEnabled {
 		if err = file.Sync(); err != nil {
-			return fmt.Errorf("error syncing content: %s", err)
+			return 0, 0, fmt.Errorf("error syncing content: %s", err)
 		}
 	}
 	err = file.Close()
 	if err != nil {
-		return fmt.Errorf("error closing content: %s", err)
+		return 0, 0, fmt.Errorf("error closing content: %s", err)
 	}
-	return nil
+	compression.add(int64(len(data)), int64(len(stored)))
+	return seq, offset, nil
 
 }
 
-func (k *Key) ReadEach(r ReadFunc) error {
-	file, err := os.Open(fmt.Sprintf("%s/content.dat", k.keyDataDir))
-	for err == nil {
-		header := &contentHeader{}
-		if err = binary.Read(file, binary.LittleEndian, header); err == nil {
-			if header.Magic != magicNumber {
-				return fmt.Errorf("invalid content block; magic %X doesn't match magic number: %X", header.Magic, magicNumber)
-			}
-			err = r(io.LimitReader(file, int64(header.Length)))
-		}
-	}
-	if err == io.EOF || os.IsNotExist(err) {
-		return nil
+// unixNano returns ts as a block header timestamp.
+func unixNano(ts time.Time) int64 {
+	if ts.IsZero() {
+		return 0
 	}
+	return ts.UnixNano()
+}
+
+// ReadEach calls r for each record stored in the key. It stops at the first corrupt block.
+func (k *Key) ReadEach(r ReadFunc) error {
+	return k.ReadEachWithOptions(r, nil)
+}
+
+// ReadEachWithOptions calls r for each record stored in the key. Every payload is verified against
+// its CRC64 before r sees it. A block that fails the check is returned as a *CorruptBlockError or,
+// if opts.SkipCorrupt is set, logged and skipped. Blocks with a bad header can't be skipped since
+// the start of the next block isn't known. Compressed payloads are decompressed before r sees them.
+func (k *Key) ReadEachWithOptions(r ReadFunc, opts *ReadOptions) error {
+	return k.ReadEachRecord(func(rec *Record) error {
+		return r(rec.Data)
+	}, opts)
+}
+
+// ReadEachRecord is ReadEachWithOptions for callers that want the metadata stored with each
+// record as well as its payload.
+func (k *Key) ReadEachRecord(f RecordFunc, opts *ReadOptions) error {
+	_, err := k.ReadFrom(Position{}, 0, f, opts)
 	return err
 }
 
+// Count returns the number of records in the key. It's the number of entries in the hash log so
+// the log doesn't need to be read. Records trimmed by a retention policy aren't counted.
 func (k *Key) Count() (int, error) {
 
-	if err := k.loadHashes(); err != nil {
-		return 0, err
-	}
+	n, err := k.hashIdx.logEntries()
+	return int(n), err
+}
 
-	return len(k.hashes), nil
+type ReadFunc func(r io.Reader) error
 
+// Record is a record read from a key along with the metadata stored with it.
+type Record struct {
+	Seq       uint64    // position of the record in the key, starting at 0
+	Timestamp time.Time // when the record was appended; zero for records written before this was kept
+	Hash      string    // hex encoded SHA1 of the payload
+	Version   uint8     // block format version the record was written with
+	Data      io.Reader // the payload
+
+	// Only seen when ReadOptions.IncludeRetracted is set
+	Retraction bool // the record is a retraction of an earlier record; see Key.Retract
+	Retracted  bool // the record has been retracted
 }
 
-type ReadFunc func(r io.Reader) error
+// RecordFunc is called with each record read from a key. rec.Data is only valid until it returns.
+type RecordFunc func(rec *Record) error
+
+// ReadOptions change how records are read from a key. A nil *ReadOptions uses the defaults.
+type ReadOptions struct {
+	SkipCorrupt      bool // skip blocks that fail their CRC check instead of stopping at the first one
+	IncludeRetracted bool // read the records that have been retracted and the retractions as well
+}
 
+// GetKeyName returns the name the key was opened with. It's empty if the key was opened by its
+// hash, like the tx log committers do.
 func (k *Key) GetKeyName() string {
 	return k.originalKeyName
 }
@@ -249,6 +773,10 @@ func (k *Key) GetKeyName() string {
 func (k *Key) initalizeDirectory() (*Key, error) {
 
 	if _, err := os.Stat(k.keyDir); os.IsNotExist(err) {
+		// A deleted key isn't created again until it has been reclaimed
+		if _, err = os.Stat(k.keyDir + deletedSuffix); err == nil {
+			return nil, ErrKeyDeleted
+		}
 		err = os.MkdirAll(k.keyDir, defaultDirPermissions)
 		if err != nil {
 			return nil, fmt.Errorf("error creating key path: %s", err)
@@ -268,22 +796,23 @@ func sanitizeKeyName(key string) string {
 	return fmt.Sprintf("%X", sha1.Sum([]byte(key)))
 }
 
-func (k *Key) loadHashes() error {
+// readHashLog returns every hash in a hash log segment, in order.
+func (k *Key) readHashLog(seg int) ([]string, error) {
 
-	k.hashes = []string{}
-	f, err := os.Open(k.keyHashLogFileName)
+	hashes := []string{}
+	f, err := os.Open(k.hashLogSegmentName(seg))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil
+			return hashes, nil
 		}
-		return err
+		return nil, err
 	}
 	defer f.Close()
 
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
-		k.hashes = append(k.hashes, scanner.Text())
+		hashes = append(hashes, scanner.Text())
 	}
 
-	return scanner.Err()
+	return hashes, scanner.Err()
 }
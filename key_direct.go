@@ -1,18 +1,47 @@
 package astore
 
+import "io"
+
 // Implements the appendableKey interface for writes that go directly to the keystore
 type directKey struct {
-	path string
+	path     string
+	conf     *Config
+	onCommit func(*appendedRecord) // called with each record appended to a key, if set
+}
+
+func newDirectKey(basepath string, conf *Config, onCommit func(*appendedRecord)) (appendableKey, error) {
+	return &directKey{path: basepath, conf: conf, onCommit: onCommit}, nil
 }
 
-func newDirectKey(basepath string) (appendableKey, error) {
-	return &directKey{path: basepath}, nil
+func (kd *directKey) Append(key hashableKey, value []byte, opts *AppendOptions) (WriteStatus, error) {
+	k, err := OpenKeyWithConfig(kd.path, key, kd.conf)
+	if err != nil {
+		return WRITE_APPENDED, err
+	}
+	if err = k.AppendWithOptions(value, opts); err != nil {
+		return WRITE_APPENDED, err
+	}
+	return kd.committed(k), nil
 }
 
-func (kd *directKey) Append(key hashableKey, value []byte) error {
-	k, err := OpenKey(kd.path, key)
+func (kd *directKey) AppendFrom(key hashableKey, r io.Reader, size int64, opts *AppendOptions) (WriteStatus, error) {
+	k, err := OpenKeyWithConfig(kd.path, key, kd.conf)
 	if err != nil {
-		return err
+		return WRITE_APPENDED, err
+	}
+	if err = k.AppendFromWithOptions(r, size, opts); err != nil {
+		return WRITE_APPENDED, err
+	}
+	return kd.committed(k), nil
+}
+
+// committed passes the record that was appended to onCommit and returns the status of the write.
+func (kd *directKey) committed(k *Key) WriteStatus {
+	if k.appended == nil {
+		return WRITE_DUPLICATE
+	}
+	if kd.onCommit != nil {
+		kd.onCommit(k.appended)
 	}
-	return k.Append(value)
+	return WRITE_APPENDED
 }
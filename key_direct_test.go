@@ -10,14 +10,14 @@ func TestDirectKey(t *testing.T) {
 	testDir := mkTestDir()
 	defer rmTestDir(testDir)
 
-	dk, err := newDirectKey(testDir)
+	dk, err := newDirectKey(testDir, NewConfig(), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	value := []byte("bar")
 	key := newSha1Key("foo")
-	err = dk.Append(key, value)
+	_, err = dk.Append(key, value, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,137 @@
+package astore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// A key's content and hash log are split into numbered segments. Segment 0 uses the original
+// file names (data/content.dat and txlog) so keys written before segments existed are read as a
+// single segment. Once the current content segment reaches the configured segment size, or its
+// hash log reaches MAX_HASH_LOG_SIZE, a new segment is started and the old one is sealed by
+// making its files read-only. Records are numbered across segments in the order they were
+// written.
+
+func (k *Key) contentSegmentName(seg int) string {
+	if seg == 0 {
+		return fmt.Sprintf("%s/content.dat", k.keyDataDir)
+	}
+	return fmt.Sprintf("%s/content-%08d.dat", k.keyDataDir, seg)
+}
+
+func (k *Key) hashLogSegmentName(seg int) string {
+	if seg == 0 {
+		return k.keyHashLogFileName
+	}
+	return fmt.Sprintf("%s-%08d", k.keyHashLogFileName, seg)
+}
+
+// lastSegment returns the number of the segment being appended to. A new segment's content file
+// is created when the segment is started so the last segment is the highest numbered one.
+func (k *Key) lastSegment() (int, error) {
+
+	if k.segment >= 0 {
+		return k.segment, nil
+	}
+
+	names, err := filepath.Glob(k.keyDataDir + "/content-*.dat")
+	if err != nil {
+		return 0, err
+	}
+	last := 0
+	for _, name := range names {
+		var seg int
+		if _, err := fmt.Sscanf(filepath.Base(name), "content-%08d.dat", &seg); err != nil {
+			continue
+		}
+		if seg > last {
+			last = seg
+		}
+	}
+	k.segment = last
+	return last, nil
+}
+
+// hashLogNames returns the file names of the hash log segments, in order. Some of them may not
+// exist yet.
+func (k *Key) hashLogNames() ([]string, error) {
+
+	last, err := k.lastSegment()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, last+1)
+	for seg := 0; seg <= last; seg++ {
+		names = append(names, k.hashLogSegmentName(seg))
+	}
+	return names, nil
+}
+
+// rollSegment starts a new segment if the current one is full.
+func (k *Key) rollSegment() error {
+
+	full, err := k.segmentFull(k.segment)
+	if err != nil || !full {
+		return err
+	}
+
+	next := k.segment + 1
+	file, err := os.OpenFile(k.contentSegmentName(next), os.O_CREATE|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return fmt.Errorf("error creating segment: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	// The new segment already exists so if sealing fails the old segment is simply left
+	// writable. It won't be appended to again.
+	for _, name := range []string{k.contentSegmentName(k.segment), k.hashLogSegmentName(k.segment)} {
+		if err = os.Chmod(name, sealedFilePermissions); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error sealing segment: %s", err)
+		}
+	}
+	k.segment = next
+	return nil
+}
+
+func (k *Key) segmentFull(seg int) (bool, error) {
+
+	fi, err := os.Stat(k.contentSegmentName(seg))
+	if err == nil && fi.Size() >= k.maxSegmentSz {
+		return true, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+
+	fi, err = os.Stat(k.hashLogSegmentName(seg))
+	if err == nil && uint(fi.Size()) >= k.maxHlogSz {
+		return true, nil
+	}
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	return false, nil
+}
+
+// segmentBase returns the sequence number of the first record in seg.
+func (k *Key) segmentBase(seg int) (uint64, error) {
+
+	n, err := k.firstSeq()
+	if err != nil {
+		return 0, err
+	}
+	for s := 0; s < seg; s++ {
+		fi, err := os.Stat(k.hashLogSegmentName(s))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		n += uint64(fi.Size() / hashLogEntrySize)
+	}
+	return n, nil
+}
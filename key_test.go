@@ -2,12 +2,17 @@ package astore
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpenKey(t *testing.T) {
@@ -17,7 +22,7 @@ func TestOpenKey(t *testing.T) {
 
 	_, err := OpenKey(testDir, newSha1Key("test-key"))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 }
 
@@ -27,7 +32,7 @@ func TestKeyAppend(t *testing.T) {
 
 	k, err := OpenKey(testDir, newSha1Key("test-key"))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	err = k.Append([]byte("This is a test"))
@@ -43,7 +48,7 @@ func TestKeyReadEach(t *testing.T) {
 	key := newSha1Key("test-key")
 	k, err := OpenKey(testDir, key)
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	m1 := []byte("This is a test 1.")
@@ -57,7 +62,7 @@ func TestKeyReadEach(t *testing.T) {
 	// Re-open the key again to test the common case
 	k, err = OpenKey(testDir, key)
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 	err = k.Append(m2)
 	if err != nil {
@@ -90,7 +95,7 @@ func TestKeyNewReadEach(t *testing.T) {
 	key := newSha1Key("test-key")
 	k, err := OpenKey(testDir, key)
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	m1 := []byte("This is a test 1.")
@@ -104,7 +109,7 @@ func TestKeyNewReadEach(t *testing.T) {
 	// Re-open the key again to test the common case
 	k, err = OpenKey(testDir, newSha1Key("test-key"))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 	err = k.Append(m2)
 	if err != nil {
@@ -138,7 +143,7 @@ func TestSkipDuplicates(t *testing.T) {
 
 	k, err := OpenKey(testDir, newSha1Key("test-key"))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	m1 := []byte("This is a test 1.")
@@ -177,7 +182,7 @@ func TestReadBeforeAppend(t *testing.T) {
 	originalKey := "testing key"
 	k, err := OpenKey(testDir, newSha1Key(originalKey))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	err = k.ReadEach(func(r io.Reader) error { return nil })
@@ -196,7 +201,7 @@ func TestGetOriginalKeyName(t *testing.T) {
 	originalKey := "this is the original key"
 	k, err := OpenKey(testDir, newSha1Key(originalKey))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	if k.GetKeyName() != originalKey {
@@ -214,7 +219,7 @@ func TestKeyIsSanitized(t *testing.T) {
 	originalKey := "this key should be hashed ./"
 	k, err := OpenKey(testDir, newSha1Key(originalKey))
 	if err != nil {
-		t.Fatalf("Failed to open key:", err)
+		t.Fatal("Failed to open key:", err)
 	}
 
 	if strings.Contains(k.keyDir, originalKey) {
@@ -254,10 +259,315 @@ func TestMaxHashLogSz(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// a full hash log starts a new segment instead of rejecting the append
 	k.maxHlogSz = 10
 	err = k.Append([]byte("second record"))
-	if err == nil {
-		t.Error("Saving too many records didn't generate an error")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.segment != 1 {
+		t.Error("expected the second record in segment 1, got:", k.segment)
+	}
+}
+
+func TestKeySegments(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	conf := NewConfig()
+	conf.Key.SegmentSize = 2 * (headerSizeV3 + 2)
+	records := []string{"r0", "r1", "r2", "r3", "r4"}
+	for _, rec := range records {
+		// reopen the key for each append, the way the direct writer does
+		k, err := OpenKeyWithConfig(testDir, newSha1Key("segmented"), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = k.Append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	k, err := OpenKeyWithConfig(testDir, newSha1Key("segmented"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a duplicate of a record in a sealed segment is still detected
+	if err = k.Append([]byte("r0")); err != nil {
+		t.Fatal(err)
+	}
+	if last, _ := k.lastSegment(); last != 2 {
+		t.Error("expected 3 segments, got:", last+1)
+	}
+	if n, _ := k.Count(); n != len(records) {
+		t.Errorf("wrong count: expected %d: got: %d", len(records), n)
+	}
+
+	got := []string{}
+	err = k.ReadEach(func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		got = append(got, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(records) {
+		t.Errorf("records don't match. Expected: %v, got: %v", records, got)
+	}
+
+	for _, name := range []string{k.contentSegmentName(0), k.hashLogSegmentName(1)} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != sealedFilePermissions {
+			t.Errorf("expected sealed segment file %s to be read-only, got: %s", name, fi.Mode())
+		}
+	}
+}
+
+func TestKeyAppendFrom(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m1 := "streamed with a known size"
+	m2 := "streamed with an unknown size"
+	if err = k.AppendFrom(strings.NewReader(m1), int64(len(m1))); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AppendFrom(strings.NewReader(m2), -1); err != nil {
+		t.Fatal(err)
+	}
+	// a plain append of the same payload is a duplicate
+	if err = k.Append([]byte(m1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, _ := k.Count(); n != 2 {
+		t.Error("wrong count: expected 2: got:", n)
+	}
+
+	buffer := &bytes.Buffer{}
+	err = k.ReadEach(func(r io.Reader) error {
+		_, err := io.Copy(buffer, r)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buffer.String() != m1+m2 {
+		t.Errorf("Buffers don't match. Expected:\n%s\nGot:\n%s", m1+m2, buffer)
+	}
+}
+
+func TestKeyAppendFromRollsBack(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k.maxContentSz = 10
+
+	if err = k.AppendFrom(strings.NewReader("first"), -1); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(k.contentSegmentName(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type fix struct {
+		name string
+		data string
+		size int64
+		err  error
+	}
+	for _, f := range []fix{
+		fix{"duplicate", "first", -1, nil},
+		fix{"too large", "12345678901", -1, ErrContentTooLarge},
+		fix{"too large size", "1", 11, ErrContentTooLarge},
+		fix{"empty", "", -1, ErrEmptyContent},
+	} {
+		err = k.AppendFrom(strings.NewReader(f.data), f.size)
+		if err != f.err {
+			t.Errorf("%s: expected error: %v, got: %v", f.name, f.err, err)
+		}
+		after, _ := os.Stat(k.contentSegmentName(0))
+		if after.Size() != fi.Size() {
+			t.Errorf("%s: content wasn't rolled back. Expected %d bytes, got: %d", f.name, fi.Size(), after.Size())
+		}
+	}
+
+	if err = k.AppendFrom(strings.NewReader("short"), 6); err == nil {
+		t.Error("expected an error when the content is shorter than the size")
+	}
+	if n, _ := k.Count(); n != 1 {
+		t.Error("wrong count: expected 1: got:", n)
+	}
+}
+
+func TestKeyAppendTruncatesUnfinishedBlock(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AppendFrom(strings.NewReader("first"), -1); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash part way through AppendFrom leaves the reserved header, zeroed, and some payload
+	file, err := os.OpenFile(k.contentSegmentName(0), os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(make([]byte, headerSizeV3))
+	file.Write([]byte("part of the payload"))
+	file.Close()
+
+	k, err = OpenKey(testDir, newSha1Key("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AppendFrom(strings.NewReader("second"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Append([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	err = k.ReadEach(func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		got = append(got, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "first,second,third" {
+		t.Errorf("unexpected records: %v", got)
+	}
+}
+
+func TestKeyRecoversAppendMarker(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	withID := func(id string) *AppendOptions {
+		return &AppendOptions{Dedupe: DEDUPE_ID, ID: id}
+	}
+	k, err := OpenKey(testDir, newSha1Key("test-key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AppendWithOptions([]byte("first"), withID("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash after the record but before its ID was logged
+	os.Remove(k.idLogFileName)
+	os.Remove(k.keyDir + "/ididx")
+	k, _ = OpenKey(testDir, newSha1Key("test-key"))
+	if err = k.AppendWithOptions([]byte("retry"), withID("1")); err != nil {
+		t.Fatal(err)
+	}
+	if k.appended != nil {
+		t.Error("expected the retry to be dropped as a duplicate")
+	}
+
+	// A crash after the marker but before its record; the marker isn't trusted
+	if err = k.writeMarker(&appendMarker{Seq: 1, Hash: idHash("lost"), ID: idHash("2")}); err != nil {
+		t.Fatal(err)
+	}
+	k, _ = OpenKey(testDir, newSha1Key("test-key"))
+	if err = k.AppendWithOptions([]byte("second"), withID("2")); err != nil {
+		t.Fatal(err)
+	}
+	if k.appended == nil {
+		t.Error("expected the record to be appended")
+	}
+
+	got := []string{}
+	err = k.ReadEach(func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		got = append(got, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "first,second" {
+		t.Errorf("unexpected records: %v", got)
+	}
+}
+
+func TestKeyReadEachDetectsCorruption(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	key := newSha1Key("test-key")
+	k, err := OpenKey(testDir, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []string{"record 0", "record 1", "record 2"}
+	for _, rec := range records {
+		if err = k.Append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// flip a bit in the payload of the second record
+	secondOffset := int64(headerSizeV3 + len(records[0]))
+	file, err := os.OpenFile(k.contentSegmentName(0), os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = file.WriteAt([]byte("R"), secondOffset+headerSizeV3); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	read := func(opts *ReadOptions) ([]string, error) {
+		got := []string{}
+		err := k.ReadEachWithOptions(func(r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			got = append(got, string(b))
+			return err
+		}, opts)
+		return got, err
+	}
+
+	got, err := read(nil)
+	cerr, ok := err.(*CorruptBlockError)
+	if !ok {
+		t.Fatalf("expected a *CorruptBlockError, got: %v", err)
+	}
+	if cerr.Index != 1 || cerr.Offset != secondOffset || cerr.Key != key.String() {
+		t.Errorf("unexpected error details: %+v", cerr)
+	}
+	if len(got) != 1 || got[0] != records[0] {
+		t.Errorf("expected only the first record before the corrupt one, got: %v", got)
+	}
+
+	got, err = read(&ReadOptions{SkipCorrupt: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0] != records[0] || got[1] != records[2] {
+		t.Errorf("expected the corrupt record to be skipped, got: %v", got)
 	}
 }
 
@@ -276,6 +586,440 @@ func rmTestDir(dirName string) {
 	}
 	err := os.RemoveAll(dirName)
 	if err != nil {
-		log.Fatal("Failed to remove the test dir '%s': %s", dirName, err)
+		log.Fatalf("Failed to remove the test dir '%s': %s", dirName, err)
+	}
+}
+
+func TestKeyCompression(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	event := strings.Repeat(`{"type":"change","field":"status","old":"pending","new":"done"},`, 20)
+	records := []string{"small", event, event + "streamed"}
+
+	for _, codec := range []Codec{CODEC_NONE, CODEC_SNAPPY, CODEC_GZIP} {
+		conf := NewConfig()
+		conf.Key.Codec = codec
+		k, err := OpenKeyWithConfig(testDir, newSha1Key(codec.String()), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = k.Append([]byte(records[0])); err != nil {
+			t.Fatal(err)
+		}
+		if err = k.Append([]byte(records[1])); err != nil {
+			t.Fatal(err)
+		}
+		if err = k.AppendFrom(strings.NewReader(records[2]), -1); err != nil {
+			t.Fatal(err)
+		}
+
+		got := []string{}
+		err = k.ReadEach(func(r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			got = append(got, string(b))
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(records) {
+			t.Errorf("%s: records don't match. Expected: %v, got: %v", codec, records, got)
+		}
+
+		fi, err := os.Stat(k.contentSegmentName(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := int64(3*headerSizeV3 + len(records[0]) + len(records[1]) + len(records[2]))
+		if codec == CODEC_NONE && fi.Size() != raw {
+			t.Errorf("expected uncompressed content to be %d bytes, got: %d", raw, fi.Size())
+		}
+		if codec != CODEC_NONE && fi.Size() >= raw/2 {
+			t.Errorf("%s: expected compressed content to be less than %d bytes, got: %d", codec, raw/2, fi.Size())
+		}
+	}
+}
+
+func TestKeyReadsVersion1Blocks(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("legacy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = k.initalizeDirectory(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a block written before the header was versioned followed by a new one
+	old := []byte("written by an old version")
+	buff := &bytes.Buffer{}
+	binary.Write(buff, binary.LittleEndian, &contentHeader{magicNumber, crc64.Checksum(old, crc64.MakeTable(crc64.ISO)), uint64(len(old))})
+	buff.Write(old)
+	if err = ioutil.WriteFile(k.contentSegmentName(0), buff.Bytes(), defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	hlog := fmt.Sprintf("%X\n", sha1.Sum(old))
+	if err = ioutil.WriteFile(k.hashLogSegmentName(0), []byte(hlog), defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Append([]byte("written by this version")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	err = k.ReadEach(func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		got = append(got, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{string(old), "written by this version"}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("records don't match. Expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestKeyReadEachRecord(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("records"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []string{"first", "second", "third"}
+	before := time.Now()
+	for _, rec := range []string{"first", "second", "first", "third"} {
+		if err = k.Append([]byte(rec)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var last time.Time
+	err = k.ReadEachRecord(func(rec *Record) error {
+		data, err := ioutil.ReadAll(rec.Data)
+		if err != nil {
+			return err
+		}
+		if string(data) != records[rec.Seq] {
+			t.Errorf("record %d doesn't match. Expected: %s, got: %s", rec.Seq, records[rec.Seq], data)
+		}
+		if hash := fmt.Sprintf("%X", sha1.Sum(data)); rec.Hash != hash {
+			t.Errorf("record %d hash doesn't match. Expected: %s, got: %s", rec.Seq, hash, rec.Hash)
+		}
+		if rec.Timestamp.Before(before) || rec.Timestamp.Before(last) {
+			t.Errorf("record %d has an out of order timestamp: %s", rec.Seq, rec.Timestamp)
+		}
+		last = rec.Timestamp
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeyReadRange(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	conf := NewConfig()
+	conf.Key.SegmentSize = 3 * (headerSizeV3 + 2)
+	k, err := OpenKeyWithConfig(testDir, newSha1Key("ranges"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = k.Append([]byte(fmt.Sprintf("r%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(c Position, limit int) (string, Position) {
+		got := []string{}
+		next, err := k.ReadFrom(c, limit, func(rec *Record) error {
+			data, err := ioutil.ReadAll(rec.Data)
+			if err == nil && string(data) != fmt.Sprintf("r%d", rec.Seq) {
+				t.Errorf("record %d doesn't match its sequence number: %s", rec.Seq, data)
+			}
+			got = append(got, string(data))
+			return err
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(got, ","), next
+	}
+
+	c, err := k.PositionAt(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, c := read(c, 4)
+	if got != "r3,r4,r5,r6" {
+		t.Errorf("invalid range. Expected r3-r6, got: %s", got)
+	}
+	if got, c = read(c, 0); got != "r7,r8,r9" {
+		t.Errorf("invalid range after the position. Expected r7-r9, got: %s", got)
+	}
+	if got, _ = read(c, 0); got != "" {
+		t.Errorf("expected nothing after the end of the key, got: %s", got)
+	}
+
+	// a position at the end picks up new records
+	if err = k.Append([]byte("r10")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = read(c, 0); got != "r10" {
+		t.Errorf("expected the new record after the end position, got: %s", got)
+	}
+
+	// the index is rebuilt if it's lost
+	if err = os.Remove(k.offsets.fileName); err != nil {
+		t.Fatal(err)
+	}
+	if c, err = k.PositionAt(8); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = read(c, 2); got != "r8,r9" {
+		t.Errorf("invalid range with a rebuilt index. Expected r8,r9, got: %s", got)
+	}
+
+	c.Offset++
+	if _, err = k.ReadFrom(c, 1, func(*Record) error { return nil }, nil); err != ErrInvalidPosition {
+		t.Errorf("expected ErrInvalidPosition, got: %v", err)
+	}
+
+	parsed, err := ParsePosition(c.String())
+	if err != nil || parsed != c {
+		t.Errorf("position didn't survive being encoded: %v, %v", parsed, err)
+	}
+	if _, err = ParsePosition("not a position"); err != ErrInvalidPosition {
+		t.Errorf("expected ErrInvalidPosition parsing a bad position, got: %v", err)
+	}
+}
+
+func TestKeyReadLast(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	conf := NewConfig()
+	conf.Key.SegmentSize = 3 * (headerSizeV3 + 2)
+	k, err := OpenKeyWithConfig(testDir, newSha1Key("last"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readLast := func(n int) string {
+		got := []string{}
+		err := k.ReadLast(n, func(rec *Record) error {
+			data, err := ioutil.ReadAll(rec.Data)
+			if err == nil && rec.Hash != fmt.Sprintf("%X", sha1.Sum(data)) {
+				t.Errorf("record %d has the wrong hash: %s", rec.Seq, rec.Hash)
+			}
+			got = append(got, string(data))
+			return err
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(got, ",")
+	}
+
+	if got := readLast(3); got != "" {
+		t.Errorf("expected nothing from an empty key, got: %s", got)
+	}
+	for i := 0; i < 8; i++ {
+		if err = k.Append([]byte(fmt.Sprintf("r%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := map[int]string{
+		1:  "r7",
+		4:  "r7,r6,r5,r4",
+		0:  "r7,r6,r5,r4,r3,r2,r1,r0",
+		20: "r7,r6,r5,r4,r3,r2,r1,r0",
+	}
+	for n, expected := range cases {
+		if got := readLast(n); got != expected {
+			t.Errorf("invalid records for the last %d. Expected: %s, got: %s", n, expected, got)
+		}
+	}
+}
+
+func TestKeyStats(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	k, err := OpenKey(testDir, newSha1Key("stats"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := k.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 0 || st.Bytes != 0 || !st.LastAppend.IsZero() {
+		t.Errorf("expected empty stats, got: %+v", st)
+	}
+
+	before := time.Now()
+	if err = k.Append([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.AppendFrom(strings.NewReader("second!"), -1); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Append([]byte("first")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(st *KeyStats) {
+		if st.Count != 2 || st.Bytes != 12 || st.Version != blockVersion ||
+			st.LastHash != fmt.Sprintf("%X", sha1.Sum([]byte("second!"))) {
+			t.Errorf("unexpected stats: %+v", st)
+		}
+		if st.FirstAppend.Before(before) || st.LastAppend.Before(st.FirstAppend) {
+			t.Errorf("unexpected append times: %+v", st)
+		}
+	}
+	if st, err = k.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	check(st)
+
+	// the stats are kept so the key doesn't have to be read
+	if _, err = k.readStats(2); err != nil {
+		t.Error("expected the stats to be kept, got:", err)
+	}
+
+	// missing stats are worked out from the records
+	if err = os.Remove(k.metaFileName); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = k.Stats(); err != nil {
+		t.Fatal(err)
+	}
+	check(st)
+
+	// and torn ones are rebuilt by the next append
+	if err = ioutil.WriteFile(k.metaFileName, []byte("torn"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.Append([]byte("third")); err != nil {
+		t.Fatal(err)
+	}
+	if st, err = k.readStats(3); err != nil {
+		t.Fatal("expected the stats to be rebuilt by the append, got:", err)
+	}
+	if st.Count != 3 || st.Bytes != 17 || st.LastHash != fmt.Sprintf("%X", sha1.Sum([]byte("third"))) {
+		t.Errorf("unexpected stats after the rebuild: %+v", st)
+	}
+}
+
+func TestKeyTrim(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	conf := NewConfig()
+	conf.Key.SegmentSize = 100
+	k, err := OpenKeyWithConfig(testDir, newSha1Key("trim"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err = k.Append([]byte(fmt.Sprintf("record-%d-%s", i, strings.Repeat("x", 30)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	read := func(k *Key) string {
+		seqs := []string{}
+		_, err := k.ReadFrom(Position{}, 0, func(rec *Record) error {
+			data, err := ioutil.ReadAll(rec.Data)
+			if err != nil {
+				return err
+			}
+			if !strings.HasPrefix(string(data), fmt.Sprintf("record-%d-", rec.Seq)) {
+				t.Errorf("record %d has the wrong data: %s", rec.Seq, data)
+			}
+			seqs = append(seqs, fmt.Sprint(rec.Seq))
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(seqs, ",")
+	}
+
+	if err = k.trim(4); err != nil {
+		t.Fatal("trim returned an error:", err)
+	}
+	if got := read(k); got != "4,5,6,7,8,9" {
+		t.Errorf("expected records 4-9 after the trim, got: %s", got)
+	}
+	st, err := k.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 6 || st.Trimmed != 4 || st.Bytes != 6*39 {
+		t.Errorf("unexpected stats after the trim: %+v", st)
+	}
+
+	pos, err := k.PositionAt(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Seq != 7 {
+		t.Errorf("expected the position of record 7, got: %+v", pos)
+	}
+	pos, err = k.PositionAt(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pos.Seq != 4 {
+		t.Errorf("expected a trimmed record to be positioned at the first record, got: %+v", pos)
+	}
+	last := []uint64{}
+	err = k.ReadLast(2, func(rec *Record) error {
+		last = append(last, rec.Seq)
+		return nil
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(last) != "[9 8]" {
+		t.Errorf("expected the last 2 records, got: %v", last)
+	}
+
+	// Appends carry on from the last sequence number
+	if err = k.Append([]byte("record-10-" + strings.Repeat("x", 30))); err != nil {
+		t.Fatal(err)
+	}
+	if err = k.trim(3); err != nil {
+		t.Fatal(err)
+	}
+
+	// A trim interrupted between the renames is finished when the key is opened
+	if err = os.Rename(k.keyDir, k.keyDir+".trim"); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(k.keyDir+".old", defaultDirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	k, err = OpenKeyWithConfig(testDir, newSha1Key("trim"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := read(k); got != "7,8,9,10" {
+		t.Errorf("expected records 7-10 after the recovery, got: %s", got)
+	}
+	if _, err = os.Stat(k.keyDir + ".old"); !os.IsNotExist(err) {
+		t.Error("expected the old directory to be removed")
 	}
 }
@@ -1,6 +1,7 @@
 package astore
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
@@ -9,18 +10,29 @@ import (
 	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 )
 
-var errMissingTxLog = errors.New("Tx log file is missing")
+var (
+	errMissingTxLog    = errors.New("Tx log file is missing")
+	errEmptyTxLogValue = errors.New("Invalid value. Empty playloads are not allowed.")
+	errTxLogIDTooLarge = fmt.Errorf("idempotency ID is longer than %d bytes", maxTxLogIDSize)
+)
 
 type keyTxLog struct {
 	txLogRootPath string
 	writeLogDir   string
 	writeLogName  string
 	readLogDir    string
+	quarantineDir string // logs that couldn't be applied; see quarantine
+	syncEnabled   bool   // calls os.File.Sync after writes if enabled
 }
 
+// txLogBlockHeader is the original tx log block header. Logs written before the header was
+// versioned are still read.
 type txLogBlockHeader struct {
 	Magic uint32
 	CRC64 uint64
@@ -28,7 +40,38 @@ type txLogBlockHeader struct {
 	Len   uint64
 }
 
+const txLogBlockVersion uint8 = 3
+
+// txLogBlockHeaderV2 is written with magicNumberVersioned.
+type txLogBlockHeaderV2 struct {
+	Magic    uint32
+	Version  uint8
+	Reserved [3]byte
+	CRC64    uint64
+	Key      [sha1.Size]byte
+	Len      uint64
+}
+
+// txLogBlockHeaderV3 adds the dedupe options of the append to txLogBlockHeaderV2. The header is
+// followed by IDLen bytes of idempotency ID and then the payload. CRC64 covers both.
+type txLogBlockHeaderV3 struct {
+	Magic   uint32
+	Version uint8
+	Dedupe  uint8
+	IDLen   uint16
+	CRC64   uint64
+	Key     [sha1.Size]byte
+	Len     uint64
+	Window  uint64
+}
+
 func newKeyTxLog(rootPath string) (appendableKey, error) {
+	return openKeyTxLog(rootPath)
+}
+
+// openKeyTxLog creates the tx log layout under rootPath if needed and returns the log. Like
+// OpenKey, setting DISABLE_ASTORE_FSYNC turns off the os.File.Sync calls.
+func openKeyTxLog(rootPath string) (*keyTxLog, error) {
 
 	txlogroot := rootPath + "/txlog"
 	kt := &keyTxLog{
@@ -36,6 +79,8 @@ func newKeyTxLog(rootPath string) (appendableKey, error) {
 		writeLogDir:   txlogroot + "/writing",
 		writeLogName:  txlogroot + "/writing/tx.log",
 		readLogDir:    txlogroot + "/reading",
+		quarantineDir: txlogroot + "/quarantine",
+		syncEnabled:   len(os.Getenv("DISABLE_ASTORE_FSYNC")) == 0,
 	}
 
 	if err := kt.initialize(); err != nil {
@@ -48,35 +93,78 @@ func newKeyTxLog(rootPath string) (appendableKey, error) {
 	return kt, nil
 }
 
-func (kt *keyTxLog) Append(key hashableKey, value []byte) error {
+func (kt *keyTxLog) Append(key hashableKey, value []byte, opts *AppendOptions) (WriteStatus, error) {
+
+	if err := checkTxLogBlock(value, opts); err != nil {
+		return WRITE_PENDING, err
+	}
 
+	file, err := kt.openWriteLog()
+	if err != nil {
+		return WRITE_PENDING, err
+	}
+	err = writeTxLogBlock(file, key, value, opts)
+	if err != nil {
+		file.Close()
+		return WRITE_PENDING, err
+	}
+	return WRITE_PENDING, file.Close()
+}
+
+// openWriteLog opens the active write log for appending, creating it if it was rotated away.
+func (kt *keyTxLog) openWriteLog() (*os.File, error) {
+	return os.OpenFile(kt.writeLogName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, defaultFilePermisions)
+}
+
+// checkTxLogBlock returns the error writeTxLogBlock would return for value and opts before
+// anything is written.
+func checkTxLogBlock(value []byte, opts *AppendOptions) error {
 	if len(value) == 0 {
-		return fmt.Errorf("Invalid value. Empty playloads are not allowed.")
+		return errEmptyTxLogValue
+	}
+	if opts != nil && len(opts.ID) > maxTxLogIDSize {
+		return errTxLogIDTooLarge
 	}
+	return nil
+}
+
+// writeTxLogBlock encodes a single header + ID + payload block to w. A nil opts is written as
+// content dedupe.
+func writeTxLogBlock(w io.Writer, key hashableKey, value []byte, opts *AppendOptions) error {
 
-	header := &txLogBlockHeader{
-		Magic: magicNumber,
-		CRC64: crc64.Checksum(value, crc64.MakeTable(crc64.ISO)),
-		Len:   uint64(len(value)),
+	if opts == nil {
+		opts = &AppendOptions{}
+	}
+	if len(opts.ID) > maxTxLogIDSize {
+		return errTxLogIDTooLarge
+	}
+	table := crc64.MakeTable(crc64.ISO)
+	header := &txLogBlockHeaderV3{
+		Magic:   magicNumberVersioned,
+		Version: txLogBlockVersion,
+		Dedupe:  uint8(opts.Dedupe),
+		IDLen:   uint16(len(opts.ID)),
+		CRC64:   crc64.Update(crc64.Checksum([]byte(opts.ID), table), table, value),
+		Len:     uint64(len(value)),
+		Window:  opts.Window,
 	}
 	copy(header.Key[:], key.Get())
 
-	file, err := os.OpenFile(kt.writeLogName, os.O_APPEND|os.O_WRONLY|os.O_CREATE, defaultFilePermisions)
+	err := binary.Write(w, binary.LittleEndian, header)
 	if err != nil {
 		return err
 	}
-	err = binary.Write(file, binary.LittleEndian, header)
-	if err != nil {
+	if _, err = io.WriteString(w, opts.ID); err != nil {
 		return err
 	}
-	n, err := file.Write(value)
+	n, err := w.Write(value)
 	if err != nil {
 		return err
 	}
 	if n < len(value) {
 		return fmt.Errorf("short write; expected: %d, wrote: %d", len(value), n)
 	}
-	return file.Close()
+	return nil
 }
 
 func (kt *keyTxLog) validateLayout() error {
@@ -113,33 +201,189 @@ func (kt *keyTxLog) initialize() error {
 	return nil
 }
 
-type txLogReaderFn func(hashableKey, io.Reader) error
+// txLogReaderFn is called with each block in a tx log. opts are the options the block was
+// appended with; nil for blocks written before they were logged.
+type txLogReaderFn func(key hashableKey, r io.Reader, opts *AppendOptions) error
+
+// maxTxLogIDSize is the longest idempotency ID that fits in a tx log block.
+const maxTxLogIDSize = 1<<16 - 1
+
+var (
+	txLogBlockHeaderSizeV1 = int64(binary.Size(txLogBlockHeader{}))
+	txLogBlockHeaderSizeV2 = int64(binary.Size(txLogBlockHeaderV2{}))
+	txLogBlockHeaderSize   = int64(binary.Size(txLogBlockHeaderV3{}))
+)
+
+// txLogHeader is a tx log block header of any supported version.
+type txLogHeader struct {
+	txLogBlockHeaderV2
+	opts *AppendOptions // nil before version 3
+	size int64          // size of the header on disk, including the ID
+}
+
+// readTxLogHeader reads the next tx log block header from r. Like readBlockHeader, it returns
+// io.EOF at the end of r, io.ErrUnexpectedEOF for a short header and the header it read with
+// errBadMagic or errBadVersion.
+func readTxLogHeader(r io.Reader) (*txLogHeader, error) {
 
+	h := &txLogHeader{}
+	if err := binary.Read(r, binary.LittleEndian, &h.Magic); err != nil {
+		return h, err
+	}
+
+	var err error
+	switch h.Magic {
+	case magicNumber:
+		v1 := struct {
+			CRC64 uint64
+			Key   [sha1.Size]byte
+			Len   uint64
+		}{}
+		err = binary.Read(r, binary.LittleEndian, &v1)
+		h.Version = 1
+		h.CRC64 = v1.CRC64
+		h.Key = v1.Key
+		h.Len = v1.Len
+		h.size = txLogBlockHeaderSizeV1
+	case magicNumberVersioned:
+		err = binary.Read(r, binary.LittleEndian, &h.Version)
+		if err == nil && (h.Version < 2 || h.Version > txLogBlockVersion) {
+			return h, errBadVersion
+		}
+		v3 := struct {
+			Dedupe uint8
+			IDLen  uint16
+			CRC64  uint64
+			Key    [sha1.Size]byte
+			Len    uint64
+		}{}
+		if err == nil {
+			err = binary.Read(r, binary.LittleEndian, &v3)
+		}
+		h.CRC64 = v3.CRC64
+		h.Key = v3.Key
+		h.Len = v3.Len
+		h.size = txLogBlockHeaderSizeV2
+		if err == nil && h.Version >= 3 {
+			h.opts = &AppendOptions{Dedupe: DedupeMode(v3.Dedupe)}
+			err = binary.Read(r, binary.LittleEndian, &h.opts.Window)
+			id := make([]byte, v3.IDLen)
+			if err == nil {
+				_, err = io.ReadFull(r, id)
+			}
+			h.opts.ID = string(id)
+			h.size = txLogBlockHeaderSize + int64(v3.IDLen)
+		}
+	default:
+		return h, errBadMagic
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return h, err
+}
+
+// readLog calls callback for each block in logfile. Payloads are checked against their CRC64
+// before they are passed to the callback; a block that fails the check (or has a bad magic
+// number) stops the read with a *CorruptBlockError.
+//
+// A crash while writing can leave a torn block at the end of the log: a short header or a payload
+// shorter than the header's Len. The torn block can never be applied so the log is truncated
+// at the start of the block and the blocks before it are read as usual.
 func (kt *keyTxLog) readLog(logfile string, callback txLogReaderFn) error {
 
 	file, err := os.Open(logfile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	for err == nil {
-		header := &txLogBlockHeader{}
-		err = binary.Read(file, binary.LittleEndian, header)
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	var offset int64
+	var payload []byte
+	table := crc64.MakeTable(crc64.ISO)
+	for index := 0; ; index++ {
+		header, err := readTxLogHeader(file)
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF:
+			return kt.truncateTornBlock(logfile, offset)
+		case err == errBadMagic:
+			return &CorruptBlockError{"", logfile, offset, index,
+				fmt.Sprintf("magic %X doesn't match magic number: %X", header.Magic, magicNumberVersioned)}
+		case err == errBadVersion:
+			return &CorruptBlockError{"", logfile, offset, index,
+				fmt.Sprintf("unsupported block version: %d", header.Version)}
+		case err != nil:
 			return fmt.Errorf("error reading header block: %s", err)
 		}
+		key := newSha1KeyFromHash(header.Key[:])
+		if header.Len > uint64(fi.Size()-offset-header.size) {
+			return kt.truncateTornBlock(logfile, offset)
+		}
+
+		if uint64(cap(payload)) < header.Len {
+			payload = make([]byte, header.Len)
+		}
+		payload = payload[:header.Len]
+		if _, err = io.ReadFull(file, payload); err != nil {
+			return err
+		}
+		if header.checksum(payload, table) != header.CRC64 {
+			return &CorruptBlockError{key.String(), logfile, offset, index, "CRC64 mismatch"}
+		}
 
-		// TODO: what happens if the reader doesn't consume all the bytes?
-		//       Look at io.LimitedReader.N and comsume any remaining bytes.
-		err = callback(newSha1KeyFromHash(header.Key[:]), io.LimitReader(file, int64(header.Len)))
+		opts := header.opts
+		if opts != nil && opts.Dedupe != DEDUPE_CONTENT {
+			// the position lets the key skip the block if the log is applied again
+			opts.txPos = fmt.Sprintf("%s:%016X", filepath.Base(logfile), offset)
+		}
+		if err = callback(key, bytes.NewReader(payload), opts); err != nil {
+			return err
+		}
+		offset += header.size + int64(header.Len)
 	}
+}
 
-	// EOF is expected and is not an error to be returned
-	if err == io.EOF {
-		return nil
+// checksum returns the CRC64 of the block with payload, which covers the ID from version 3.
+func (h *txLogHeader) checksum(payload []byte, table *crc64.Table) uint64 {
+	var crc uint64
+	if h.opts != nil {
+		crc = crc64.Checksum([]byte(h.opts.ID), table)
+	}
+	return crc64.Update(crc, table, payload)
+}
+
+func (kt *keyTxLog) truncateTornBlock(logfile string, offset int64) error {
+	log.Printf("WARNING: truncating torn block in tx log: %s at offset: %d", logfile, offset)
+	return os.Truncate(logfile, offset)
+}
+
+// readyLogs returns the rotated logs waiting to be applied, oldest first.
+func (kt *keyTxLog) readyLogs() ([]string, error) {
+	logs, err := filepath.Glob(kt.readLogDir + "/tx-*.log")
+	if err != nil {
+		return nil, err
 	}
-	log.Println("ERR:", err)
-	return err
+	// the fixed width timestamps in the names sort in the order the logs were rotated
+	sort.Strings(logs)
+	return logs, nil
+}
+
+// quarantine moves a rotated log that can't be applied out of the reading directory so the logs
+// after it aren't held up by it. It returns the new name of the log. Moving it back to the reading
+// directory applies it again the next time the store is opened.
+func (kt *keyTxLog) quarantine(logName string) (string, error) {
+	if err := os.MkdirAll(kt.quarantineDir, defaultDirPermissions); err != nil {
+		return "", err
+	}
+	name := kt.quarantineDir + "/" + filepath.Base(logName)
+	return name, os.Rename(logName, name)
 }
 
 // rotate renames the active write log to the reading directory, setting the unique timestamp. This
@@ -147,8 +391,7 @@ func (kt *keyTxLog) readLog(logfile string, callback txLogReaderFn) error {
 // own locking. If the tx log hasn't been created yet, return errMissingTxLog which, similar to
 // EOF shouldn't be considered execeptional. IOW callers are expected to handle this case gracefully.
 func (kt *keyTxLog) rotate() (string, error) {
-	format := "20060102T150405.999999999Z" // compressed ISO3339
-	newName := fmt.Sprintf("%s/tx-%s.log", kt.readLogDir, time.Now().UTC().Format(format))
+	newName := fmt.Sprintf("%s/tx-%s.log", kt.readLogDir, time.Now().UTC().Format(txLogTimeFormat))
 
 	// TODO: Is Go's Rename implemented using atomic renames on all platforms? Windows?
 	//       Looks like Windows is getting this in go 1.5:
@@ -160,6 +403,15 @@ func (kt *keyTxLog) rotate() (string, error) {
 	return newName, err
 }
 
+// compressed ISO3339, fixed width so names sort by time
+const txLogTimeFormat = "20060102T150405.000000000Z"
+
+// txLogRotatedAt returns when the log named logName was rotated.
+func txLogRotatedAt(logName string) (time.Time, error) {
+	stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(logName), "tx-"), ".log")
+	return time.Parse(txLogTimeFormat, stamp)
+}
+
 func helpWritablePathExists(path string) bool {
 	i, err := os.Stat(path)
 	if err != nil {
@@ -0,0 +1,326 @@
+package astore
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const txLogDispatchBuffer = 16
+
+// txLogBlock is a single tx log entry on its way to a key committer. Every block read from the
+// same log shares the pending WaitGroup and failure count so the dispatcher knows when the log
+// has been fully applied and how many of its blocks were new.
+type txLogBlock struct {
+	key      hashableKey
+	value    []byte
+	opts     *AppendOptions
+	pending  *sync.WaitGroup
+	failed   *int32
+	appended *int32 // blocks appended to their keys; duplicates aren't counted
+}
+
+// txLogDispatcher reads rotated tx logs in the order they are handed to it and sends each block
+// to a committer. A key is always sent to the same committer so that the writes to a key are
+// applied in the order they were logged. A log is removed once all of its blocks have been
+// committed. If any block fails, the log is moved to the quarantine directory so the logs after
+// it can be applied; see keyTxLog.quarantine. The later blocks for a key that failed are held back
+// and quarantined with their logs so that the key's blocks can still be applied in order.
+// Duplicates are skipped by the keys when a log is applied again.
+type txLogDispatcher struct {
+	txlog      *keyTxLog
+	keyPath    string
+	conf       *Config
+	chLogs     chan string
+	committers []chan *txLogBlock
+	onCommit   func(*appendedRecord) // called with each record appended to a key, if set
+	held       map[string]bool       // keys held back for blocks in quarantined logs; see holdQuarantined
+	wg         *sync.WaitGroup
+	applied    int64 // unix nanos; every block logged before this has been applied
+	queued     int32 // number of logs dispatched that haven't been applied yet
+	stalled    int32 // set once a log that failed to apply can't be quarantined
+}
+
+func newTxLogDispatcher(txlog *keyTxLog, keyPath string, conf *Config) (*txLogDispatcher, error) {
+
+	nCommitters := conf.TxLog.Committers
+	if nCommitters < 1 {
+		nCommitters = 1
+	}
+
+	d := &txLogDispatcher{
+		txlog:      txlog,
+		keyPath:    keyPath,
+		conf:       conf,
+		chLogs:     make(chan string, txLogDispatchBuffer),
+		committers: make([]chan *txLogBlock, nCommitters),
+		wg:         &sync.WaitGroup{},
+		applied:    time.Now().UnixNano(),
+	}
+	for i := range d.committers {
+		d.committers[i] = make(chan *txLogBlock, txLogDispatchBuffer)
+	}
+	if err := d.holdQuarantined(); err != nil {
+		return nil, fmt.Errorf("error reading the quarantined tx logs: %s", err)
+	}
+	return d, nil
+}
+
+// holdQuarantined finds the keys that have blocks in the quarantined logs which a replay would
+// skip if later blocks were committed to them. Those are the blocks that weren't committed to keys
+// that keep the position of the last block committed to them (see Key.txApplied). Keys that dedupe
+// by content find their blocks whenever the log is applied. The blocks after a corrupt one can't
+// be read and aren't considered.
+func (d *txLogDispatcher) holdQuarantined() error {
+
+	logs, err := filepath.Glob(d.txlog.quarantineDir + "/tx-*.log")
+	if err != nil {
+		return err
+	}
+	d.held = map[string]bool{}
+	for _, logName := range logs {
+		err = d.txlog.readLog(logName, func(key hashableKey, r io.Reader, opts *AppendOptions) error {
+			if opts == nil || opts.txPos == "" || d.held[key.String()] {
+				return nil
+			}
+			k, err := OpenKeyWithConfig(d.keyPath, key, d.conf)
+			if err != nil {
+				return err
+			}
+			applied, err := k.txApplied(opts.txPos)
+			if err != nil {
+				return err
+			}
+			d.held[key.String()] = !applied
+			return nil
+		})
+		if _, ok := err.(*CorruptBlockError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// run starts the dispatcher and committer goroutines.
+func (d *txLogDispatcher) run() {
+
+	for _, ch := range d.committers {
+		d.wg.Add(1)
+		go d.commit(ch)
+	}
+
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		for logName := range d.chLogs {
+			if _, err := d.applyOrQuarantine(logName); err != nil {
+				// the log is only applied again when the store is reopened
+				log.Println("ERROR:", err)
+				atomic.StoreInt32(&d.stalled, 1)
+			}
+			if rotated, err := txLogRotatedAt(logName); err == nil && atomic.LoadInt32(&d.stalled) == 0 {
+				atomic.StoreInt64(&d.applied, rotated.UnixNano())
+			}
+			atomic.AddInt32(&d.queued, -1)
+		}
+		for _, ch := range d.committers {
+			close(ch)
+		}
+	}()
+}
+
+// appliedThrough returns the time before which every block logged has been applied to its key.
+// The logs left behind by a previous run were recovered before the dispatcher was created. It stops
+// advancing if a log that failed to apply can't be quarantined.
+func (d *txLogDispatcher) appliedThrough() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&d.applied))
+}
+
+// idle is called when nothing was written to the tx log before now. Once the logs already
+// dispatched have been applied, every block logged before now has been.
+func (d *txLogDispatcher) idle(now time.Time) {
+	if atomic.LoadInt32(&d.queued) == 0 && atomic.LoadInt32(&d.stalled) == 0 {
+		atomic.StoreInt64(&d.applied, now.UnixNano())
+	}
+}
+
+// dispatch queues a rotated log to be applied.
+func (d *txLogDispatcher) dispatch(logName string) {
+	atomic.AddInt32(&d.queued, 1)
+	d.chLogs <- logName
+}
+
+// close signals that no more logs will be dispatched. The queued logs are still applied.
+func (d *txLogDispatcher) close() {
+	close(d.chLogs)
+}
+
+// wait blocks until the dispatcher and the committers have exited.
+func (d *txLogDispatcher) wait() {
+	d.wg.Wait()
+}
+
+// applyOrQuarantine applies logName and quarantines it if it can't be. An error is only returned
+// if the log couldn't be quarantined.
+func (d *txLogDispatcher) applyOrQuarantine(logName string) (int, error) {
+
+	n, err := d.apply(logName)
+	if err == nil {
+		return n, nil
+	}
+	log.Println("ERROR:", err)
+	name, err := d.txlog.quarantine(logName)
+	if err != nil {
+		return n, fmt.Errorf("error quarantining tx log: %s: %s", logName, err)
+	}
+	log.Printf("ERROR: quarantined tx log: %s. Move it back to %s to apply it again", name, d.txlog.readLogDir)
+	return n, nil
+}
+
+// apply sends every block in logName to the committers and removes the log once they have all
+// been committed. It returns the number of blocks that were appended to their keys.
+func (d *txLogDispatcher) apply(logName string) (int, error) {
+
+	pending := &sync.WaitGroup{}
+	var failed, appended int32
+
+	err := d.txlog.readLog(logName, func(key hashableKey, r io.Reader, opts *AppendOptions) error {
+		value, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		pending.Add(1)
+		d.committerFor(key) <- &txLogBlock{
+			key:      key,
+			value:    value,
+			opts:     opts,
+			pending:  pending,
+			failed:   &failed,
+			appended: &appended,
+		}
+		return nil
+	})
+	pending.Wait()
+	blocks := int(atomic.LoadInt32(&appended))
+
+	if err != nil {
+		return blocks, fmt.Errorf("error reading tx log: %s: %s", logName, err)
+	}
+	if n := atomic.LoadInt32(&failed); n > 0 {
+		return blocks, fmt.Errorf("%d blocks failed to commit, keeping tx log: %s", n, logName)
+	}
+	if err := os.Remove(logName); err != nil {
+		return blocks, fmt.Errorf("error removing tx log: %s: %s", logName, err)
+	}
+	return blocks, nil
+}
+
+func (d *txLogDispatcher) committerFor(key hashableKey) chan *txLogBlock {
+	return d.committers[binary.BigEndian.Uint32(key.Get())%uint32(len(d.committers))]
+}
+
+// commit applies the blocks it receives to their keys. Once a block fails to commit, the later
+// blocks for its key are held back and fail too, even if they are in a later log. The key only
+// keeps the position of the last block committed to it so committing them would skip the failed
+// block when its log is applied again. The keys with blocks in logs that were quarantined before
+// the dispatcher was created are held back the same way.
+func (d *txLogDispatcher) commit(ch chan *txLogBlock) {
+	defer d.wg.Done()
+
+	// a key is always sent to the same committer so each one tracks the keys it failed on
+	failedKeys := map[string]bool{}
+	for b := range ch {
+		if failedKeys[b.key.String()] || d.held[b.key.String()] {
+			atomic.AddInt32(b.failed, 1)
+			b.pending.Done()
+			continue
+		}
+
+		var appended *appendedRecord
+		k, err := OpenKeyWithConfig(d.keyPath, b.key, d.conf)
+		if err == nil {
+			if err = k.AppendWithOptions(b.value, b.opts); err == nil {
+				appended = k.appended
+			}
+		}
+		switch err {
+		case ErrKeyDeleted:
+			// the key was deleted after the block was logged
+			err = nil
+		case ErrKeySealed:
+			// the key was sealed after the block was logged; retrying won't help
+			log.Printf("WARNING: dropping append to sealed key: %s", b.key)
+			err = nil
+		}
+		if err != nil {
+			log.Printf("ERROR: committing to key: %s: %s", b.key, err)
+			atomic.AddInt32(b.failed, 1)
+			failedKeys[b.key.String()] = true
+		} else if appended != nil {
+			atomic.AddInt32(b.appended, 1)
+			if d.onCommit != nil {
+				d.onCommit(appended)
+			}
+		}
+		b.pending.Done()
+	}
+}
+
+// recoverTxLog applies the tx logs left behind by a previous run. A leftover writing/tx.log is
+// rotated first and then every log in the reading directory is applied, oldest first. Logs that
+// were partly committed before a crash are simply applied again; the keys skip the blocks
+// they already have. Logs that can't be applied are quarantined. It returns the number of blocks
+// that were replayed.
+//
+// This must run before a tx log writer is started on rootPath. onCommit, if not nil, is called with
+// each record that's appended to a key.
+func recoverTxLog(rootPath, keyPath string, conf *Config, onCommit func(*appendedRecord)) (int, error) {
+
+	txlog, err := openKeyTxLog(rootPath)
+	if err != nil {
+		return 0, err
+	}
+
+	fi, err := os.Stat(txlog.writeLogName)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	if err == nil && fi.Size() > 0 {
+		if _, err := txlog.rotate(); err != nil {
+			return 0, fmt.Errorf("error rotating the pending tx log: %s", err)
+		}
+	}
+
+	logs, err := txlog.readyLogs()
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := newTxLogDispatcher(txlog, keyPath, conf)
+	if err != nil {
+		return 0, err
+	}
+	d.onCommit = onCommit
+	d.run()
+	defer func() {
+		d.close()
+		d.wait()
+	}()
+
+	recovered := 0
+	for _, logName := range logs {
+		n, err := d.applyOrQuarantine(logName)
+		recovered += n
+		if err != nil {
+			return recovered, err
+		}
+	}
+	return recovered, nil
+}
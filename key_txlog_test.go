@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"os"
 	"path"
 	"sort"
 	"strings"
@@ -54,7 +55,7 @@ func TestKeyTxLogAppendRead(t *testing.T) {
 	for i, f := range fixtures {
 		tvalue := f.val
 		tkey := f.key
-		err = k.Append(newSha1Key(tkey), tvalue)
+		_, err = k.Append(newSha1Key(tkey), tvalue, nil)
 
 		if f.hasAppendErr && err != nil {
 			continue
@@ -77,7 +78,7 @@ func TestKeyTxLogAppendRead(t *testing.T) {
 	}
 
 	readCount := 0
-	err = klog.readLog(klog.writeLogName, func(key hashableKey, r io.Reader) error {
+	err = klog.readLog(klog.writeLogName, func(key hashableKey, r io.Reader, opts *AppendOptions) error {
 
 		// make sure to always consume all the bits from the reader
 		v, err := ioutil.ReadAll(r)
@@ -224,7 +225,7 @@ func helpAppendTxLog(kt *keyTxLog, fixtures map[string]string) ([]string, error)
 
 	keys := []string{}
 	for k, v := range fixtures {
-		err := kt.Append(newSha1Key(k), []byte(v))
+		_, err := kt.Append(newSha1Key(k), []byte(v), nil)
 		if err != nil {
 			return keys, err
 		}
@@ -236,7 +237,7 @@ func helpAppendTxLog(kt *keyTxLog, fixtures map[string]string) ([]string, error)
 func helpValidateWrittenKeys(t *testing.T, logFile string, kt *keyTxLog, writtenKeys []string) {
 
 	readCount := 0
-	err := kt.readLog(logFile, func(key hashableKey, r io.Reader) error {
+	err := kt.readLog(logFile, func(key hashableKey, r io.Reader, opts *AppendOptions) error {
 		_, err := ioutil.ReadAll(r)
 		if err != nil && err != io.EOF {
 			return err
@@ -259,3 +260,75 @@ func helpValidateWrittenKeys(t *testing.T, logFile string, kt *keyTxLog, written
 	}
 
 }
+
+func TestKeyTxLogReadTruncatesTornBlock(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	klog, err := helpMkTxLog(t, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writtenKeys, err := helpAppendTxLog(klog, map[string]string{"one": "p1", "two": "p2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(klog.writeLogName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	goodSize := fi.Size()
+
+	type torn struct {
+		name string
+		cut  int64 // bytes cut from the end of a complete third block
+	}
+	for _, fix := range []torn{
+		torn{"short payload", 3},
+		torn{"short header", int64(len("the torn value")) + 10},
+	} {
+		if _, err := klog.Append(newSha1Key("three"), []byte("the torn value"), nil); err != nil {
+			t.Fatal(err)
+		}
+		fi, _ = os.Stat(klog.writeLogName)
+		if err := os.Truncate(klog.writeLogName, fi.Size()-fix.cut); err != nil {
+			t.Fatal(err)
+		}
+
+		helpValidateWrittenKeys(t, klog.writeLogName, klog, writtenKeys)
+
+		fi, _ = os.Stat(klog.writeLogName)
+		if fi.Size() != goodSize {
+			t.Errorf("%s: expected the log to be truncated to %d bytes, got: %d", fix.name, goodSize, fi.Size())
+		}
+	}
+}
+
+func TestKeyTxLogReadDetectsCorruption(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	klog, err := helpMkTxLog(t, testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = klog.Append(newSha1Key("one"), []byte("the value"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	file, err := os.OpenFile(klog.writeLogName, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteAt([]byte("X"), txLogBlockHeaderSize)
+	file.Close()
+
+	err = klog.readLog(klog.writeLogName, func(key hashableKey, r io.Reader, opts *AppendOptions) error {
+		t.Error("the corrupt block must not be passed to the callback")
+		return nil
+	})
+	if cerr, ok := err.(*CorruptBlockError); !ok || cerr.Index != 0 {
+		t.Errorf("expected a *CorruptBlockError for block 0, got: %v", err)
+	}
+}
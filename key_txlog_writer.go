@@ -0,0 +1,257 @@
+package astore
+
+import (
+	"bufio"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+var errTxLogClosed = errors.New("Tx log writer is closed")
+
+// txLogWrite is a single append waiting to be written to the tx log. The writer reports the
+// result of the write on chErr.
+type txLogWrite struct {
+	key   hashableKey
+	value []byte
+	opts  *AppendOptions
+	chErr chan error
+}
+
+// Implements the appendableKey interface for writes that go through the transaction log. See
+// WRITELOG.md for the design.
+//
+// A single goroutine owns writing/tx.log. It drains the buffered write channel in batches,
+// writes and syncs each batch once and then unblocks the callers. The same goroutine rotates
+// the log on a timer so no locking is needed around the open file. Rotated logs are handed to
+// the dispatcher which applies them to the keys with the committers.
+type keyTxLogWriter struct {
+	txlog          *keyTxLog
+	rotateInterval time.Duration
+	chWrite        chan *txLogWrite
+	dispatcher     *txLogDispatcher
+	file           *os.File
+	buff           *bufio.Writer
+	dirty          bool // something has been written since the last rotate
+	torn           bool // a failed batch couldn't be truncated away; the log is rotated
+	mu             sync.RWMutex
+	closed         bool
+	wg             *sync.WaitGroup
+}
+
+// newKeyTxLogWriter starts a tx log writer. onCommit, if not nil, is called with each record once
+// it has been committed to its key.
+func newKeyTxLogWriter(rootPath, keyPath string, conf *Config, onCommit func(*appendedRecord)) (appendableKey, error) {
+
+	txlog, err := openKeyTxLog(rootPath)
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher, err := newTxLogDispatcher(txlog, keyPath, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	kw := &keyTxLogWriter{
+		txlog:          txlog,
+		rotateInterval: conf.TxLog.RotateInterval,
+		chWrite:        make(chan *txLogWrite, conf.TxLog.WriteBuffer),
+		dispatcher:     dispatcher,
+		wg:             &sync.WaitGroup{},
+	}
+
+	kw.dispatcher.onCommit = onCommit
+	kw.dispatcher.run()
+	kw.wg.Add(1)
+	go kw.run()
+	return kw, nil
+}
+
+// Append queues value for the tx log and blocks until it has been written (and synced if
+// enabled) or the write failed. Whether it's a duplicate is only known once it's committed. Bad
+// input is rejected before it's queued so it can't fail the rest of the batch.
+func (kw *keyTxLogWriter) Append(key hashableKey, value []byte, opts *AppendOptions) (WriteStatus, error) {
+
+	if err := checkTxLogBlock(value, opts); err != nil {
+		return WRITE_PENDING, err
+	}
+
+	w := &txLogWrite{
+		key:   key,
+		value: value,
+		opts:  opts,
+		chErr: make(chan error, 1),
+	}
+
+	kw.mu.RLock()
+	if kw.closed {
+		kw.mu.RUnlock()
+		return WRITE_PENDING, errTxLogClosed
+	}
+	kw.chWrite <- w
+	kw.mu.RUnlock()
+
+	return WRITE_PENDING, <-w.chErr
+}
+
+// Close stops accepting writes, flushes everything that was already queued through the
+// committers and waits for them to finish.
+func (kw *keyTxLogWriter) Close() error {
+
+	kw.mu.Lock()
+	if kw.closed {
+		kw.mu.Unlock()
+		return nil
+	}
+	kw.closed = true
+	close(kw.chWrite)
+	kw.mu.Unlock()
+
+	kw.wg.Wait()
+	kw.dispatcher.wait()
+	return nil
+}
+
+// appliedThrough returns the time before which every append written to the tx log has been
+// committed to its key.
+func (kw *keyTxLogWriter) appliedThrough() time.Time {
+	return kw.dispatcher.appliedThrough()
+}
+
+func (kw *keyTxLogWriter) run() {
+	defer kw.wg.Done()
+
+	tick := time.NewTicker(kw.rotateInterval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case w, ok := <-kw.chWrite:
+			if !ok {
+				kw.rotate()
+				kw.dispatcher.close()
+				return
+			}
+			kw.writeBatch(w)
+
+		case <-tick.C:
+			kw.rotate()
+		}
+	}
+}
+
+// writeBatch writes first plus whatever else is waiting in the write channel, then syncs once
+// for the whole batch.
+func (kw *keyTxLogWriter) writeBatch(first *txLogWrite) {
+
+	batch := []*txLogWrite{first}
+	for len(batch) < cap(kw.chWrite) {
+		w, ok := kw.tryRecv()
+		if !ok {
+			break
+		}
+		batch = append(batch, w)
+	}
+
+	err := kw.write(batch)
+	if err != nil {
+		// start over with a fresh file handle and buffer on the next batch
+		kw.closeFile()
+		if kw.torn {
+			// the batch couldn't be cut off; end the log with it so that it's the log's torn tail
+			// rather than a torn block in front of the next batch
+			kw.rotate()
+		}
+	}
+	for _, w := range batch {
+		w.chErr <- err
+	}
+}
+
+func (kw *keyTxLogWriter) tryRecv() (*txLogWrite, bool) {
+	select {
+	case w, ok := <-kw.chWrite:
+		return w, ok
+	default:
+		return nil, false
+	}
+}
+
+func (kw *keyTxLogWriter) write(batch []*txLogWrite) error {
+
+	if kw.file == nil {
+		file, err := kw.txlog.openWriteLog()
+		if err != nil {
+			return err
+		}
+		kw.file = file
+		kw.buff = bufio.NewWriter(file)
+	}
+
+	fi, err := kw.file.Stat()
+	if err != nil {
+		return err
+	}
+	start := fi.Size()
+
+	kw.dirty = true
+	if err = kw.writeBlocks(batch); err != nil {
+		// The buffer may have been flushed part way through the batch. Cut the log back to where
+		// the batch started so that the callers, who are told it failed, don't have their blocks
+		// applied and a torn block isn't left in front of the next batch.
+		if terr := kw.file.Truncate(start); terr != nil {
+			log.Println("ERROR: truncating tx log after a failed write:", terr)
+			kw.torn = true
+		}
+	}
+	return err
+}
+
+func (kw *keyTxLogWriter) writeBlocks(batch []*txLogWrite) error {
+
+	for _, w := range batch {
+		if err := writeTxLogBlock(kw.buff, w.key, w.value, w.opts); err != nil {
+			return err
+		}
+	}
+	if err := kw.buff.Flush(); err != nil {
+		return err
+	}
+	if kw.txlog.syncEnabled {
+		return kw.file.Sync()
+	}
+	return nil
+}
+
+// rotate closes the active log and hands it to the dispatcher. If there haven't been any writes
+// since the last rotate the dispatcher is only told that it's idle.
+func (kw *keyTxLogWriter) rotate() {
+
+	if !kw.dirty {
+		kw.dispatcher.idle(time.Now())
+		return
+	}
+
+	kw.closeFile()
+	name, err := kw.txlog.rotate()
+	if err != nil {
+		log.Println("ERROR: rotating tx log:", err)
+		return
+	}
+	kw.dirty, kw.torn = false, false
+	kw.dispatcher.dispatch(name)
+}
+
+func (kw *keyTxLogWriter) closeFile() {
+	if kw.file == nil {
+		return
+	}
+	if err := kw.file.Close(); err != nil {
+		log.Println("ERROR: closing tx log:", err)
+	}
+	kw.file = nil
+	kw.buff = nil
+}
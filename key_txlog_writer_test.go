@@ -0,0 +1,457 @@
+package astore
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyTxLogWriterCommitsOnClose(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	conf := NewConfig()
+	conf.TxLog.RotateInterval = time.Hour // only the final rotate on Close
+	kw := helpMkTxLogWriter(t, testDir, conf)
+
+	fixtures := map[string][]string{
+		"one":   []string{"p1", "p2", "p3"},
+		"two":   []string{"bar, baz and bing"},
+		"three": []string{"whatever", "whatever again"},
+	}
+
+	wg := &sync.WaitGroup{}
+	for key, values := range fixtures {
+		wg.Add(1)
+		go func(key string, values []string) {
+			defer wg.Done()
+			for _, v := range values {
+				if _, err := kw.Append(newSha1Key(key), []byte(v), nil); err != nil {
+					t.Errorf("append failed: %s: %s", key, err)
+				}
+			}
+		}(key, values)
+	}
+	wg.Wait()
+
+	if err := kw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, values := range fixtures {
+		helpValidateKeyContent(t, testDir+"/keys", key, values)
+	}
+
+	logs, _ := filepath.Glob(kw.txlog.readLogDir + "/*.log")
+	if len(logs) != 0 {
+		t.Errorf("expected all tx logs to be removed after commit, found: %v", logs)
+	}
+}
+
+func TestKeyTxLogWriterRotatesOnTimer(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	conf := NewConfig()
+	conf.TxLog.RotateInterval = 10 * time.Millisecond
+	kw := helpMkTxLogWriter(t, testDir, conf)
+	defer kw.Close()
+
+	key := newSha1Key("the key")
+	if _, err := kw.Append(key, []byte("committed by the timer"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		k, _ := OpenKey(testDir+"/keys", key)
+		if n, _ := k.Count(); n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the committers")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestKeyTxLogWriterAppendAfterClose(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	kw := helpMkTxLogWriter(t, testDir, NewConfig())
+	kw.Close()
+
+	if _, err := kw.Append(newSha1Key("k"), []byte("v"), nil); err != errTxLogClosed {
+		t.Errorf("expected errTxLogClosed, got: %v", err)
+	}
+}
+
+func TestKeyTxLogWriterEmptyValue(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	kw := helpMkTxLogWriter(t, testDir, NewConfig())
+	defer kw.Close()
+
+	if _, err := kw.Append(newSha1Key("k"), []byte{}, nil); err == nil {
+		t.Error("expected an error writing an empty value")
+	}
+}
+
+func TestKeyTxLogWriterIDTooLarge(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	kw := helpMkTxLogWriter(t, testDir, NewConfig())
+	defer kw.Close()
+
+	opts := &AppendOptions{ID: strings.Repeat("x", maxTxLogIDSize+1)}
+	if _, err := kw.Append(newSha1Key("k"), []byte("v"), opts); err != errTxLogIDTooLarge {
+		t.Errorf("expected errTxLogIDTooLarge, got: %v", err)
+	}
+}
+
+func TestKeyTxLogWriterFailedBatch(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the batches are written directly, without the writer goroutine
+	kw := &keyTxLogWriter{txlog: klog, chWrite: make(chan *txLogWrite, 4)}
+	defer kw.closeFile()
+	newWrite := func(value string, opts *AppendOptions) *txLogWrite {
+		return &txLogWrite{key: newSha1Key("k"), value: []byte(value), opts: opts, chErr: make(chan error, 1)}
+	}
+
+	first := newWrite("before", nil)
+	kw.writeBatch(first)
+	if err = <-first.chErr; err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(klog.writeLogName)
+	size := fi.Size()
+
+	// The large value is flushed to the log before the bad ID fails the batch. Append rejects the
+	// ID before it's queued; it stands in for any failed write.
+	large := newWrite(strings.Repeat("l", 16*1024), nil) // larger than the bufio buffer
+	bad := newWrite("bad", &AppendOptions{ID: strings.Repeat("x", maxTxLogIDSize+1)})
+	kw.chWrite <- bad
+	kw.writeBatch(large)
+	if <-large.chErr == nil || <-bad.chErr == nil {
+		t.Fatal("expected the batch to fail")
+	}
+	if fi, _ = os.Stat(klog.writeLogName); fi.Size() != size {
+		t.Errorf("expected the failed batch to be truncated away; size: %d, expected: %d", fi.Size(), size)
+	}
+
+	last := newWrite("after", nil)
+	kw.writeBatch(last)
+	if err = <-last.chErr; err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	err = klog.readLog(klog.writeLogName, func(key hashableKey, r io.Reader, opts *AppendOptions) error {
+		b, err := ioutil.ReadAll(r)
+		if len(b) > 10 {
+			b = append(b[:10], "..."...)
+		}
+		got = append(got, string(b))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "[before after]" {
+		t.Errorf("unexpected blocks in the log: %v", got)
+	}
+}
+
+func helpMkTxLogWriter(t *testing.T, dir string, conf *Config) *keyTxLogWriter {
+	k, err := newKeyTxLogWriter(dir, dir+"/keys", conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k.(*keyTxLogWriter)
+}
+
+func helpValidateKeyContent(t *testing.T, keyPath, key string, values []string) {
+
+	k, err := OpenKey(keyPath, newSha1Key(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{}
+	err = k.ReadEach(func(r io.Reader) error {
+		b, err := ioutil.ReadAll(r)
+		got = append(got, string(b))
+		return err
+	})
+	if err != nil {
+		t.Errorf("error reading key: %s: %s", key, err)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Errorf("key '%s' content doesn't match. Expected: %v, got: %v", key, values, got)
+	}
+}
+
+func TestRecoverTxLog(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+	keyPath := testDir + "/keys"
+
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A rotated log that was only partly committed before the crash
+	for _, v := range []string{"a1", "a2"} {
+		if _, err := klog.Append(newSha1Key("a"), []byte(v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := klog.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	k, _ := OpenKey(keyPath, newSha1Key("a"))
+	if err := k.Append([]byte("a1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// and a pending writing log with a torn block at the end
+	pending := map[string]string{"a3": "a", "b1": "b", "torn": "b"}
+	for _, v := range []string{"a3", "b1", "torn"} {
+		if _, err := klog.Append(newSha1Key(pending[v]), []byte(v), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fi, _ := os.Stat(klog.writeLogName)
+	os.Truncate(klog.writeLogName, fi.Size()-2)
+
+	conf := NewConfig()
+	conf.TxLog.Committers = 2
+	n, err := recoverTxLog(testDir, keyPath, conf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 recovered blocks, got: %d", n)
+	}
+
+	helpValidateKeyContent(t, keyPath, "a", []string{"a1", "a2", "a3"})
+	helpValidateKeyContent(t, keyPath, "b", []string{"b1"})
+
+	logs, _ := klog.readyLogs()
+	if len(logs) != 0 {
+		t.Errorf("expected the recovered logs to be removed, found: %v", logs)
+	}
+
+	// recovering again is a NOP
+	n, err = recoverTxLog(testDir, keyPath, conf, nil)
+	if err != nil || n != 0 {
+		t.Errorf("expected nothing to recover, got: %d, %v", n, err)
+	}
+}
+
+// TestRecoverTxLogCrashWindow replays a log after a crash between committing its blocks to a key
+// that doesn't dedupe by content and saving their position in the key's txapplied file.
+func TestRecoverTxLogCrashWindow(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+	keyPath := testDir + "/keys"
+
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []string{"a1", "a2"} {
+		if _, err := klog.Append(newSha1Key("a"), []byte(v), &AppendOptions{Dedupe: DEDUPE_NONE}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logName, err := klog.rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ioutil.ReadFile(logName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = recoverTxLog(testDir, keyPath, NewConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	helpValidateKeyContent(t, keyPath, "a", []string{"a1", "a2"})
+
+	// The log is still there and txapplied didn't make it to disk
+	if err = ioutil.WriteFile(logName, saved, defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	k, _ := OpenKey(keyPath, newSha1Key("a"))
+	if err = os.Truncate(k.txAppliedFileName, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = recoverTxLog(testDir, keyPath, NewConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	helpValidateKeyContent(t, keyPath, "a", []string{"a1", "a2"})
+}
+
+// TestRecoverTxLogAfterFailedBlock commits a block to a key after an earlier block for it failed
+// and then replays both logs.
+func TestRecoverTxLogAfterFailedBlock(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+	keyPath := testDir + "/keys"
+
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs := []string{}
+	for _, v := range []string{"a1", "a2"} {
+		if _, err := klog.Append(newSha1Key("a"), []byte(v), &AppendOptions{Dedupe: DEDUPE_NONE}); err != nil {
+			t.Fatal(err)
+		}
+		logName, err := klog.rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		logs = append(logs, logName)
+	}
+
+	// a file in place of the key's directory fails the first block
+	keyDir := keyDirName(keyPath, newSha1Key("a"))
+	if err = os.MkdirAll(filepath.Dir(keyDir), defaultDirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyDir, nil, defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	d, err := newTxLogDispatcher(klog, keyPath, NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.run()
+	if _, err = d.apply(logs[0]); err == nil {
+		t.Error("expected the first log to fail")
+	}
+	os.Remove(keyDir)
+	if _, err = d.apply(logs[1]); err == nil {
+		t.Error("expected the second log to be held back")
+	}
+	d.close()
+	d.wait()
+
+	if _, err = recoverTxLog(testDir, keyPath, NewConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	helpValidateKeyContent(t, keyPath, "a", []string{"a1", "a2"})
+}
+
+func TestRecoverTxLogQuarantine(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+	keyPath := testDir + "/keys"
+
+	klog, err := openKeyTxLog(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAndRotate := func(blocks ...string) string {
+		for i := 0; i < len(blocks); i += 2 {
+			if _, err := klog.Append(newSha1Key(blocks[i]), []byte(blocks[i+1]), &AppendOptions{Dedupe: DEDUPE_NONE}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		logName, err := klog.rotate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return logName
+	}
+	quarantined := func() []string {
+		logs, _ := filepath.Glob(klog.quarantineDir + "/*.log")
+		return logs
+	}
+
+	// a log with a corrupt block doesn't hold up the log after it
+	corrupt := appendAndRotate("a", "a1", "c", "c1")
+	file, err := os.OpenFile(corrupt, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := file.Stat()
+	file.WriteAt([]byte("X"), fi.Size()-1)
+	file.Close()
+	appendAndRotate("a", "a2", "b", "b1")
+
+	n, err := recoverTxLog(testDir, keyPath, NewConfig(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("expected 3 recovered blocks, got: %d", n)
+	}
+	helpValidateKeyContent(t, keyPath, "a", []string{"a1", "a2"})
+	helpValidateKeyContent(t, keyPath, "b", []string{"b1"})
+	if logs := quarantined(); len(logs) != 1 || filepath.Base(logs[0]) != filepath.Base(corrupt) {
+		t.Errorf("expected the corrupt log to be quarantined, got: %v", logs)
+	}
+
+	writeManifest(testDir, &manifest{Format: STORE_FORMAT_VERSION})
+	report, err := Fsck(testDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reported := false
+	for _, p := range report.Problems {
+		reported = reported || strings.HasPrefix(p.Problem, "quarantined")
+	}
+	if !reported {
+		t.Errorf("expected fsck to report the quarantined log, got: %v", report.Problems)
+	}
+
+	// a log with a block that fails is quarantined and the key's later blocks are held back, even
+	// after a restart, until it's moved back
+	keyDir := keyDirName(keyPath, newSha1Key("d"))
+	if err = os.MkdirAll(filepath.Dir(keyDir), defaultDirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyDir, nil, defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	appendAndRotate("d", "d1")
+	if _, err = recoverTxLog(testDir, keyPath, NewConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(keyDir)
+	appendAndRotate("d", "d2")
+	if _, err = recoverTxLog(testDir, keyPath, NewConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	helpValidateKeyContent(t, keyPath, "d", []string{})
+	if logs := quarantined(); len(logs) != 3 {
+		t.Fatalf("expected 3 quarantined logs, got: %v", logs)
+	}
+
+	for _, logName := range quarantined()[1:] {
+		if err = os.Rename(logName, klog.readLogDir+"/"+filepath.Base(logName)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = recoverTxLog(testDir, keyPath, NewConfig(), nil); err != nil {
+		t.Fatal(err)
+	}
+	helpValidateKeyContent(t, keyPath, "d", []string{"d1", "d2"})
+}
@@ -0,0 +1,93 @@
+package astore
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+// keyLocks serializes the changes made to each key by this process. Appends hold a key's lock
+// while they write and a trim holds it while the key is rewritten.
+var keyLocks = &keyLockTable{}
+
+// keyLockStripes is the number of mutexes in keyLocks. Keys share the mutex picked by the first
+// bytes of their hash so a lock never has to be allocated or freed. None of the changes to a key
+// lock another key so keys sharing a stripe only wait for each other.
+const keyLockStripes = 1024
+
+type keyLockTable struct {
+	stripes [keyLockStripes]sync.Mutex
+}
+
+// lock locks hk and returns the function that unlocks it.
+func (t *keyLockTable) lock(hk hashableKey) func() {
+
+	h := hk.Get()
+	mu := &t.stripes[(int(h[0])<<8|int(h[1]))%keyLockStripes]
+	mu.Lock()
+	return mu.Unlock
+}
+
+// lockSuffix is added to the name of a key's directory to get the name of its lock file. The
+// file is kept next to the directory rather than in it so that it isn't moved by a trim or a
+// delete while it's held, and so that it can be locked before an append creates the directory.
+// It's left behind when a deleted key is reclaimed; removing it could let two processes hold
+// different lock files for the same key.
+const lockSuffix = ".lock"
+
+// lockKey locks hk in keyLocks and, with files set, takes an exclusive flock on the lock file of
+// keyDir so that other processes using the store wait as well. It returns the function that
+// unlocks both.
+func lockKey(hk hashableKey, keyDir string, files bool) (func(), error) {
+
+	unlock := keyLocks.lock(hk)
+	if !files {
+		return unlock, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(keyDir), defaultDirPermissions); err != nil {
+		unlock()
+		return nil, err
+	}
+	file, err := os.OpenFile(keyDir+lockSuffix, os.O_CREATE|os.O_RDWR, defaultFilePermisions)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		file.Close()
+		unlock()
+		return nil, err
+	}
+	return func() {
+		// closing the file releases the flock
+		file.Close()
+		unlock()
+	}, nil
+}
+
+// lock locks the key in this process and, if Config.Key.LockFiles is set, in every process using
+// the store. See lockKey.
+func (k *Key) lock() (func(), error) {
+	return lockKey(k.keyName, k.keyDir, k.lockFiles)
+}
+
+// storeLockFileName is the lock file at the root of a store. An open store holds a shared flock on
+// it so that Fsck can tell whether the store is in use.
+const storeLockFileName = "LOCK"
+
+// lockStore takes a non-blocking flock on the lock file of the store at path; how is
+// syscall.LOCK_SH or syscall.LOCK_EX. It fails with syscall.EWOULDBLOCK if the store is locked
+// the other way. Closing the returned file releases the lock.
+func lockStore(path string, how int) (*os.File, error) {
+
+	file, err := os.OpenFile(path+"/"+storeLockFileName, os.O_CREATE|os.O_RDWR, defaultFilePermisions)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(file.Fd()), how|syscall.LOCK_NB); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
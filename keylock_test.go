@@ -0,0 +1,134 @@
+package astore
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestConcurrentWritesToKey hammers a single key from many goroutines. Run it with -race.
+func TestConcurrentWritesToKey(t *testing.T) {
+
+	for _, lockFiles := range []bool{false, true} {
+		dir, err := ioutil.TempDir("", "al-store-")
+		if err != nil {
+			t.Fatal("Failed to create temporary directory:", err)
+		}
+		defer os.RemoveAll(dir)
+
+		conf := NewConfig()
+		conf.Key.LockFiles = lockFiles
+		store := newStoreWithConfig(dir, conf)
+		if err = store.Initialize(); err != nil {
+			t.Fatal("Failed to initialize the store:", err)
+		}
+
+		const writers, writes = 16, 25
+		wg := sync.WaitGroup{}
+		errs := make(chan error, writers)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < writes; i++ {
+					// every other write is a duplicate of the one before it
+					data := []byte(fmt.Sprintf(`{"writer":%d,"write":%d}`, w, i/2))
+					if err := store.WriteToKey("hot", data); err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatalf("lock files %v: error writing to the key: %s", lockFiles, err)
+		}
+		store.Close()
+
+		expected := uint64(writers * (writes + 1) / 2)
+		st, err := store.GetStatsFromKey("hot")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Count != expected {
+			t.Errorf("lock files %v: expected %d records, got: %d", lockFiles, expected, st.Count)
+		}
+		seen := map[string]bool{}
+		err = store.ReadEachRecordFromKey("hot", func(rec *Record) error {
+			if seen[rec.Hash] {
+				return fmt.Errorf("duplicate record: %s", rec.Hash)
+			}
+			seen[rec.Hash] = true
+			return nil
+		}, nil)
+		if err != nil {
+			t.Errorf("lock files %v: error reading the key: %s", lockFiles, err)
+		}
+		if uint64(len(seen)) != expected {
+			t.Errorf("lock files %v: expected to read %d records, got: %d", lockFiles, expected, len(seen))
+		}
+
+		report, err := Fsck(dir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(report.Problems) > 0 {
+			t.Errorf("lock files %v: fsck found problems: %v", lockFiles, report.Problems)
+		}
+	}
+}
+
+func TestKeyLockFile(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hk := newSha1Key("k")
+	keyDir := keyDirName(dir, hk)
+	unlock, err := lockKey(hk, keyDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+
+	// Another process holding the lock file, standing in for astored
+	file, err := os.Open(keyDir + lockSuffix)
+	if err != nil {
+		t.Fatal("expected a lock file next to the key directory:", err)
+	}
+	defer file.Close()
+	if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan struct{})
+	go func() {
+		unlock, err := lockKey(hk, keyDir, true)
+		if err != nil {
+			t.Error(err)
+		} else {
+			unlock()
+		}
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		t.Fatal("took the key's lock while another process held the lock file")
+	case <-time.After(50 * time.Millisecond):
+	}
+	syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the lock file to be released")
+	}
+}
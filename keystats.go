@@ -0,0 +1,207 @@
+package astore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// KeyStats describes the records in a key.
+type KeyStats struct {
+	Count       uint64    // number of records, including the retracted ones and the retractions
+	Effective   uint64    // number of records default reads return; see Key.Retract
+	Bytes       uint64    // total size of the record payloads before compression
+	FirstAppend time.Time // when the first record was appended; zero if it was written before this was kept
+	LastAppend  time.Time // when the last record was appended; zero if it was written before this was kept
+	LastHash    string    // hex encoded SHA1 of the last record
+	Version     uint8     // block format version of the last record
+	Trimmed     uint64    // number of records trimmed from the start of the key by a retention policy
+	Sealed      time.Time // when the key was sealed; zero if it isn't
+}
+
+// keyStatsEntry is the on disk form of KeyStats. CRC is the CRC32 of the fields before it.
+type keyStatsEntry struct {
+	Count       uint64
+	Bytes       uint64
+	FirstAppend int64
+	LastAppend  int64
+	LastHash    [20]byte
+	Version     uint8
+	_           [3]byte
+	CRC         uint32
+}
+
+const keyStatsEntrySize = 60
+
+var errStaleStats = errors.New("key stats are out of date")
+
+// readStats returns the stats kept in the key's meta file. errStaleStats is returned if the file
+// is missing, torn or doesn't cover count records.
+func (k *Key) readStats(count uint64) (*KeyStats, error) {
+
+	b, err := ioutil.ReadFile(k.metaFileName)
+	if os.IsNotExist(err) {
+		return nil, errStaleStats
+	}
+	if err != nil {
+		return nil, err
+	}
+	entry := &keyStatsEntry{}
+	if len(b) != keyStatsEntrySize || binary.Read(bytes.NewReader(b), binary.LittleEndian, entry) != nil ||
+		crc32.ChecksumIEEE(b[:keyStatsEntrySize-4]) != entry.CRC || entry.Count != count {
+		return nil, errStaleStats
+	}
+	st := &KeyStats{
+		Count:    entry.Count,
+		Bytes:    entry.Bytes,
+		LastHash: strings.ToUpper(hex.EncodeToString(entry.LastHash[:])),
+		Version:  entry.Version,
+	}
+	if entry.FirstAppend != 0 {
+		st.FirstAppend = time.Unix(0, entry.FirstAppend)
+	}
+	if entry.LastAppend != 0 {
+		st.LastAppend = time.Unix(0, entry.LastAppend)
+	}
+	return st, nil
+}
+
+// writeStats writes st to the key's meta file. The file is written in place; a torn write fails
+// its CRC and the stats are rebuilt.
+func (k *Key) writeStats(st *KeyStats) error {
+
+	entry := &keyStatsEntry{
+		Count:   st.Count,
+		Bytes:   st.Bytes,
+		Version: st.Version,
+	}
+	if !st.FirstAppend.IsZero() {
+		entry.FirstAppend = st.FirstAppend.UnixNano()
+	}
+	if !st.LastAppend.IsZero() {
+		entry.LastAppend = st.LastAppend.UnixNano()
+	}
+	if _, err := hex.Decode(entry.LastHash[:], []byte(st.LastHash)); err != nil {
+		return fmt.Errorf("error decoding record hash: %s", err)
+	}
+	buf := &bytes.Buffer{}
+	if err := binary.Write(buf, binary.LittleEndian, entry); err != nil {
+		return err
+	}
+	b := buf.Bytes()
+	binary.LittleEndian.PutUint32(b[keyStatsEntrySize-4:], crc32.ChecksumIEEE(b[:keyStatsEntrySize-4]))
+
+	file, err := os.OpenFile(k.metaFileName, os.O_CREATE|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return err
+	}
+	if _, err = file.WriteAt(b, 0); err == nil && k.syncEnabled {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing key stats: %s", err)
+	}
+	return file.Close()
+}
+
+// scanStats works out the stats by reading every record in the key.
+func (k *Key) scanStats() (*KeyStats, error) {
+
+	st := &KeyStats{}
+	_, err := k.ReadFrom(Position{}, 0, func(rec *Record) error {
+		n, err := io.Copy(ioutil.Discard, rec.Data)
+		if err != nil {
+			return err
+		}
+		if st.Count == 0 {
+			st.FirstAppend = rec.Timestamp
+		}
+		st.Count++
+		st.Bytes += uint64(n)
+		st.LastAppend = rec.Timestamp
+		st.LastHash = rec.Hash
+		st.Version = rec.Version
+		return nil
+	}, &ReadOptions{SkipCorrupt: true, IncludeRetracted: true})
+	if err != nil {
+		return nil, err
+	}
+	// skipped blocks still count
+	n, err := k.Count()
+	if err != nil {
+		return nil, err
+	}
+	st.Count = uint64(n)
+	return st, nil
+}
+
+// Stats returns the stats of the key. They're kept up to date as records are appended so the key
+// only has to be read if they're missing, like for a key written before they were kept.
+func (k *Key) Stats() (*KeyStats, error) {
+
+	count, err := k.Count()
+	if err != nil {
+		return nil, err
+	}
+	st, err := k.readStats(uint64(count))
+	if err == errStaleStats {
+		st, err = k.scanStats()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if st.Trimmed, err = k.firstSeq(); err != nil {
+		return nil, err
+	}
+	if st.Sealed, err = k.sealedAt(); err != nil {
+		return nil, err
+	}
+	hidden, err := k.hiddenCount(st.Trimmed)
+	if err != nil {
+		return nil, err
+	}
+	st.Effective = st.Count - hidden
+	return st, nil
+}
+
+// updateStats adds the record that was just appended to the key's stats. The record has already
+// been committed so a failure is only logged; the stats are rebuilt the next time they're found
+// to be out of date.
+func (k *Key) updateStats(rec *appendedRecord, size int64) {
+
+	var st *KeyStats
+	first, err := k.firstSeq()
+	if err == nil {
+		st, err = k.readStats(rec.seq - first)
+	}
+	switch {
+	case err == errStaleStats:
+		// the scan includes the new record
+		st, err = k.scanStats()
+	case err == nil:
+		if st.Count == 0 {
+			st.FirstAppend = rec.timestamp
+		}
+		st.Count++
+		st.Bytes += uint64(size)
+		st.LastAppend = rec.timestamp
+		st.LastHash = rec.hash
+		st.Version = blockVersion
+	}
+	if err == nil {
+		err = k.writeStats(st)
+	}
+	if err != nil {
+		log.Println("ERROR: updating stats for key:", k.keyName, err)
+	}
+}
@@ -0,0 +1,99 @@
+package astore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// STORE_FORMAT_VERSION is the on-disk format written by this version of the store. Stores created
+// before the manifest existed are format 1: unversioned block headers and, for the oldest of
+// them, key directories named after the Go formatting of a byte ("%!s(uint8=10)") instead of its
+// hex value.
+//
+// Format 2 adds versioned block headers to the content files and tx logs, the hex key
+// directories and the store manifest. Format 3 adds the append timestamp and sequence number to
+// the content block headers.
+//
+// Stores from MIN_STORE_FORMAT_VERSION on can be read without being rewritten. Their manifest is
+// updated when they are opened so older versions of astored won't open them after that.
+const (
+	STORE_FORMAT_VERSION     = 3
+	MIN_STORE_FORMAT_VERSION = 2
+	manifestFileName         = "MANIFEST"
+)
+
+// manifest is the store-level metadata kept in the MANIFEST file at the root of a store.
+type manifest struct {
+	Format int `json:"format"`
+}
+
+// readManifest returns the manifest of the store at path. A store without a manifest that
+// already has keys or tx logs is format 1. nil is returned for a new, empty store.
+func readManifest(path string) (*manifest, error) {
+
+	b, err := ioutil.ReadFile(path + "/" + manifestFileName)
+	if os.IsNotExist(err) {
+		for _, dir := range []string{path + "/keys", path + "/txlog"} {
+			if _, err := os.Stat(dir); err == nil {
+				return &manifest{Format: 1}, nil
+			}
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &manifest{}
+	if err = json.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %s", err)
+	}
+	return m, nil
+}
+
+// writeManifest replaces the manifest of the store at path. The new manifest is written next to
+// the old one and renamed over it.
+func writeManifest(path string, m *manifest) error {
+
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	fileName := path + "/" + manifestFileName
+	tmpName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(b, '\n')); err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing manifest: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, fileName)
+}
+
+// checkManifest makes sure the store at path can be opened by this version, writing the manifest
+// of a new store.
+func checkManifest(path string) error {
+
+	m, err := readManifest(path)
+	if err != nil {
+		return err
+	}
+	if m == nil || (m.Format >= MIN_STORE_FORMAT_VERSION && m.Format < STORE_FORMAT_VERSION) {
+		return writeManifest(path, &manifest{Format: STORE_FORMAT_VERSION})
+	}
+	if m.Format != STORE_FORMAT_VERSION {
+		return &StoreFormatError{Path: path, Format: m.Format}
+	}
+	return nil
+}
@@ -1,9 +1,10 @@
 package metastore
 
 import (
+	"bytes"
 	"fmt"
 
-	"github.com/boltdb/bolt"
+	bolt "go.etcd.io/bbolt"
 )
 
 const (
@@ -13,7 +14,7 @@ const (
 )
 
 // boltStore implements the MetaKVStore interface using boltdb local storage
-// https://godoc.org/github.com/boltdb/bolt
+// https://godoc.org/go.etcd.io/bbolt
 type boltStore struct {
 	db   *bolt.DB
 	conf *Config
@@ -22,7 +23,7 @@ type boltStore struct {
 // newBoltStore constructs a new boltStore instance
 func newBoltStore(conf *Config) (*boltStore, error) {
 	// TODO: assert that conf.Bolt.BasePath is set
-	db, err := bolt.Open(fmt.Sprintf("%s/%s", conf.Bolt.BasePath, boltFile), boltPermissions, nil)
+	db, err := bolt.Open(fmt.Sprintf("%s/%s", conf.Bolt.BasePath, conf.Bolt.FileName), boltPermissions, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error opening bold DB: %s", err)
 	}
@@ -41,7 +42,10 @@ func (bs *boltStore) Get(key []byte) ([]byte, error) {
 		if b == nil {
 			return nil
 		}
-		result = b.Get(key)
+		// the value is only valid for the life of the transaction
+		if v := b.Get(key); v != nil {
+			result = append([]byte{}, v...)
+		}
 		return nil
 	})
 	return result, err
@@ -67,6 +71,51 @@ func (bs *boltStore) Put(key []byte, value []byte) error {
 	return nil
 }
 
+// Delete removes key and its value. Deleting a key that doesn't exist isn't an error. Delete
+// implements the MetaKVStore Delete interface.
+func (bs *boltStore) Delete(key []byte) error {
+
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return nil
+		}
+		return b.Delete(key)
+	})
+	if err != nil {
+		return fmt.Errorf("delete error: %s: %s: %s", bucketName, key, err)
+	}
+	return nil
+}
+
+// Scan calls f for up to limit keys that start with prefix, in order, starting with the first key
+// after after. A nil after starts with the first key with the prefix and a limit <= 0 scans all of
+// them. Scan implements the MetaKVStore Scan interface.
+func (bs *boltStore) Scan(prefix, after []byte, limit int, f ScanFunc) error {
+
+	return bs.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		k, v := c.Seek(prefix)
+		if after != nil && bytes.Compare(after, prefix) >= 0 {
+			k, v = c.Seek(after)
+			if bytes.Equal(k, after) {
+				k, v = c.Next()
+			}
+		}
+		for n := 0; k != nil && bytes.HasPrefix(k, prefix) && (limit <= 0 || n < limit); n++ {
+			if err := f(k, v); err != nil {
+				return err
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+}
+
 func (bs *boltStore) Close() error {
 	return bs.db.Close()
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -58,6 +59,30 @@ func TestBoltStorePutGet(t *testing.T) {
 
 }
 
+func TestBoltStoreDelete(t *testing.T) {
+
+	store, testDir := initTest(t)
+	defer os.RemoveAll(testDir)
+	key := []byte("foo-key")
+
+	if err := store.Delete(key); err != nil {
+		t.Fatal("Delete of a missing key returned an error:", err)
+	}
+	if err := store.Put(key, []byte("the data")); err != nil {
+		t.Fatal("Put returned an error:", err)
+	}
+	if err := store.Delete(key); err != nil {
+		t.Fatal("Delete returned an error:", err)
+	}
+	vget, err := store.Get(key)
+	if err != nil {
+		t.Fatal("Get returned an error:", err)
+	}
+	if len(vget) != 0 {
+		t.Errorf("Expected the key to be deleted, got: %s", vget)
+	}
+}
+
 func initTest(t *testing.T) (*boltStore, string) {
 
 	testDir, err := ioutil.TempDir("", "bolt-test")
@@ -75,3 +100,53 @@ func initTest(t *testing.T) (*boltStore, string) {
 
 	return store, testDir
 }
+
+func TestBoltStoreScan(t *testing.T) {
+
+	store, testDir := initTest(t)
+	defer os.RemoveAll(testDir)
+
+	for _, k := range []string{"a", "b/1", "b/2", "b/3", "c"} {
+		if err := store.Put([]byte(k), []byte("v"+k)); err != nil {
+			t.Fatal("Put returned an error:", err)
+		}
+	}
+
+	scan := func(prefix, after string, limit int) string {
+		var a []byte
+		if after != "" {
+			a = []byte(after)
+		}
+		keys := []string{}
+		err := store.Scan([]byte(prefix), a, limit, func(k, v []byte) error {
+			if string(v) != "v"+string(k) {
+				t.Errorf("wrong value for %s: %s", k, v)
+			}
+			keys = append(keys, string(k))
+			return nil
+		})
+		if err != nil {
+			t.Fatal("Scan returned an error:", err)
+		}
+		return strings.Join(keys, ",")
+	}
+
+	cases := []struct {
+		prefix, after string
+		limit         int
+		expected      string
+	}{
+		{"", "", 0, "a,b/1,b/2,b/3,c"},
+		{"b/", "", 0, "b/1,b/2,b/3"},
+		{"b/", "", 2, "b/1,b/2"},
+		{"b/", "b/2", 0, "b/3"},
+		{"b/", "a", 0, "b/1,b/2,b/3"},
+		{"", "b/3", 1, "c"},
+		{"d", "", 0, ""},
+	}
+	for _, c := range cases {
+		if got := scan(c.prefix, c.after, c.limit); got != c.expected {
+			t.Errorf("scan(%q, %q, %d): expected: %s, got: %s", c.prefix, c.after, c.limit, c.expected, got)
+		}
+	}
+}
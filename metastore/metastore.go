@@ -3,9 +3,14 @@ package metastore
 type KVStore interface {
 	Get(key []byte) ([]byte, error)
 	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	Scan(prefix, after []byte, limit int, f ScanFunc) error
 	Close() error
 }
 
+// ScanFunc is called with each key and value found by Scan. They're only valid until it returns.
+type ScanFunc func(key, value []byte) error
+
 type KVStoreType int
 
 const (
@@ -15,11 +20,14 @@ const (
 type Config struct {
 	Bolt struct {
 		BasePath string
+		FileName string // name of the bolt file in BasePath
 	}
 }
 
 func NewConfig() *Config {
-	return &Config{}
+	conf := &Config{}
+	conf.Bolt.FileName = boltFile
+	return conf
 }
 
 func NewKVStore(storeType KVStoreType, config *Config) (store KVStore, err error) {
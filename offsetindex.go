@@ -0,0 +1,328 @@
+package astore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+const offsetEntrySize = 16 // segment(8) + offset(8)
+
+// offsetEntry is the location of a record's block.
+type offsetEntry struct {
+	Segment uint64
+	Offset  uint64
+}
+
+// offsetIndex is a per-key file of fixed size entries, one per record, with the segment and
+// byte offset of the record's block. Entry N is at N*offsetEntrySize so finding record N doesn't
+// depend on the size of the key.
+//
+// The entry for a record is written after its hash log entry. The hash log is the source of
+// truth for the number of records so an index that doesn't have the same number of entries,
+// say after a crash, is rebuilt from the content.
+type offsetIndex struct {
+	fileName string
+}
+
+func newOffsetIndex(fileName string) *offsetIndex {
+	return &offsetIndex{fileName: fileName}
+}
+
+// entries returns the number of entries in the index.
+func (oi *offsetIndex) entries() (uint64, error) {
+	fi, err := os.Stat(oi.fileName)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fi.Size() / offsetEntrySize), nil
+}
+
+func (oi *offsetIndex) add(seg int, offset int64) error {
+
+	file, err := os.OpenFile(oi.fileName, os.O_CREATE|os.O_APPEND|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return err
+	}
+	err = binary.Write(file, binary.LittleEndian, &offsetEntry{uint64(seg), uint64(offset)})
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing offset index: %s", err)
+	}
+	return file.Close()
+}
+
+// get returns the location of record i.
+func (oi *offsetIndex) get(i uint64) (int, int64, error) {
+
+	entries, err := oi.read(i, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int(entries[0].Segment), int64(entries[0].Offset), nil
+}
+
+// read returns the locations of n records starting with record i.
+func (oi *offsetIndex) read(i, n uint64) ([]offsetEntry, error) {
+
+	file, err := os.Open(oi.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	b := make([]byte, n*offsetEntrySize)
+	if _, err = file.ReadAt(b, int64(i)*offsetEntrySize); err != nil {
+		return nil, fmt.Errorf("error reading offset index: %s", err)
+	}
+	entries := make([]offsetEntry, n)
+	if err = binary.Read(bytes.NewReader(b), binary.LittleEndian, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// indexOffset adds the location of record seq to the offset index. If the index is out of step
+// with the key it's rebuilt instead, which picks up the new record as well.
+//
+// The index starts with the first record in the key so the entry for record seq is at seq less the
+// sequence number of the first record.
+func (k *Key) indexOffset(seq uint64, seg int, offset int64) error {
+
+	n, err := k.offsets.entries()
+	if err != nil {
+		return err
+	}
+	first, err := k.firstSeq()
+	if err != nil {
+		return err
+	}
+	if n != seq-first {
+		return k.rebuildOffsets()
+	}
+	return k.offsets.add(seg, offset)
+}
+
+// rebuildOffsets rewrites the offset index from the block headers in the content segments.
+func (k *Key) rebuildOffsets() error {
+
+	last, err := k.lastSegment()
+	if err != nil {
+		return err
+	}
+
+	// Readers rebuild the index too so each rebuild gets its own temporary file.
+	file, err := ioutil.TempFile(k.keyDir, "offsets.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := file.Name()
+	w := bufio.NewWriter(file)
+	for seg := 0; seg <= last && err == nil; seg++ {
+		err = k.scanOffsets(seg, func(offset int64) error {
+			return binary.Write(w, binary.LittleEndian, &offsetEntry{uint64(seg), uint64(offset)})
+		})
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return fmt.Errorf("error rebuilding offset index: %s", err)
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, k.offsets.fileName)
+}
+
+// scanOffsets calls f with the offset of each block in a content segment. It stops quietly at
+// a damaged block; the records after it can't be located.
+func (k *Key) scanOffsets(seg int, f func(offset int64) error) error {
+
+	file, err := os.Open(k.contentSegmentName(seg))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(file)
+	var offset int64
+	for {
+		header, err := readBlockHeader(r)
+		if err != nil || header.Length > uint64(fi.Size()-offset-header.size) {
+			return nil
+		}
+		if _, err = r.Discard(int(header.Length)); err != nil {
+			return nil
+		}
+		if err = f(offset); err != nil {
+			return err
+		}
+		offset += header.size + int64(header.Length)
+	}
+}
+
+// ErrInvalidPosition is returned for a Position that doesn't point at a record in the key.
+var ErrInvalidPosition = errors.New("invalid position")
+
+// Position is a position in a key: the segment and byte offset of the next record to read along
+// with its sequence number. Positions are passed around as the opaque strings returned by String.
+type Position struct {
+	Segment int
+	Offset  int64
+	Seq     uint64
+}
+
+func (c Position) String() string {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.BigEndian, &[3]uint64{uint64(c.Segment), uint64(c.Offset), c.Seq})
+	return base64.RawURLEncoding.EncodeToString(b.Bytes())
+}
+
+// ParsePosition returns the Position encoded in s by Position.String.
+func ParsePosition(s string) (Position, error) {
+
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) != 24 {
+		return Position{}, ErrInvalidPosition
+	}
+	v := [3]uint64{}
+	binary.Read(bytes.NewReader(b), binary.BigEndian, &v)
+	return Position{Segment: int(v[0]), Offset: int64(v[1]), Seq: v[2]}, nil
+}
+
+// PositionAt returns the position of record i. If there is no record i the position is
+// the end of the key; it's where the next record appended to the key will be read from. If
+// record i has been trimmed it's the position of the first record.
+func (k *Key) PositionAt(i uint64) (Position, error) {
+
+	count, err := k.nextSeq()
+	if err != nil {
+		return Position{}, err
+	}
+	if i >= count {
+		return k.endPosition(count)
+	}
+	first, err := k.firstSeq()
+	if err != nil {
+		return Position{}, err
+	}
+	if i < first {
+		i = first
+	}
+	if err = k.syncOffsets(count); err != nil {
+		return Position{}, err
+	}
+	seg, offset, err := k.offsets.get(i - first)
+	if err != nil {
+		return Position{}, err
+	}
+	return Position{Segment: seg, Offset: offset, Seq: i}, nil
+}
+
+// syncOffsets rebuilds the offset index if it doesn't have an entry for each of the count records
+// in the key. An index with more entries than that is fine; a reader may have rebuilt it while a
+// record was being appended.
+func (k *Key) syncOffsets(count uint64) error {
+
+	n, err := k.offsets.entries()
+	if err != nil {
+		return err
+	}
+	first, err := k.firstSeq()
+	if err != nil || first+n >= count {
+		return err
+	}
+	return k.rebuildOffsets()
+}
+
+// endPosition returns the position after the last of the count records in the key. Content written
+// after the last record's hash log entry isn't part of the key yet so the end isn't simply the size
+// of the last segment.
+func (k *Key) endPosition(count uint64) (Position, error) {
+	first, err := k.firstSeq()
+	if err != nil {
+		return Position{}, err
+	}
+	if count == first {
+		return Position{Seq: first}, nil
+	}
+	if err := k.syncOffsets(count); err != nil {
+		return Position{}, err
+	}
+	return k.recordEnd(count - 1)
+}
+
+// recordEnd returns the position right after record i.
+func (k *Key) recordEnd(i uint64) (Position, error) {
+
+	first, err := k.firstSeq()
+	if err != nil {
+		return Position{}, err
+	}
+	seg, offset, err := k.offsets.get(i - first)
+	if err != nil {
+		return Position{}, err
+	}
+	file, err := os.Open(k.contentSegmentName(seg))
+	if err != nil {
+		return Position{}, err
+	}
+	defer file.Close()
+
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return Position{}, err
+	}
+	header, err := readBlockHeader(file)
+	if err != nil {
+		return Position{}, fmt.Errorf("error reading block %d: %s", i, err)
+	}
+	return Position{Segment: seg, Offset: offset + header.size + int64(header.Length), Seq: i + 1}, nil
+}
+
+// checkPosition returns ErrInvalidPosition unless c points at a record in the key or at its end.
+func (k *Key) checkPosition(c Position) error {
+
+	at, err := k.PositionAt(c.Seq)
+	if err != nil {
+		return err
+	}
+	if at == c {
+		return nil
+	}
+	// The end of a record is as good as the start of the next one, which may be at the start
+	// of a later segment.
+	first, err := k.firstSeq()
+	if err != nil {
+		return err
+	}
+	if c.Seq == at.Seq && c.Seq > first && c.Segment < at.Segment {
+		prev, err := k.recordEnd(c.Seq - 1)
+		if err != nil {
+			return err
+		}
+		if prev == c {
+			return nil
+		}
+	}
+	return ErrInvalidPosition
+}
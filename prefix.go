@@ -0,0 +1,117 @@
+package astore
+
+import (
+	"container/heap"
+	"errors"
+)
+
+// PrefixRecordFunc is called with each record read from the keys under a prefix along with the
+// name of the key it's from. rec.Data is only valid until it returns.
+type PrefixRecordFunc func(key string, rec *Record) error
+
+// ErrTooManyPrefixKeys is returned by ReadPrefix for a prefix with more keys than
+// Config.Read.MaxPrefixKeys.
+var ErrTooManyPrefixKeys = errors.New("too many keys under the prefix")
+
+// errNamesFull stops the scan of the names under a prefix once it's over the limit.
+var errNamesFull = errors.New("names full")
+
+// readPrefix calls f for up to limit records from the keys in the registry that start with prefix,
+// merged into one stream in the order they were appended. The merge needs a cursor on each key so
+// a prefix with more than maxKeys keys fails with ErrTooManyPrefixKeys before anything is read. A
+// maxKeys <= 0 doesn't limit them. See store.ReadPrefix.
+func readPrefix(keyPath string, conf *Config, r *keyRegistry, prefix string, maxKeys, limit int, f PrefixRecordFunc) error {
+
+	names := []string{}
+	err := r.names(prefix, func(name string) error {
+		if maxKeys > 0 && len(names) == maxKeys {
+			return errNamesFull
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err == errNamesFull {
+		return ErrTooManyPrefixKeys
+	}
+	if err != nil {
+		return err
+	}
+
+	m := prefixMerge{}
+	defer func() {
+		for _, pc := range m {
+			pc.c.Close()
+		}
+	}()
+	for _, name := range names {
+		k, err := OpenKeyWithConfig(keyPath, newSha1Key(name), conf)
+		if err != nil {
+			return err
+		}
+		c, err := k.openCursor(Position{}, nil)
+		if err != nil {
+			return err
+		}
+		pc := &prefixCursor{name: name, c: c}
+		if !c.Next() {
+			c.Close()
+			if err = c.Err(); err != nil {
+				return err
+			}
+			continue
+		}
+		m = append(m, pc)
+	}
+	heap.Init(&m)
+
+	for n := 0; len(m) > 0 && (limit <= 0 || n < limit); n++ {
+		pc := m[0]
+		if err = f(pc.name, pc.c.rec); err != nil {
+			return err
+		}
+		if pc.c.Next() {
+			heap.Fix(&m, 0)
+			continue
+		}
+		heap.Pop(&m)
+		pc.c.Close()
+		if err = pc.c.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type prefixCursor struct {
+	name string
+	c    *keyCursor
+}
+
+// prefixMerge is a heap of cursors ordered by the record each one is on. Records are ordered by
+// timestamp, then by key name and sequence number. Records written before timestamps were kept
+// sort first.
+type prefixMerge []*prefixCursor
+
+func (m prefixMerge) Len() int { return len(m) }
+
+func (m prefixMerge) Less(i, j int) bool {
+	a, b := m[i].c.rec, m[j].c.rec
+	if !a.Timestamp.Equal(b.Timestamp) {
+		return a.Timestamp.Before(b.Timestamp)
+	}
+	if m[i].name != m[j].name {
+		return m[i].name < m[j].name
+	}
+	return a.Seq < b.Seq
+}
+
+func (m prefixMerge) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+
+func (m *prefixMerge) Push(x interface{}) { *m = append(*m, x.(*prefixCursor)) }
+
+func (m *prefixMerge) Pop() interface{} {
+	old := *m
+	pc := old[len(old)-1]
+	*m = old[:len(old)-1]
+	return pc
+}
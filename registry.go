@@ -0,0 +1,197 @@
+package astore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/skyec/astore/metastore"
+)
+
+const registryFile = "keys.bolt"
+
+// KEY_SEPARATOR separates the parts of a hierarchical key name like tenant/42/orders/7.
+const KEY_SEPARATOR = "/"
+
+var (
+	errNextChild = errors.New("next child") // stops a registry scan at the start of the next child's keys
+	errListFull  = errors.New("list full")  // stops a registry scan once there are enough keys
+)
+
+// KeyInfo describes a key in the store.
+type KeyInfo struct {
+	Name    string    // the key's name
+	Created time.Time // when the key was first appended to
+	Count   int       // number of records in the key
+
+	Attributes map[string]string // the key's attributes; nil if it doesn't have any
+}
+
+// keyRegistry records the names of the keys in the store. Keys are stored under the hash of their
+// name so without it they can't be listed. A key is registered the first time it's appended to;
+// keys written before the registry existed aren't in it.
+type keyRegistry struct {
+	kv      metastore.KVStore
+	attrs   *keyAttributes
+	keyPath string
+	conf    *Config
+}
+
+type registryEntry struct {
+	Created time.Time `json:"created"`
+}
+
+func openKeyRegistry(path, keyPath string, attrs *keyAttributes, conf *Config) (*keyRegistry, error) {
+
+	mconf := metastore.NewConfig()
+	mconf.Bolt.BasePath = path
+	mconf.Bolt.FileName = registryFile
+	kv, err := metastore.NewKVStore(metastore.KV_TYPE_BOLT, mconf)
+	if err != nil {
+		return nil, fmt.Errorf("error opening the key registry: %s", err)
+	}
+	return &keyRegistry{kv: kv, attrs: attrs, keyPath: keyPath, conf: conf}, nil
+}
+
+// register adds name to the registry if it isn't already there.
+func (r *keyRegistry) register(name string) error {
+	return r.registerAt(name, time.Now())
+}
+
+// registerAt adds name to the registry, created at created, if it isn't already there.
+func (r *keyRegistry) registerAt(name string, created time.Time) error {
+
+	v, err := r.kv.Get([]byte(name))
+	if err != nil || len(v) > 0 {
+		return err
+	}
+	b, err := json.Marshal(&registryEntry{Created: created})
+	if err != nil {
+		return err
+	}
+	return r.kv.Put([]byte(name), b)
+}
+
+// created returns when name was registered. It's zero if name isn't in the registry.
+func (r *keyRegistry) created(name string) (time.Time, error) {
+
+	v, err := r.kv.Get([]byte(name))
+	if err != nil || len(v) == 0 {
+		return time.Time{}, err
+	}
+	entry := &registryEntry{}
+	if err = json.Unmarshal(v, entry); err != nil {
+		return time.Time{}, fmt.Errorf("bad registry entry for key: %s: %s", name, err)
+	}
+	return entry.Created, nil
+}
+
+// unregister removes name from the registry.
+func (r *keyRegistry) unregister(name string) error {
+	return r.kv.Delete([]byte(name))
+}
+
+// list returns up to limit keys whose names start with prefix, in order, starting after the key
+// named after. An empty after starts at the beginning. If match is set only the keys with every
+// attribute in it are returned.
+func (r *keyRegistry) list(prefix, after string, limit int, match map[string]string) ([]*KeyInfo, error) {
+
+	var from []byte
+	if after != "" {
+		from = []byte(after)
+	}
+	keys := []*KeyInfo{}
+	err := r.kv.Scan([]byte(prefix), from, 0, func(name, value []byte) error {
+		entry := &registryEntry{}
+		if err := json.Unmarshal(value, entry); err != nil {
+			return fmt.Errorf("bad registry entry for key: %s: %s", name, err)
+		}
+		info := &KeyInfo{Name: string(name), Created: entry.Created}
+		attrs, err := r.attrs.get(newSha1Key(info.Name))
+		if err != nil {
+			return err
+		}
+		if !attributesMatch(attrs, match) {
+			return nil
+		}
+		if len(attrs) > 0 {
+			info.Attributes = attrs
+		}
+		keys = append(keys, info)
+		if limit > 0 && len(keys) == limit {
+			return errListFull
+		}
+		return nil
+	})
+	if err != nil && err != errListFull {
+		return nil, err
+	}
+
+	for _, info := range keys {
+		k, err := OpenKeyWithConfig(r.keyPath, newSha1Key(info.Name), r.conf)
+		if err != nil {
+			return nil, err
+		}
+		if info.Count, err = k.Count(); err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// children returns up to limit names of the children of prefix, in order, starting after the child
+// named after. A key directly under prefix is returned as is and the keys further down are rolled
+// up into the next part of their name, ending in KEY_SEPARATOR. For keys tenant/42/profile and
+// tenant/42/orders/7 the children of tenant/42/ are tenant/42/orders/ and tenant/42/profile.
+func (r *keyRegistry) children(prefix, after string, limit int) ([]string, error) {
+
+	var from []byte
+	if after != "" {
+		from = []byte(after)
+		if strings.HasSuffix(after, KEY_SEPARATOR) {
+			// skip the whole subtree; names are UTF-8 so never contain 0xFF
+			from = append(from, 0xFF)
+		}
+	}
+
+	children := []string{}
+	for limit <= 0 || len(children) < limit {
+		child := ""
+		err := r.kv.Scan([]byte(prefix), from, 0, func(name, _ []byte) error {
+			rest := string(name[len(prefix):])
+			i := strings.Index(rest, KEY_SEPARATOR)
+			if i < 0 {
+				children = append(children, string(name))
+				if limit > 0 && len(children) == limit {
+					return errNextChild
+				}
+				return nil
+			}
+			child = prefix + rest[:i+len(KEY_SEPARATOR)]
+			return errNextChild
+		})
+		if err != nil && err != errNextChild {
+			return nil, err
+		}
+		if child == "" {
+			break
+		}
+		// a scan is started again after each subtree rather than reading through it
+		children = append(children, child)
+		from = append([]byte(child), 0xFF)
+	}
+	return children, nil
+}
+
+// names calls f with the name of each key in the registry that starts with prefix, in order.
+func (r *keyRegistry) names(prefix string, f func(name string) error) error {
+	return r.kv.Scan([]byte(prefix), nil, 0, func(name, _ []byte) error {
+		return f(string(name))
+	})
+}
+
+func (r *keyRegistry) close() error {
+	return r.kv.Close()
+}
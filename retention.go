@@ -0,0 +1,317 @@
+package astore
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RetentionMode is how a RetentionPolicy is enforced.
+type RetentionMode int
+
+const (
+	RETENTION_REJECT RetentionMode = iota // appends to a key that's over its limits fail with ErrQuotaExceeded
+	RETENTION_TRIM                        // the oldest records are trimmed in the background
+)
+
+var retentionModeNames = map[RetentionMode]string{
+	RETENTION_REJECT: "reject",
+	RETENTION_TRIM:   "trim",
+}
+
+func (m RetentionMode) String() string {
+	if name, ok := retentionModeNames[m]; ok {
+		return name
+	}
+	return fmt.Sprintf("retention(%d)", int(m))
+}
+
+// ParseRetentionMode returns the RetentionMode called name.
+func ParseRetentionMode(name string) (RetentionMode, error) {
+	for m, n := range retentionModeNames {
+		if n == name {
+			return m, nil
+		}
+	}
+	return RETENTION_REJECT, fmt.Errorf("unknown retention mode: %s", name)
+}
+
+// ErrQuotaExceeded is returned by appends to a key that's over the limits of a RETENTION_REJECT
+// policy.
+var ErrQuotaExceeded = errors.New("key is over its retention quota")
+
+// RetentionPolicy limits the size of the keys it applies to. A policy applies to the key named Key
+// or, if Key isn't set, to every key whose name starts with Prefix. The policy for a key is the one
+// that names it, otherwise the one with the longest matching prefix. Limits that are 0 aren't
+// enforced.
+//
+// MaxAge is only enforced by RETENTION_TRIM since appending a new record doesn't make the old ones
+// any younger. Records written before timestamps were kept are trimmed along with the first record
+// after them that's too old.
+type RetentionPolicy struct {
+	Key        string
+	Prefix     string
+	MaxRecords uint64
+	MaxBytes   uint64 // total size of the record payloads before compression
+	MaxAge     time.Duration
+	Mode       RetentionMode
+}
+
+func (p *RetentionPolicy) String() string {
+	name := "prefix " + p.Prefix
+	if p.Key != "" {
+		name = "key " + p.Key
+	}
+	return fmt.Sprintf("%s: max records: %d, max bytes: %d, max age: %s, mode: %s", name, p.MaxRecords, p.MaxBytes, p.MaxAge, p.Mode)
+}
+
+// retentionPolicy returns the policy for the key named name or nil if there isn't one.
+func (conf *Config) retentionPolicy(name string) *RetentionPolicy {
+
+	var match *RetentionPolicy
+	for i := range conf.Retention.Policies {
+		p := &conf.Retention.Policies[i]
+		switch {
+		case p.Key != "":
+			if p.Key == name {
+				return p
+			}
+		case strings.HasPrefix(name, p.Prefix):
+			if match == nil || len(p.Prefix) > len(match.Prefix) {
+				match = p
+			}
+		}
+	}
+	return match
+}
+
+// allows reports if a record of size bytes can be appended to a key with the stats st. A size < 0
+// isn't known and only the current size of the key is checked.
+func (p *RetentionPolicy) allows(st *KeyStats, size int64) bool {
+	if p.MaxRecords > 0 && st.Count >= p.MaxRecords {
+		return false
+	}
+	if size < 0 {
+		size = 0
+	}
+	return p.MaxBytes == 0 || st.Bytes+uint64(size) <= p.MaxBytes
+}
+
+// exceeded reports if a key with the stats st may be over the policy's limits at now. Only the age
+// needs the key to be read to be sure.
+func (p *RetentionPolicy) exceeded(st *KeyStats, now time.Time) bool {
+	if p.MaxRecords > 0 && st.Count > p.MaxRecords {
+		return true
+	}
+	if p.MaxBytes > 0 && st.Bytes > p.MaxBytes {
+		return true
+	}
+	if p.MaxAge > 0 && st.Count > 0 {
+		cutoff := now.Add(-p.MaxAge)
+		if st.FirstAppend.IsZero() {
+			// the first records don't have timestamps; the later ones may be old enough
+			return !st.LastAppend.IsZero()
+		}
+		return st.FirstAppend.Before(cutoff)
+	}
+	return false
+}
+
+// errTrimFound stops the scan for records to trim once the first one to keep has been found.
+var errTrimFound = errors.New("found the records to trim")
+
+// trimCount returns the number of records at the start of k that have to be trimmed to bring it
+// within the policy's limits at now.
+func (p *RetentionPolicy) trimCount(k *Key, now time.Time) (uint64, error) {
+
+	st, err := k.Stats()
+	if err != nil || !p.exceeded(st, now) {
+		return 0, err
+	}
+
+	var drop uint64
+	if p.MaxRecords > 0 && st.Count > p.MaxRecords {
+		drop = st.Count - p.MaxRecords
+	}
+	if p.MaxBytes == 0 && p.MaxAge == 0 {
+		return drop, nil
+	}
+
+	cutoff := now.Add(-p.MaxAge)
+	size := st.Bytes
+	var i uint64
+	_, err = k.ReadFrom(Position{}, 0, func(rec *Record) error {
+		overSize := p.MaxBytes > 0 && size > p.MaxBytes
+		tooOld := p.MaxAge > 0 && (rec.Timestamp.IsZero() || rec.Timestamp.Before(cutoff))
+		if !overSize && !tooOld {
+			return errTrimFound
+		}
+		n, err := io.Copy(ioutil.Discard, rec.Data)
+		if err != nil {
+			return err
+		}
+		size -= uint64(n)
+		i++
+		if overSize || !rec.Timestamp.IsZero() {
+			// records without a timestamp only go with a later one that's too old
+			if i > drop {
+				drop = i
+			}
+		}
+		return nil
+	}, &ReadOptions{SkipCorrupt: true, IncludeRetracted: true})
+	if err != nil && err != errTrimFound {
+		return 0, err
+	}
+	return drop, nil
+}
+
+// trim removes the oldest drop records from the key. The records that are kept are copied, with
+// their sequence numbers, to a new directory next to the key's, which is then swapped in. Appends
+// to the key wait until it's done. Cursors opened before the trim can't be used after it.
+func (k *Key) trim(drop uint64) error {
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := k.finishTrim(); err != nil {
+		return err
+	}
+	first, err := k.firstSeq()
+	if err != nil {
+		return err
+	}
+	count, err := k.nextSeq()
+	if err != nil {
+		return err
+	}
+	if drop > count-first {
+		drop = count - first
+	}
+	if drop == 0 {
+		return nil
+	}
+
+	build := k.keyDir + ".trim"
+	if err = os.RemoveAll(build); err != nil {
+		return err
+	}
+	nk := openKeyDir(k.baseDir, build, k.keyName, NewConfig())
+	nk.maxSegmentSz, nk.codec, nk.syncEnabled = k.maxSegmentSz, k.codec, k.syncEnabled
+	if _, err = nk.initalizeDirectory(); err != nil {
+		return err
+	}
+	if err = nk.setFirstSeq(first + drop); err != nil {
+		return err
+	}
+
+	start, err := k.PositionAt(first + drop)
+	if err != nil {
+		return err
+	}
+	st := &KeyStats{}
+	_, err = k.ReadFrom(start, 0, func(rec *Record) error {
+		data, err := ioutil.ReadAll(rec.Data)
+		if err != nil {
+			return err
+		}
+		if err = nk.prepareAppend(); err != nil {
+			return err
+		}
+		hash := fmt.Sprintf("%X", sha1.Sum(data))
+		var flags uint16
+		if rec.Retraction {
+			flags = blockFlagRetraction
+		}
+		if _, err = nk.writeRecord(data, hash, rec.Timestamp, flags); err != nil {
+			return err
+		}
+		if st.Count == 0 {
+			st.FirstAppend = rec.Timestamp
+		}
+		st.Count++
+		st.Bytes += uint64(len(data))
+		st.LastAppend = rec.Timestamp
+		st.LastHash = hash
+		st.Version = blockVersion
+		return nil
+	}, &ReadOptions{IncludeRetracted: true})
+	if err == nil && st.Count > 0 {
+		err = nk.writeStats(st)
+	}
+	if err == nil {
+		err = k.copySealed(nk)
+	}
+	if err == nil {
+		err = k.copyRetractions(nk)
+	}
+	if err == nil {
+		err = k.copyDedupe(nk)
+	}
+	if err != nil {
+		os.RemoveAll(build)
+		return fmt.Errorf("error copying the records to keep: %s", err)
+	}
+
+	// A crash between the renames leaves the key without a directory; finishTrim moves the new
+	// one in.
+	old := k.keyDir + ".old"
+	if err = os.RemoveAll(old); err != nil {
+		return err
+	}
+	if err = os.Rename(k.keyDir, old); err != nil {
+		return err
+	}
+	if err = os.Rename(build, k.keyDir); err != nil {
+		return err
+	}
+	k.segment, k.first = -1, -1
+	k.hashIdx = newHashIndex(k.hashIdx.fileName, k.hashLogNames)
+	return os.RemoveAll(old)
+}
+
+// finishTrim moves the new directory of a trim into place if the trim was interrupted after the
+// old directory was moved out of the way.
+func (k *Key) finishTrim() error {
+
+	if _, err := os.Stat(k.keyDir); !os.IsNotExist(err) {
+		return nil
+	}
+	for _, dir := range []string{k.keyDir + ".trim", k.keyDir + ".old"} {
+		if _, err := os.Stat(dir); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	log.Println("WARNING: finishing an interrupted trim of key:", k.keyName)
+	if err := os.Rename(k.keyDir+".trim", k.keyDir); err != nil {
+		return err
+	}
+	return os.RemoveAll(k.keyDir + ".old")
+}
+
+// retention counts how the retention policies have been enforced by this process.
+var retention = &retentionStats{}
+
+type retentionStats struct {
+	rejected, trimmed, trims int64
+}
+
+func (rs *retentionStats) String() string {
+	return fmt.Sprintf("rejected appends: %d, trimmed records: %d, trimmed keys: %d",
+		atomic.LoadInt64(&rs.rejected),
+		atomic.LoadInt64(&rs.trimmed),
+		atomic.LoadInt64(&rs.trims))
+}
@@ -0,0 +1,238 @@
+package astore
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+)
+
+// retractedFile is kept in the directory of a key that has retractions.
+const retractedFile = "retracted"
+
+// blockFlagRetraction is set in the header flags of a retraction's block.
+const blockFlagRetraction uint16 = 1 << 0
+
+// retractionEntrySize is the size of an entry in the retracted file: the sequence numbers of the
+// retraction and of the record it retracts.
+const retractionEntrySize = 16
+
+// ErrRecordNotFound is returned when a retraction names a record the key doesn't have.
+var ErrRecordNotFound = errors.New("record not found")
+
+// Retract appends a retraction of the record with the hex encoded SHA1 hash. Since records can't
+// be removed from a key, the retraction is a record of its own: {"retract":"<hash>"} with the
+// retraction flag set in its block header. The pair is also added to the key's retracted file
+// which is what the readers use to hide both of them. Retracting a record that's already been
+// retracted does nothing. ErrRecordNotFound is returned if the key doesn't have a record with the
+// hash, or it's a retraction, and ErrKeySealed if the key is sealed.
+func (k *Key) Retract(hash string) error {
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	k.appended = nil
+	hash = strings.ToUpper(hash)
+
+	if _, err := os.Stat(k.keyDir); err != nil {
+		if os.IsNotExist(err) {
+			return ErrRecordNotFound
+		}
+		return err
+	}
+	if err := k.prepareAppend(); err != nil {
+		return err
+	}
+	exists, err := k.hashExists(hash)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRecordNotFound
+	}
+	target, err := k.findHash(hash)
+	if err != nil {
+		return err
+	}
+	retractions, err := k.readRetractions()
+	if err != nil {
+		return err
+	}
+	for marker, retracted := range retractions {
+		switch target {
+		case retracted:
+			return nil
+		case marker:
+			return ErrRecordNotFound
+		}
+	}
+
+	data := []byte(fmt.Sprintf(`{"retract":"%s"}`, hash))
+	markerHash := fmt.Sprintf("%X", sha1.Sum(data))
+	ts := time.Now()
+	seq, err := k.writeRecord(data, markerHash, ts, blockFlagRetraction)
+	if err != nil {
+		return err
+	}
+	if err = k.addRetraction(seq, target); err != nil {
+		return err
+	}
+	k.appended = &appendedRecord{key: k.keyName, seq: seq, hash: markerHash, timestamp: ts}
+	k.updateStats(k.appended, int64(len(data)))
+	return nil
+}
+
+// findHash returns the sequence number of the first record in the key with hash. The hash logs
+// are read from the start so it's only used by Retract; the hash index only knows if a record is
+// there.
+func (k *Key) findHash(hash string) (uint64, error) {
+
+	last, err := k.lastSegment()
+	if err != nil {
+		return 0, err
+	}
+	for seg := 0; seg <= last; seg++ {
+		hashes, err := k.readHashLog(seg)
+		if err != nil {
+			return 0, err
+		}
+		for i, h := range hashes {
+			if h != hash {
+				continue
+			}
+			base, err := k.segmentBase(seg)
+			if err != nil {
+				return 0, err
+			}
+			return base + uint64(i), nil
+		}
+	}
+	return 0, ErrRecordNotFound
+}
+
+// readRetractions returns the retractions in the key mapped to the record each one retracts.
+// Retractions trimmed from the key are still there; they're left out by the callers.
+func (k *Key) readRetractions() (map[uint64]uint64, error) {
+
+	b, err := ioutil.ReadFile(k.retractedFileName)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a torn entry at the end is left for the next addRetraction to overwrite
+	retractions := map[uint64]uint64{}
+	for len(b) >= retractionEntrySize {
+		retractions[binary.LittleEndian.Uint64(b)] = binary.LittleEndian.Uint64(b[8:])
+		b = b[retractionEntrySize:]
+	}
+	return retractions, nil
+}
+
+func (k *Key) addRetraction(marker, target uint64) error {
+
+	file, err := os.OpenFile(k.retractedFileName, os.O_CREATE|os.O_WRONLY, defaultFilePermisions)
+	if err != nil {
+		return err
+	}
+	fi, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	entry := make([]byte, retractionEntrySize)
+	binary.LittleEndian.PutUint64(entry, marker)
+	binary.LittleEndian.PutUint64(entry[8:], target)
+	if _, err = file.WriteAt(entry, fi.Size()-fi.Size()%retractionEntrySize); err == nil && k.syncEnabled {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error writing retraction: %s", err)
+	}
+	return file.Close()
+}
+
+// retractedSeqs returns the sequence numbers of the records in the key that have been retracted.
+// Nil is returned if there aren't any.
+func (k *Key) retractedSeqs() (map[uint64]bool, error) {
+
+	retractions, err := k.readRetractions()
+	if err != nil || len(retractions) == 0 {
+		return nil, err
+	}
+	retracted := make(map[uint64]bool, len(retractions))
+	for _, target := range retractions {
+		retracted[target] = true
+	}
+	return retracted, nil
+}
+
+// hiddenSeqs returns the sequence numbers of the records default reads skip: the retracted
+// records and the retractions. Nil is returned if there aren't any.
+func (k *Key) hiddenSeqs() (map[uint64]bool, error) {
+
+	retractions, err := k.readRetractions()
+	if err != nil || len(retractions) == 0 {
+		return nil, err
+	}
+	hidden := make(map[uint64]bool, 2*len(retractions))
+	for marker, target := range retractions {
+		hidden[marker] = true
+		hidden[target] = true
+	}
+	return hidden, nil
+}
+
+// hiddenCount returns the number of records from first on that default reads skip.
+func (k *Key) hiddenCount(first uint64) (uint64, error) {
+
+	hidden, err := k.hiddenSeqs()
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for seq := range hidden {
+		if seq >= first {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// copyRetractions copies the retracted file of k to nk, the copy of k made by trim. Entries for
+// records that were trimmed don't match anything in nk so they're copied as is.
+func (k *Key) copyRetractions(nk *Key) error {
+
+	b, err := ioutil.ReadFile(k.retractedFileName)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(nk.retractedFileName, b[:len(b)-len(b)%retractionEntrySize], defaultFilePermisions)
+}
+
+// RetractRecord appends a retraction of the record at key with hash. See Key.Retract. Like
+// SealKey, in tx log mode the record has to have been committed before it can be retracted.
+func (s *store) RetractRecord(key, hash string) error {
+	k, err := OpenKeyWithConfig(s.GetKeyPath(), newSha1Key(key), s.conf)
+	if err != nil {
+		return err
+	}
+	if err = k.Retract(hash); err != nil {
+		return err
+	}
+	if k.appended != nil {
+		s.committed(k.appended)
+	}
+	return nil
+}
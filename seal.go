@@ -0,0 +1,106 @@
+package astore
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// sealedFile is kept in a sealed key's directory.
+const sealedFile = "sealed"
+
+// ErrKeySealed is returned by appends to a sealed key.
+var ErrKeySealed = errors.New("key is sealed")
+
+// Seal makes the key read-only. The time it was sealed is kept in the key's sealed file and
+// appends fail with ErrKeySealed from then on. Since nothing is appended to a sealed key its last
+// segment is made read-only and its hash index, which is only used to find duplicates, is
+// removed. Sealing a sealed key does nothing. ErrKeyNotFound is returned if the key doesn't have
+// any records.
+func (k *Key) Seal() error {
+
+	unlock, err := k.lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if _, err := os.Stat(k.keyDir); err != nil {
+		if os.IsNotExist(err) {
+			return ErrKeyNotFound
+		}
+		return err
+	}
+	sealed, err := k.sealedAt()
+	if err != nil || !sealed.IsZero() {
+		return err
+	}
+	if err = k.setSealed(time.Now()); err != nil {
+		return err
+	}
+
+	seg, err := k.lastSegment()
+	if err != nil {
+		return err
+	}
+	for _, name := range []string{k.contentSegmentName(seg), k.hashLogSegmentName(seg)} {
+		if err = os.Chmod(name, sealedFilePermissions); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error sealing segment: %s", err)
+		}
+	}
+	if err = os.Remove(k.hashIdx.fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// sealedAt returns when the key was sealed. It's zero if the key isn't sealed.
+func (k *Key) sealedAt() (time.Time, error) {
+
+	b, err := ioutil.ReadFile(k.sealedFileName)
+	switch {
+	case os.IsNotExist(err):
+		return time.Time{}, nil
+	case err != nil:
+		return time.Time{}, err
+	case len(b) != 8:
+		return time.Time{}, fmt.Errorf("bad sealed file: %s", k.sealedFileName)
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(b))), nil
+}
+
+func (k *Key) setSealed(ts time.Time) error {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, uint64(ts.UnixNano()))
+	return ioutil.WriteFile(k.sealedFileName, b, defaultFilePermisions)
+}
+
+// copySealed seals nk, the copy of k made by trim, if k is sealed.
+func (k *Key) copySealed(nk *Key) error {
+
+	sealed, err := k.sealedAt()
+	if err != nil || sealed.IsZero() {
+		return err
+	}
+	if err = nk.setSealed(sealed); err != nil {
+		return err
+	}
+	if err = os.Remove(nk.hashIdx.fileName); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SealKey seals key so it can't be appended to anymore. ErrKeyNotFound is returned if the key
+// doesn't have any records yet; in tx log mode that includes the records that haven't been
+// committed.
+func (s *store) SealKey(key string) error {
+	k, err := OpenKeyWithConfig(s.GetKeyPath(), newSha1Key(key), s.conf)
+	if err != nil {
+		return err
+	}
+	return k.Seal()
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -36,6 +37,8 @@ func (st *stats) run() {
 			if i%statsLogInterval == 0 {
 				log.Println("Write Stats:", st.cWrites)
 				log.Println("Error Stats:", st.cErrors)
+				log.Println("Compression Stats:", compression)
+				log.Println("Retention Stats:", retention)
 				i = 1
 			}
 		}
@@ -50,6 +53,36 @@ func (st *stats) countError() {
 	st.cErrors.count()
 }
 
+// compression counts the bytes written to content blocks before and after compression. Keys
+// are written from the direct writer and the tx log committers so the counts are kept for the
+// whole process.
+var compression = &compressionStats{}
+
+type compressionStats struct {
+	raw, stored int64
+}
+
+func (cs *compressionStats) add(raw, stored int64) {
+	atomic.AddInt64(&cs.raw, raw)
+	atomic.AddInt64(&cs.stored, stored)
+}
+
+// ratio returns the raw size over the stored size; 1 if nothing has been written.
+func (cs *compressionStats) ratio() float64 {
+	stored := atomic.LoadInt64(&cs.stored)
+	if stored == 0 {
+		return 1
+	}
+	return float64(atomic.LoadInt64(&cs.raw)) / float64(stored)
+}
+
+func (cs *compressionStats) String() string {
+	return fmt.Sprintf("raw: %d, stored: %d, ratio: %.2f",
+		atomic.LoadInt64(&cs.raw),
+		atomic.LoadInt64(&cs.stored),
+		cs.ratio())
+}
+
 type countType int
 
 type counter struct {
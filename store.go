@@ -1,20 +1,34 @@
 package astore
 
 import (
+	"crypto/rand"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/skyec/astore/metastore"
 )
 
 type WriteableKey interface {
 	WriteToKey(key string, data []byte) error
+	WriteStreamToKey(key string, r io.Reader, size int64) error
+	WriteToKeyWithOptions(key string, data []byte, opts *WriteOptions) (WriteStatus, error)
+	WriteStreamToKeyWithOptions(key string, r io.Reader, size int64, opts *WriteOptions) (WriteStatus, error)
 }
 
 type Store interface {
 	Initialize() error
-	Purge()
+	Purge(token string) error
+	RequestPurge() (string, error)
 	GetMeta(key []byte) []byte
 	PutMeta(key, value []byte)
 	Close() error
@@ -22,7 +36,52 @@ type Store interface {
 
 type ReadableKey interface {
 	ReadEachFromKey(key string, f ReadFunc) error
+	ReadEachFromKeyWithOptions(key string, f ReadFunc, opts *ReadOptions) error
+	ReadEachRecordFromKey(key string, f RecordFunc, opts *ReadOptions) error
+	ReadRangeFromKey(key string, from uint64, limit int, f RecordFunc, opts *ReadOptions) (Position, error)
+	ReadFromPosition(key string, c Position, limit int, f RecordFunc, opts *ReadOptions) (Position, error)
+	PositionAt(key string, index uint64) (Position, error)
+	OpenCursor(key string, start Position) (Cursor, error)
+	ReadLastFromKey(key string, n int, f RecordFunc, opts *ReadOptions) error
 	GetCountFromKey(key string) (int, error)
+	GetStatsFromKey(key string) (*KeyStats, error)
+}
+
+type WatchableKey interface {
+	Watch(key string, from uint64) (<-chan Record, CancelFunc)
+}
+
+type KeyLister interface {
+	ListKeys(prefix, after string, limit int) ([]*KeyInfo, error)
+	ListKeysWithAttributes(prefix, after string, limit int, match map[string]string) ([]*KeyInfo, error)
+	ListChildren(prefix, after string, limit int) ([]string, error)
+}
+
+type AttributableKey interface {
+	GetKeyAttributes(key string) (map[string]string, error)
+	SetKeyAttributes(key string, attrs map[string]string) error
+}
+
+type DeletableKey interface {
+	DeleteKey(key string) error
+	UndeleteKey(key string) error
+	ListDeletedKeys() ([]*DeletedKey, error)
+}
+
+type SealableKey interface {
+	SealKey(key string) error
+}
+
+type RetractableKey interface {
+	RetractRecord(key, hash string) error
+}
+
+type PrefixReader interface {
+	ReadPrefix(prefix string, limit int, f PrefixRecordFunc) error
+}
+
+type ChangeFeed interface {
+	ReadChangesSince(since uint64, limit int, f ChangeFunc) error
 }
 
 type WriteableStore interface {
@@ -32,37 +91,83 @@ type WriteableStore interface {
 
 type ReadableStore interface {
 	ReadableKey
+	WatchableKey
+	ChangeFeed
+	KeyLister
+	PrefixReader
 	Store
 }
 
 type ReadWriteableStore interface {
 	Store
 	ReadableKey
+	WatchableKey
+	ChangeFeed
+	KeyLister
+	PrefixReader
+	AttributableKey
+	DeletableKey
+	SealableKey
+	RetractableKey
 	WriteableKey
 }
 
+// appendableKey is implemented by the write paths. Implementations that hold resources, like the
+// tx log writer, also implement io.Closer.
 type appendableKey interface {
-	Append(key hashableKey, value []byte) error
+	Append(key hashableKey, value []byte, opts *AppendOptions) (WriteStatus, error)
+}
+
+// streamAppendableKey is implemented by write paths that can stream a payload to the key without
+// buffering it first.
+type streamAppendableKey interface {
+	AppendFrom(key hashableKey, r io.Reader, size int64, opts *AppendOptions) (WriteStatus, error)
+}
+
+// deferredAppendableKey is implemented by write paths that commit appends to their keys after
+// they return, like the tx log writer.
+type deferredAppendableKey interface {
+	appliedThrough() time.Time
 }
 
 // Implements the ReadWriteableStore interface
 type store struct {
 	path        string
-	sequence    int64
+	conf        *Config
+	recovered   int // number of tx log blocks replayed when the store was opened
 	kv          metastore.KVStore
 	initialized bool
 	st          *stats
 	keyWriter   appendableKey
+	watches     *watchHub
+	changes     *changeLog
+	registry    *keyRegistry
+	attrs       *keyAttributes
+	lockFile    *os.File // holds a shared lock on the store; see lockStore
+
+	stop       chan struct{}  // closed to stop the background work
+	background sync.WaitGroup // the goroutines doing the background work
 }
 
+// NewReadWriteableStore opens the store at path using the default configuration.
 func NewReadWriteableStore(path string) (ReadWriteableStore, error) {
-	s := newStore(path)
+	return NewReadWriteableStoreWithConfig(path, NewConfig())
+}
+
+// NewReadWriteableStoreWithConfig opens the store at path using conf.
+func NewReadWriteableStoreWithConfig(path string, conf *Config) (ReadWriteableStore, error) {
+	s := newStoreWithConfig(path, conf)
 	return s, s.Initialize()
 }
 
 func newStore(path string) *store {
+	return newStoreWithConfig(path, NewConfig())
+}
+
+func newStoreWithConfig(path string, conf *Config) *store {
 	return &store{
 		path: path,
+		conf: conf,
 		st:   newStats(),
 	}
 }
@@ -74,17 +179,66 @@ func (s *store) Initialize() (err error) {
 		return nil
 	}
 	if _, err = os.Stat(s.path); os.IsNotExist(err) {
-		return os.MkdirAll(s.path, os.ModeDir)
+		err = os.MkdirAll(s.path, defaultDirPermissions)
 	}
 	if err != nil {
 		return
 	}
+	if err = checkManifest(s.path); err != nil {
+		return
+	}
+	if s.lockFile, err = lockStore(s.path, syscall.LOCK_SH); err == syscall.EWOULDBLOCK {
+		return fmt.Errorf("store %s is locked, is astore-fsck running?", s.path)
+	} else if err != nil {
+		return
+	}
+	s.watches = newWatchHub(s.GetKeyPath(), s.conf)
+	if s.changes, err = openChangeLog(s.path + "/changes.log"); err != nil {
+		return
+	}
 
 	conf := metastore.NewConfig()
 	conf.Bolt.BasePath = s.path
 	s.kv, err = metastore.NewKVStore(metastore.KV_TYPE_BOLT, conf)
+	if err != nil {
+		return
+	}
+	s.attrs = &keyAttributes{kv: s.kv}
+	if s.registry, err = openKeyRegistry(s.path, s.GetKeyPath(), s.attrs, s.conf); err != nil {
+		return
+	}
+	if err = s.recoverChanges(); err != nil {
+		return fmt.Errorf("error recovering the change log: %s", err)
+	}
 
-	s.keyWriter, _ = newDirectKey(s.GetKeyPath())
+	// Replay anything a previous run left in the tx log. This also happens in direct mode so
+	// that switching write modes doesn't strand writes in the log.
+	if s.conf.WriteMode == WRITE_MODE_TXLOG || helpWritablePathExists(s.path+"/txlog") {
+		s.recovered, err = recoverTxLog(s.path, s.GetKeyPath(), s.conf, s.committed)
+		if err != nil {
+			return fmt.Errorf("error recovering the tx log: %s", err)
+		}
+		log.Printf("Recovered %d blocks from the transaction log", s.recovered)
+	}
+
+	switch s.conf.WriteMode {
+	case WRITE_MODE_TXLOG:
+		s.keyWriter, err = newKeyTxLogWriter(s.path, s.GetKeyPath(), s.conf, s.committed)
+	default:
+		s.keyWriter, err = newDirectKey(s.GetKeyPath(), s.conf, s.committed)
+	}
+	if err != nil {
+		return
+	}
+
+	s.stop = make(chan struct{})
+	for _, p := range s.conf.Retention.Policies {
+		if p.Mode == RETENTION_TRIM {
+			s.every(s.conf.Retention.Interval, "enforcing retention", s.enforceRetention)
+			break
+		}
+	}
+	s.every(s.conf.Delete.ReclaimInterval, "reclaiming deleted keys", s.reclaimDeleted)
 
 	s.st.run()
 	s.initialized = true
@@ -93,9 +247,184 @@ func (s *store) Initialize() (err error) {
 	return
 }
 
-// Purge destroys all the data in the store (if possible) and makes it unusable.
-func (s *store) Purge() {
-	os.RemoveAll(s.path)
+// committed is called by the write paths with each record once it's in its key. The record is
+// added to the change feed and the key's subscribers are woken up. A failed write to the change
+// feed is retried until it succeeds; the commit, and the writer waiting on it, stalls rather than
+// leaving the record out of the feed. Only a commit that races Close gives up; the record is
+// added by recoverChanges when the store is opened again.
+func (s *store) committed(rec *appendedRecord) {
+	wait := changeLogRetryMin
+	for {
+		_, err := s.changes.add(rec)
+		if err == nil {
+			break
+		}
+		if err == errChangeLogClosed {
+			log.Printf("WARNING: the change log was closed before record %d of key %s was added", rec.seq, rec.key)
+			return
+		}
+		log.Printf("ERROR: adding to the change log, retrying in %s: %s", wait, err)
+		time.Sleep(wait)
+		if wait *= 2; wait > changeLogRetryMax {
+			wait = changeLogRetryMax
+		}
+	}
+	s.watches.notify(rec.key)
+}
+
+// recoverChanges adds the records that a crash between their commit and changeLog.add left out of
+// the change feed. Those were appended no more than changeLogRecoveryWindow before the last change
+// in the feed, so only the records of the keys appended to since then are checked, against the
+// changes added since then. A feed without any changes has nothing to go by and isn't recovered.
+func (s *store) recoverChanges() error {
+
+	last, err := s.changes.lastEntry()
+	if err != nil || last == nil {
+		return err
+	}
+	since := time.Unix(0, last.Timestamp).Add(-changeLogRecoveryWindow)
+
+	type change struct {
+		key   [20]byte
+		index uint64
+	}
+	fed := map[change]bool{}
+	err = s.changes.readBack(since.Add(-changeLogRecoveryWindow), func(e *changeEntry) error {
+		fed[change{e.Key, e.Index}] = true
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	missing := []*appendedRecord{}
+	err = s.registry.names("", func(name string) error {
+		hk := newSha1Key(name)
+		k, err := OpenKeyWithConfig(s.GetKeyPath(), hk, s.conf)
+		if err != nil {
+			return err
+		}
+		st, err := k.Stats()
+		if err != nil {
+			return err
+		}
+		if st.Count == 0 || st.LastAppend.Before(since) {
+			return nil
+		}
+		c := change{}
+		copy(c.key[:], hk.Get())
+		for c.index = st.Trimmed + st.Count; c.index > st.Trimmed; {
+			c.index--
+			var rec *appendedRecord
+			_, err := k.ReadRange(c.index, 1, func(r *Record) error {
+				rec = &appendedRecord{key: hk, seq: r.Seq, hash: r.Hash, timestamp: r.Timestamp}
+				return nil
+			}, &ReadOptions{IncludeRetracted: true})
+			if err != nil {
+				return err
+			}
+			if rec == nil || rec.timestamp.Before(since) {
+				break
+			}
+			if !fed[c] {
+				missing = append(missing, rec)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(missing, func(i, j int) bool { return missing[i].timestamp.Before(missing[j].timestamp) })
+	for _, rec := range missing {
+		if _, err = s.changes.add(rec); err != nil {
+			return err
+		}
+	}
+	if len(missing) > 0 {
+		log.Printf("Recovered %d changes missing from the change log", len(missing))
+	}
+	return nil
+}
+
+// The token from RequestPurge is kept in purgeTokenFile in the store's directory until it expires.
+const (
+	purgeTokenFile = "purge.token"
+	purgeTokenTTL  = 10 * time.Minute
+)
+
+type purgeTokenEntry struct {
+	Token   string    `json:"token"`
+	Expires time.Time `json:"expires"`
+}
+
+// Purge destroys all the data in the store (if possible) and makes it unusable. token must be the
+// one RequestPurge returned last, and must be used before it expires; ErrInvalidPurgeToken is
+// returned otherwise.
+func (s *store) Purge(token string) error {
+	return Purge(s.path, token)
+}
+
+// purge removes the store's directory.
+func (s *store) purge() error {
+	return os.RemoveAll(s.path)
+}
+
+// RequestPurge returns a token that confirms a purge of this store. See RequestPurge.
+func (s *store) RequestPurge() (string, error) {
+	return RequestPurge(s.path)
+}
+
+// Purge destroys the store in path if token is the one RequestPurge returned last for it and
+// hasn't expired; ErrInvalidPurgeToken is returned otherwise. The store doesn't have to be opened
+// first.
+func Purge(path, token string) error {
+	data, err := ioutil.ReadFile(filepath.Join(path, purgeTokenFile))
+	if os.IsNotExist(err) {
+		return ErrInvalidPurgeToken
+	}
+	if err != nil {
+		return fmt.Errorf("error reading the purge token: %s", err)
+	}
+	entry := &purgeTokenEntry{}
+	if err = json.Unmarshal(data, entry); err != nil {
+		return fmt.Errorf("bad purge token file: %s", err)
+	}
+	if token == "" || token != entry.Token || time.Now().After(entry.Expires) {
+		return ErrInvalidPurgeToken
+	}
+	return os.RemoveAll(path)
+}
+
+// RequestPurge returns a token that confirms a purge of the store in path. It's random and is kept
+// in the store's directory until it expires after purgeTokenTTL; requesting another one replaces
+// it. Only purge.token is written so the store doesn't have to be opened, or be idle. The token
+// only makes a purge a separate, deliberate step; anyone who can call RequestPurge can purge.
+func RequestPurge(path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !fi.IsDir() {
+		return "", fmt.Errorf("not a store directory: %s", path)
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	entry := &purgeTokenEntry{
+		Token:   fmt.Sprintf("PURGE-%X", b),
+		Expires: time.Now().Add(purgeTokenTTL),
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(filepath.Join(path, purgeTokenFile), data, defaultFilePermisions); err != nil {
+		return "", fmt.Errorf("error saving the purge token: %s", err)
+	}
+	return entry.Token, nil
 }
 
 // GetRootPath returns the path where the sore files are kept
@@ -112,19 +441,279 @@ func (s *store) GetKeyPath() string {
 
 // WriteToKey appends data to the conent stored at key.
 func (s *store) WriteToKey(key string, data []byte) error {
+	_, err := s.WriteToKeyWithOptions(key, data, nil)
+	return err
+}
+
+// WriteToKeyWithOptions is WriteToKey with the options in opts. It returns whether the record was
+// appended or dropped as a duplicate by the key's DedupePolicy. In tx log mode that isn't known
+// until the record is committed so WRITE_PENDING is returned.
+func (s *store) WriteToKeyWithOptions(key string, data []byte, opts *WriteOptions) (WriteStatus, error) {
 	hk := &sha1Key{}
 	hk.Set(key)
-	err := s.keyWriter.Append(hk, data)
+	var status WriteStatus
+	err := s.checkWritable(hk)
+	if err == nil {
+		err = s.checkQuota(key, hk, int64(len(data)))
+	}
+	if err == nil {
+		status, err = s.keyWriter.Append(hk, data, s.conf.appendOptions(key, opts))
+	}
 	if err != nil {
 		s.st.countError()
-		return err
+		return status, err
 	}
 	s.st.countWrite()
+	s.register(key)
+	return status, nil
+}
+
+// WriteStreamToKey appends size bytes read from r to the content stored at key. Use a size < 0 if
+// the size isn't known. If the write path can't stream, the payload is read into memory first, up
+// to the maximum content size.
+func (s *store) WriteStreamToKey(key string, r io.Reader, size int64) error {
+	_, err := s.WriteStreamToKeyWithOptions(key, r, size, nil)
+	return err
+}
+
+// WriteStreamToKeyWithOptions is WriteStreamToKey with the options in opts. The status is the same
+// as WriteToKeyWithOptions returns.
+func (s *store) WriteStreamToKeyWithOptions(key string, r io.Reader, size int64, opts *WriteOptions) (WriteStatus, error) {
+	hk := &sha1Key{}
+	hk.Set(key)
+
+	var status WriteStatus
+	err := s.checkWritable(hk)
+	if err == nil {
+		err = s.checkQuota(key, hk, size)
+	}
+	if err == nil {
+		ao := s.conf.appendOptions(key, opts)
+		if sk, ok := s.keyWriter.(streamAppendableKey); ok {
+			status, err = sk.AppendFrom(hk, r, size, ao)
+		} else {
+			status, err = s.bufferAndAppend(hk, r, size, ao)
+		}
+	}
+	if err != nil {
+		s.st.countError()
+		return status, err
+	}
+	s.st.countWrite()
+	s.register(key)
+	return status, nil
+}
+
+// checkWritable returns ErrKeyDeleted if hk has been deleted and not reclaimed yet and
+// ErrKeySealed if it's sealed. Direct appends find out when they open the key but appends through
+// the tx log have to be turned away before they're logged.
+func (s *store) checkWritable(hk hashableKey) error {
+	if _, ok := s.keyWriter.(deferredAppendableKey); !ok {
+		return nil
+	}
+	dir := keyDirName(s.GetKeyPath(), hk)
+	if _, err := os.Stat(dir + deletedSuffix); err == nil {
+		return ErrKeyDeleted
+	}
+	if _, err := os.Stat(dir + "/" + sealedFile); err == nil {
+		return ErrKeySealed
+	}
+	return nil
+}
+
+// checkQuota returns ErrQuotaExceeded if key has a RETENTION_REJECT policy that doesn't allow
+// another size bytes. In tx log mode appends that haven't been committed yet aren't counted.
+func (s *store) checkQuota(key string, hk hashableKey, size int64) error {
+
+	p := s.conf.retentionPolicy(key)
+	if p == nil || p.Mode != RETENTION_REJECT {
+		return nil
+	}
+	k, err := OpenKeyWithConfig(s.GetKeyPath(), hk, s.conf)
+	if err != nil {
+		return err
+	}
+	st, err := k.Stats()
+	if err != nil {
+		return err
+	}
+	if !p.allows(st, size) {
+		atomic.AddInt64(&retention.rejected, 1)
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// every calls f every interval in the background until the store is closed. Errors are logged
+// with what f does.
+func (s *store) every(interval time.Duration, what string, f func(now time.Time) error) {
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+
+		tick := time.NewTicker(interval)
+		defer tick.Stop()
+		for {
+			select {
+			case now := <-tick.C:
+				if err := f(now); err != nil {
+					log.Printf("ERROR: %s: %s", what, err)
+				}
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+// enforceRetention trims the keys with a RETENTION_TRIM policy that are over their limits at now.
+// Only the keys in the key registry are trimmed.
+func (s *store) enforceRetention(now time.Time) error {
+
+	// The registry isn't scanned while the keys are trimmed so appends can register keys
+	var names []string
+	err := s.registry.names("", func(name string) error {
+		if p := s.conf.retentionPolicy(name); p != nil && p.Mode == RETENTION_TRIM {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		k, err := OpenKeyWithConfig(s.GetKeyPath(), newSha1Key(name), s.conf)
+		if err != nil {
+			return err
+		}
+		drop, err := s.conf.retentionPolicy(name).trimCount(k, now)
+		if err == nil && drop > 0 {
+			err = k.trim(drop)
+		}
+		if err != nil {
+			// the other keys are still trimmed
+			log.Println("ERROR: trimming key:", name, err)
+			continue
+		}
+		if drop > 0 {
+			atomic.AddInt64(&retention.trimmed, int64(drop))
+			atomic.AddInt64(&retention.trims, 1)
+		}
+	}
 	return nil
 }
 
+// register adds key to the key registry. The write has already succeeded so a failure is only
+// logged; the key won't be listed until it's written to again.
+func (s *store) register(key string) {
+	if err := s.registry.register(key); err != nil {
+		log.Println("ERROR: registering key:", key, err)
+	}
+}
+
+func (s *store) bufferAndAppend(hk hashableKey, r io.Reader, size int64, opts *AppendOptions) (WriteStatus, error) {
+
+	if size > MAX_CONTENT_FILE_SIZE {
+		return WRITE_APPENDED, ErrContentTooLarge
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r, MAX_CONTENT_FILE_SIZE+1))
+	switch {
+	case err != nil:
+		return WRITE_APPENDED, err
+	case len(data) > MAX_CONTENT_FILE_SIZE:
+		return WRITE_APPENDED, ErrContentTooLarge
+	case len(data) == 0:
+		return WRITE_APPENDED, ErrEmptyContent
+	case size >= 0 && int64(len(data)) != size:
+		return WRITE_APPENDED, fmt.Errorf("short content: expected: %d bytes, got: %d", size, len(data))
+	}
+	return s.keyWriter.Append(hk, data, opts)
+}
+
 // ReadEachFromKey reads the content at key and calls the callback, f, for each content block.
+// Reading stops with a *CorruptBlockError at the first block that fails validation.
 func (s *store) ReadEachFromKey(key string, f ReadFunc) error {
+	return s.ReadEachFromKeyWithOptions(key, f, nil)
+}
+
+// ReadEachFromKeyWithOptions is ReadEachFromKey with control over how corrupt blocks are handled.
+func (s *store) ReadEachFromKeyWithOptions(key string, f ReadFunc, opts *ReadOptions) error {
+
+	c, err := s.openCursor(key, Position{}, opts)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	for c.Next() {
+		if err = f(c.Reader()); err != nil {
+			return err
+		}
+	}
+	return c.Err()
+}
+
+// OpenCursor returns a Cursor that reads the records at key starting at start. The zero Position is
+// the start of the key. ErrInvalidPosition is returned if start wasn't returned for this key.
+func (s *store) OpenCursor(key string, start Position) (Cursor, error) {
+	return s.openCursor(key, start, nil)
+}
+
+func (s *store) openCursor(key string, start Position, opts *ReadOptions) (Cursor, error) {
+
+	hk := &sha1Key{}
+	hk.Set(key)
+	k, err := OpenKey(s.GetKeyPath(), hk)
+	if err != nil {
+		return nil, err
+	}
+	return k.OpenCursor(start, opts)
+}
+
+// ReadEachRecordFromKey is ReadEachFromKeyWithOptions passing the metadata stored with each record
+// to f as well as its payload.
+func (s *store) ReadEachRecordFromKey(key string, f RecordFunc, opts *ReadOptions) error {
+
+	hk := &sha1Key{}
+	hk.Set(key)
+	k, err := OpenKey(s.GetKeyPath(), hk)
+	if err != nil {
+		return err
+	}
+	return k.ReadEachRecord(f, opts)
+}
+
+// ReadRangeFromKey calls f for up to limit records at key starting with record number from. A limit
+// <= 0 reads to the end of the key. The returned position can be passed to ReadFromPosition to read the
+// records after the last one that was read.
+func (s *store) ReadRangeFromKey(key string, from uint64, limit int, f RecordFunc, opts *ReadOptions) (Position, error) {
+
+	hk := &sha1Key{}
+	hk.Set(key)
+	k, err := OpenKey(s.GetKeyPath(), hk)
+	if err != nil {
+		return Position{}, err
+	}
+	return k.ReadRange(from, limit, f, opts)
+}
+
+// ReadFromPosition calls f for up to limit records at key starting at the position c. ErrInvalidPosition
+// is returned if c wasn't returned for this key.
+func (s *store) ReadFromPosition(key string, c Position, limit int, f RecordFunc, opts *ReadOptions) (Position, error) {
+
+	hk := &sha1Key{}
+	hk.Set(key)
+	k, err := OpenKey(s.GetKeyPath(), hk)
+	if err != nil {
+		return c, err
+	}
+	return k.ReadFrom(c, limit, f, opts)
+}
+
+// ReadLastFromKey calls f for the last n records at key, newest first. A n <= 0 reads every record
+// in reverse.
+func (s *store) ReadLastFromKey(key string, n int, f RecordFunc, opts *ReadOptions) error {
 
 	hk := &sha1Key{}
 	hk.Set(key)
@@ -132,7 +721,20 @@ func (s *store) ReadEachFromKey(key string, f ReadFunc) error {
 	if err != nil {
 		return err
 	}
-	return k.ReadEach(f)
+	return k.ReadLast(n, f, opts)
+}
+
+// PositionAt returns the position of record number index of key, or at the end of the key if
+// there are no more than index records.
+func (s *store) PositionAt(key string, index uint64) (Position, error) {
+
+	hk := &sha1Key{}
+	hk.Set(key)
+	k, err := OpenKey(s.GetKeyPath(), hk)
+	if err != nil {
+		return Position{}, err
+	}
+	return k.PositionAt(index)
 }
 
 // GetCountFromKey returns the number of items saved at key.
@@ -147,6 +749,82 @@ func (s *store) GetCountFromKey(key string) (int, error) {
 	return k.Count()
 }
 
+// GetStatsFromKey returns the number of records at key, their total size, when they were appended
+// and the hash of the last one. The stats are kept up to date as records are appended.
+func (s *store) GetStatsFromKey(key string) (*KeyStats, error) {
+
+	hk := &sha1Key{}
+	hk.Set(key)
+	k, err := OpenKey(s.GetKeyPath(), hk)
+	if err != nil {
+		return nil, err
+	}
+	return k.Stats()
+}
+
+// Watch subscribes to the records at key starting with record number from. Records already in the
+// key are sent first and then new ones as they're appended. In tx log mode a record is sent once
+// it has been committed to the key. Call the CancelFunc when done with the subscription; the
+// channel is closed once it has stopped. A subscriber that doesn't keep up with its buffer is
+// dropped after Config.Watch.SendTimeout and its channel closed. Record.Data can be kept after the
+// next record is received.
+func (s *store) Watch(key string, from uint64) (<-chan Record, CancelFunc) {
+	hk := &sha1Key{}
+	hk.Set(key)
+	return s.watches.watch(hk, from)
+}
+
+// ReadChangesSince calls f for up to limit changes after the change with sequence number since, in
+// the order the records were appended to the store. Use since 0 to start at the beginning of the
+// feed and the Seq of the last change read to continue. A limit <= 0 reads to the end of the feed.
+func (s *store) ReadChangesSince(since uint64, limit int, f ChangeFunc) error {
+	return s.changes.readSince(since, limit, f)
+}
+
+// ListKeys returns up to limit keys whose names start with prefix, ordered by name, starting after
+// the key named after. Pass the name of the last key returned as after to get the next page. A
+// limit <= 0 returns them all. Only keys appended to since the key registry was added are listed.
+func (s *store) ListKeys(prefix, after string, limit int) ([]*KeyInfo, error) {
+	return s.registry.list(prefix, after, limit, nil)
+}
+
+// ListKeysWithAttributes is ListKeys for only the keys that have every attribute in match with the
+// same value.
+func (s *store) ListKeysWithAttributes(prefix, after string, limit int, match map[string]string) ([]*KeyInfo, error) {
+	return s.registry.list(prefix, after, limit, match)
+}
+
+// GetKeyAttributes returns the attributes of key. A key without any gets an empty map.
+func (s *store) GetKeyAttributes(key string) (map[string]string, error) {
+	return s.attrs.get(newSha1Key(key))
+}
+
+// SetKeyAttributes replaces the attributes of key with attrs. Attributes are kept apart from the
+// records and can be changed at any time. An empty attrs removes them all.
+func (s *store) SetKeyAttributes(key string, attrs map[string]string) error {
+	return s.attrs.set(newSha1Key(key), attrs)
+}
+
+// ListChildren returns up to limit names of the children of prefix in the key hierarchy, ordered
+// by name, starting after the child named after. Keys directly under prefix are returned by name
+// and deeper ones are rolled up into the next part of their name, ending in KEY_SEPARATOR:
+//
+//	tenant/42/orders/7, tenant/42/orders/8, tenant/42/profile
+//
+// are the children tenant/42/orders/ and tenant/42/profile of tenant/42/. A limit <= 0 returns
+// them all.
+func (s *store) ListChildren(prefix, after string, limit int) ([]string, error) {
+	return s.registry.children(prefix, after, limit)
+}
+
+// ReadPrefix calls f for up to limit records from every key whose name starts with prefix, merged
+// into one stream ordered by when the records were appended. A limit <= 0 reads them all. Each key
+// is read up to its end when ReadPrefix started and keys created after it started aren't read. A
+// prefix with more than Config.Read.MaxPrefixKeys keys returns ErrTooManyPrefixKeys.
+func (s *store) ReadPrefix(prefix string, limit int, f PrefixRecordFunc) error {
+	return readPrefix(s.GetKeyPath(), s.conf, s.registry, prefix, s.conf.Read.MaxPrefixKeys, limit, f)
+}
+
 // GetMeta returns the value contained at key from the metastore.
 // TODO: this interface needs to be able to return an error.
 func (s *store) GetMeta(key []byte) []byte {
@@ -166,8 +844,39 @@ func (s *store) PutMeta(key, value []byte) {
 	}
 }
 
-// Close closes any resources associated with the store.
+// Close closes any resources associated with the store. When writing through the tx log, Close
+// blocks until all of the pending writes have been committed to their keys.
 func (s *store) Close() error {
+	if s.lockFile != nil {
+		// the store stays locked until everything else is closed
+		defer func() {
+			s.lockFile.Close()
+			s.lockFile = nil
+		}()
+	}
+	if s.watches != nil {
+		s.watches.close()
+	}
+	if s.stop != nil {
+		close(s.stop)
+		s.background.Wait()
+		s.stop = nil
+	}
+	if c, ok := s.keyWriter.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
+	if s.registry != nil {
+		if err := s.registry.close(); err != nil {
+			return err
+		}
+	}
+	if s.changes != nil {
+		if err := s.changes.close(); err != nil {
+			return err
+		}
+	}
 	if s.kv != nil {
 		return s.kv.Close()
 	}
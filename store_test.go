@@ -2,16 +2,24 @@ package astore
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestInitializeAndPurgeInterface(t *testing.T) {
 	store := newStore("/tmp/alfs-test")
-	defer store.Purge() // Purge is idempotent
+	defer store.purge() // purge is idempotent
 	defer store.Close()
 
 	err := store.Initialize()
@@ -23,12 +31,78 @@ func TestInitializeAndPurgeInterface(t *testing.T) {
 		t.Error("Failed to find the store dir:", err)
 	}
 
-	store.Purge()
+	if err = store.Purge("PURGE-0"); err != ErrInvalidPurgeToken {
+		t.Error("Expected ErrInvalidPurgeToken, got:", err)
+	}
+	if _, err := os.Stat(store.GetRootPath()); err != nil {
+		t.Error("Purge with the wrong token removed the store dir:", err)
+	}
+
+	token, err := store.RequestPurge()
+	if err != nil {
+		t.Fatal("RequestPurge returned an error:", err)
+	}
+	if err = store.Purge("PURGE-0"); err != ErrInvalidPurgeToken {
+		t.Error("Expected ErrInvalidPurgeToken for the wrong token, got:", err)
+	}
+
+	// an expired token doesn't purge
+	data, _ := json.Marshal(&purgeTokenEntry{Token: token, Expires: time.Now().Add(-time.Second)})
+	if err = ioutil.WriteFile(filepath.Join(store.GetRootPath(), purgeTokenFile), data, defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.Purge(token); err != ErrInvalidPurgeToken {
+		t.Error("Expected ErrInvalidPurgeToken for an expired token, got:", err)
+	}
+
+	// requesting another token replaces the one before it
+	replaced := token
+	if token, err = store.RequestPurge(); err != nil {
+		t.Fatal("RequestPurge returned an error:", err)
+	}
+	if token == replaced {
+		t.Error("expected a new token from each RequestPurge")
+	}
+	if err = store.Purge(replaced); err != ErrInvalidPurgeToken {
+		t.Error("Expected ErrInvalidPurgeToken for a replaced token, got:", err)
+	}
+	if _, err := os.Stat(store.GetRootPath()); err != nil {
+		t.Error("Purge with an invalid token removed the store dir:", err)
+	}
+
+	if err = store.Purge(token); err != nil {
+		t.Error("Purge returned an error:", err)
+	}
 	if _, err := os.Stat(store.GetRootPath()); err == nil {
 		t.Error("Root dir still exsits after purge:", store.GetRootPath())
 	}
 }
 
+func TestPurgeWithoutOpening(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err = RequestPurge(dir + "/missing"); err == nil {
+		t.Error("expected an error requesting a purge of a missing store")
+	}
+	token, err := RequestPurge(dir)
+	if err != nil {
+		t.Fatal("RequestPurge returned an error:", err)
+	}
+	if err = Purge(dir, "PURGE-0"); err != ErrInvalidPurgeToken {
+		t.Error("Expected ErrInvalidPurgeToken, got:", err)
+	}
+	if err = Purge(dir, token); err != nil {
+		t.Error("Purge returned an error:", err)
+	}
+	if _, err := os.Stat(dir); err == nil {
+		t.Error("the store dir still exists after purge:", dir)
+	}
+}
+
 func TestAppend(t *testing.T) {
 	dir, err := ioutil.TempDir("", "al-store-")
 	if err != nil {
@@ -37,7 +111,7 @@ func TestAppend(t *testing.T) {
 
 	log.Printf("Temp Dir: %s", dir)
 	store := newStore(dir)
-	defer store.Purge()
+	defer store.purge()
 	defer store.Close()
 
 	err = store.Initialize()
@@ -61,7 +135,7 @@ func TestRead(t *testing.T) {
 
 	log.Printf("Temp Dir: %s", dir)
 	store := newStore(dir)
-	defer store.Purge()
+	defer store.purge()
 	defer store.Close()
 
 	err = store.Initialize()
@@ -114,7 +188,7 @@ func TestReadFunc(t *testing.T) {
 	helpInitStore(t, dir, testKey, bodies[1])
 
 	store := newStore(dir)
-	defer store.Purge()
+	defer store.purge()
 
 	next := 0
 	mustNotBeFalse := false
@@ -162,3 +236,1212 @@ func helpInitStore(t *testing.T, dir, key, payload string) {
 		t.Fatal("Error closing store:", err)
 	}
 }
+
+func TestReadTxLogStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.WriteMode = WRITE_MODE_TXLOG
+	store, err := NewReadWriteableStoreWithConfig(dir, conf)
+	if err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+
+	testKey := "the key"
+	bodies := []string{"this is a test", "this is a test 2"}
+	for _, b := range bodies {
+		if err := store.WriteToKey(testKey, []byte(b)); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	// Close waits for the committers to apply the tx log to the keys
+	if err := store.Close(); err != nil {
+		t.Fatal("Error closing store:", err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = store.ReadEachFromKey(testKey, func(r io.Reader) error {
+		_, err := io.Copy(buffer, r)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Failed to read from key '%s': %s", testKey, err)
+	}
+	if buffer.String() != strings.Join(bodies, "") {
+		t.Errorf("Buffers do not match.\nExpected:\n%s\nGot:\n%s", strings.Join(bodies, ""), buffer)
+	}
+}
+
+func TestWriteStreamToKey(t *testing.T) {
+
+	for _, mode := range []WriteMode{WRITE_MODE_DIRECT, WRITE_MODE_TXLOG} {
+		dir, err := ioutil.TempDir("", "al-store-")
+		if err != nil {
+			t.Fatal("Failed to create temporary directory:", err)
+		}
+		defer os.RemoveAll(dir)
+
+		conf := NewConfig()
+		conf.WriteMode = mode
+		store, err := NewReadWriteableStoreWithConfig(dir, conf)
+		if err != nil {
+			t.Fatal("Failed to initialize the store:", err)
+		}
+
+		testKey := "the key"
+		body := "this is a streamed test"
+		if err = store.WriteStreamToKey(testKey, strings.NewReader(body), int64(len(body))); err != nil {
+			t.Fatalf("mode %d: error saving test data: %s", mode, err)
+		}
+		if err = store.WriteStreamToKey(testKey, strings.NewReader(""), -1); err != ErrEmptyContent {
+			t.Errorf("mode %d: expected ErrEmptyContent, got: %v", mode, err)
+		}
+		if err = store.Close(); err != nil {
+			t.Fatal("Error closing store:", err)
+		}
+
+		buffer := bytes.NewBuffer(nil)
+		err = store.ReadEachFromKey(testKey, func(r io.Reader) error {
+			_, err := io.Copy(buffer, r)
+			return err
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if buffer.String() != body {
+			t.Errorf("mode %d: expected: %s, got: %s", mode, body, buffer)
+		}
+	}
+}
+
+func TestOpenCursor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	conf := NewConfig()
+	conf.Key.SegmentSize = 2 * (headerSizeV3 + 2)
+	store := newStoreWithConfig(dir, conf)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	for _, rec := range []string{"r0", "r1", "r2", "r3", "r4"} {
+		if err = store.WriteToKey("the key", []byte(rec)); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	c, err := store.OpenCursor("the key", Position{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for len(got) < 3 && c.Next() {
+		got = append(got, string(c.Record()))
+	}
+	if err = c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	pos := c.Position()
+	c.Close()
+	if strings.Join(got, ",") != "r0,r1,r2" {
+		t.Errorf("invalid records. Expected r0-r2, got: %v", got)
+	}
+
+	// resume where the first cursor stopped
+	c, err = store.OpenCursor("the key", pos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	got = []string{}
+	for c.Next() {
+		b, err := ioutil.ReadAll(c.Reader())
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	if err = c.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "r3,r4" {
+		t.Errorf("invalid records after resuming. Expected r3,r4, got: %v", got)
+	}
+	if c.Position().Seq != 5 {
+		t.Error("expected the cursor to end at record 5, got:", c.Position().Seq)
+	}
+
+	pos.Offset++
+	if _, err = store.OpenCursor("the key", pos); err != ErrInvalidPosition {
+		t.Error("expected ErrInvalidPosition, got:", err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	for _, mode := range []WriteMode{WRITE_MODE_DIRECT, WRITE_MODE_TXLOG} {
+		helpTestWatch(t, mode)
+	}
+}
+
+func helpTestWatch(t *testing.T, mode WriteMode) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	conf := NewConfig()
+	conf.WriteMode = mode
+	conf.TxLog.RotateInterval = 10 * time.Millisecond
+	store := newStoreWithConfig(dir, conf)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	for _, rec := range []string{"r0", "r1"} {
+		if err = store.WriteToKey("the key", []byte(rec)); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	records, cancel := store.Watch("the key", 1)
+	for _, rec := range []string{"r2", "r3"} {
+		if err = store.WriteToKey("the key", []byte(rec)); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	for i, expected := range []string{"r1", "r2", "r3"} {
+		select {
+		case rec := <-records:
+			b, _ := ioutil.ReadAll(rec.Data)
+			if string(b) != expected || rec.Seq != uint64(i+1) {
+				t.Errorf("mode %d: invalid record. Expected %d:%s, got: %d:%s", mode, i+1, expected, rec.Seq, b)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("mode %d: timed out waiting for %s", mode, expected)
+		}
+	}
+
+	cancel()
+	select {
+	case _, ok := <-records:
+		if ok {
+			t.Errorf("mode %d: unexpected record after cancel", mode)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("mode %d: the channel wasn't closed after cancel", mode)
+	}
+}
+
+func TestReadChangesSince(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	store := newStore(dir)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	writes := [][2]string{{"a", "r0"}, {"b", "r0"}, {"a", "r0"}, {"a", "r1"}}
+	for _, w := range writes {
+		if err = store.WriteToKey(w[0], []byte(w[1])); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	read := func(since uint64, limit int) []*Change {
+		changes := []*Change{}
+		err := store.ReadChangesSince(since, limit, func(c *Change) error {
+			changes = append(changes, c)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return changes
+	}
+
+	// the duplicate isn't a change
+	changes := read(0, 0)
+	expected := []struct {
+		key   string
+		index uint64
+		rec   string
+	}{{"a", 0, "r0"}, {"b", 0, "r0"}, {"a", 1, "r1"}}
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got: %d", len(expected), len(changes))
+	}
+	for i, e := range expected {
+		c := changes[i]
+		if c.Seq != uint64(i+1) || c.Key != newSha1Key(e.key).String() || c.Index != e.index ||
+			c.Hash != fmt.Sprintf("%X", sha1.Sum([]byte(e.rec))) || c.Timestamp.IsZero() {
+			t.Errorf("change %d doesn't match: %+v", i, c)
+		}
+	}
+
+	if changes = read(1, 1); len(changes) != 1 || changes[0].Seq != 2 {
+		t.Errorf("expected change 2 after 1, got: %v", changes)
+	}
+
+	// the sequence picks up where it left off when the store is opened again
+	store.Close()
+	store = newStore(dir)
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	defer store.Close()
+	if err = store.WriteToKey("c", []byte("r0")); err != nil {
+		t.Fatal("Error saving test data:", err)
+	}
+	if changes = read(3, 0); len(changes) != 1 || changes[0].Seq != 4 {
+		t.Errorf("expected change 4 after reopening, got: %v", changes)
+	}
+}
+
+func TestChangeLogConcurrentCommits(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	store := newStore(dir)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+
+	const writers, writes = 8, 20
+	wg := sync.WaitGroup{}
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				if err := store.WriteToKey(fmt.Sprintf("k%d", w), []byte(fmt.Sprintf("r%d", i))); err != nil {
+					t.Error("Error saving test data:", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	seq := uint64(0)
+	err = store.ReadChangesSince(0, 0, func(c *Change) error {
+		if seq++; c.Seq != seq {
+			return fmt.Errorf("expected change %d, got: %d", seq, c.Seq)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if seq != writers*writes {
+		t.Errorf("expected %d changes, got: %d", writers*writes, seq)
+	}
+}
+
+func TestChangeLogRetriesFailedAdd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	store := newStore(dir)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	if err = store.WriteToKey("a", []byte("r0")); err != nil {
+		t.Fatal("Error saving test data:", err)
+	}
+
+	// With the change log's file read only every add fails
+	ro, err := os.Open(store.changes.fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ro.Close()
+	store.changes.mu.Lock()
+	rw := store.changes.file
+	store.changes.file = ro
+	store.changes.mu.Unlock()
+	done := make(chan error)
+	go func() {
+		done <- store.WriteToKey("b", []byte("r0"))
+	}()
+	select {
+	case err = <-done:
+		t.Fatal("the write finished without adding to the change log:", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	store.changes.mu.Lock()
+	store.changes.file = rw
+	store.changes.mu.Unlock()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the change to be retried")
+	}
+
+	changes := []*Change{}
+	store.ReadChangesSince(0, 0, func(c *Change) error {
+		changes = append(changes, c)
+		return nil
+	})
+	if len(changes) != 2 || changes[1].Key != newSha1Key("b").String() {
+		t.Errorf("expected the change to b to be in the feed, got: %v", changes)
+	}
+
+	// Once the change log is closed the commit gives up
+	store.changes.close()
+	go func() {
+		done <- store.WriteToKey("c", []byte("r0"))
+	}()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the commit kept retrying after the change log was closed")
+	}
+}
+
+func TestRecoverChanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStore(dir)
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	writes := [][2]string{{"a", "r0"}, {"b", "r0"}, {"a", "r1"}, {"b", "r1"}}
+	for _, w := range writes {
+		if err = store.WriteToKey(w[0], []byte(w[1])); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+	store.Close()
+
+	// A crash after the last two records were committed but before they were in the feed
+	if err = os.Truncate(dir+"/changes.log", 2*changeEntrySize); err != nil {
+		t.Fatal(err)
+	}
+	store = newStore(dir)
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	defer store.Close()
+
+	got := []string{}
+	err = store.ReadChangesSince(0, 0, func(c *Change) error {
+		got = append(got, fmt.Sprintf("%d:%s:%d", c.Seq, c.Key[:4], c.Index))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := newSha1Key("a").String()[:4], newSha1Key("b").String()[:4]
+	expected := []string{"1:" + a + ":0", "2:" + b + ":0", "3:" + a + ":1", "4:" + b + ":1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected changes after recovery.\nExpected: %v\nGot:      %v", expected, got)
+	}
+}
+
+func TestListKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	store := newStore(dir)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	writes := [][2]string{{"user-b", "r0"}, {"user-a", "r0"}, {"order-1", "r0"}, {"user-a", "r1"}}
+	for _, w := range writes {
+		if err = store.WriteToKey(w[0], []byte(w[1])); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	names := func(keys []*KeyInfo) []string {
+		n := []string{}
+		for _, k := range keys {
+			n = append(n, k.Name)
+		}
+		return n
+	}
+
+	keys, err := store.ListKeys("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(keys), []string{"order-1", "user-a", "user-b"}) {
+		t.Fatalf("unexpected keys: %v", names(keys))
+	}
+	if keys[1].Count != 2 || keys[2].Count != 1 || keys[1].Created.IsZero() {
+		t.Errorf("unexpected key info: %+v %+v", keys[1], keys[2])
+	}
+
+	keys, err = store.ListKeys("user-", "user-a", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(keys), []string{"user-b"}) {
+		t.Errorf("unexpected keys after user-a: %v", names(keys))
+	}
+
+	keys, err = store.ListKeys("user-", "", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(names(keys), []string{"user-a"}) {
+		t.Errorf("unexpected keys with limit 1: %v", names(keys))
+	}
+}
+
+func TestHierarchicalKeys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	store := newStore(dir)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	writes := [][2]string{
+		{"tenant/42/orders/7", "o7-0"},
+		{"tenant/42/profile", "p-0"},
+		{"tenant/42/orders/8", "o8-0"},
+		{"tenant/43/profile", "p-0"},
+		{"tenant/42/orders/7", "o7-1"},
+		{"tenant/42/zone", "z-0"},
+	}
+	for _, w := range writes {
+		if err = store.WriteToKey(w[0], []byte(w[1])); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	children := func(prefix, after string, limit int) []string {
+		c, err := store.ListChildren(prefix, after, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	if c := children("tenant/42/", "", 0); !reflect.DeepEqual(c, []string{"tenant/42/orders/", "tenant/42/profile", "tenant/42/zone"}) {
+		t.Errorf("unexpected children of tenant/42/: %v", c)
+	}
+	if c := children("tenant/", "", 0); !reflect.DeepEqual(c, []string{"tenant/42/", "tenant/43/"}) {
+		t.Errorf("unexpected children of tenant/: %v", c)
+	}
+	if c := children("tenant/42/", "", 2); !reflect.DeepEqual(c, []string{"tenant/42/orders/", "tenant/42/profile"}) {
+		t.Errorf("unexpected children with limit 2: %v", c)
+	}
+	if c := children("tenant/42/", "tenant/42/orders/", 0); !reflect.DeepEqual(c, []string{"tenant/42/profile", "tenant/42/zone"}) {
+		t.Errorf("unexpected children after tenant/42/orders/: %v", c)
+	}
+
+	// the records under the prefix come back in the order they were written
+	got := []string{}
+	err = store.ReadPrefix("tenant/42/", 0, func(key string, rec *Record) error {
+		data, err := ioutil.ReadAll(rec.Data)
+		got = append(got, key+":"+string(data))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"tenant/42/orders/7:o7-0", "tenant/42/profile:p-0", "tenant/42/orders/8:o8-0", "tenant/42/orders/7:o7-1", "tenant/42/zone:z-0"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("unexpected records under tenant/42/.\nExpected: %v\nGot:      %v", expected, got)
+	}
+
+	got = got[:0]
+	err = store.ReadPrefix("tenant/42/orders/", 2, func(key string, rec *Record) error {
+		got = append(got, key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, []string{"tenant/42/orders/7", "tenant/42/orders/8"}) {
+		t.Errorf("unexpected records with limit 2: %v", got)
+	}
+
+	// a prefix with more keys than the store merges isn't read at all
+	store.conf.Read.MaxPrefixKeys = 2
+	got = got[:0]
+	err = store.ReadPrefix("tenant/42/", 0, func(key string, rec *Record) error {
+		got = append(got, key)
+		return nil
+	})
+	if err != ErrTooManyPrefixKeys || len(got) > 0 {
+		t.Errorf("expected ErrTooManyPrefixKeys before any records, got: %v, %v", err, got)
+	}
+	if err = store.ReadPrefix("tenant/42/orders/", 0, func(string, *Record) error { return nil }); err != nil {
+		t.Errorf("unexpected error reading a prefix with %d keys: %s", store.conf.Read.MaxPrefixKeys, err)
+	}
+}
+
+func TestKeyAttributes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	store := newStore(dir)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err = store.WriteToKey(key, []byte("r0")); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	attrs, err := store.GetKeyAttributes("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 0 {
+		t.Errorf("expected no attributes, got: %v", attrs)
+	}
+
+	set := map[string]map[string]string{
+		"a": {"owner": "alice", "schema": "v1"},
+		"b": {"owner": "bob", "schema": "v1"},
+		"c": {"owner": "alice", "schema": "v2"},
+	}
+	for key, attrs := range set {
+		if err = store.SetKeyAttributes(key, attrs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if attrs, err = store.GetKeyAttributes("a"); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(attrs, set["a"]) {
+		t.Errorf("unexpected attributes. Expected: %v, got: %v", set["a"], attrs)
+	}
+
+	list := func(limit int, match map[string]string) []string {
+		keys, err := store.ListKeysWithAttributes("", "", limit, match)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, k := range keys {
+			if !reflect.DeepEqual(k.Attributes, set[k.Name]) {
+				t.Errorf("unexpected attributes for %s: %v", k.Name, k.Attributes)
+			}
+			names = append(names, k.Name)
+		}
+		return names
+	}
+	if names := list(0, map[string]string{"owner": "alice"}); !reflect.DeepEqual(names, []string{"a", "c"}) {
+		t.Errorf("unexpected keys owned by alice: %v", names)
+	}
+	if names := list(0, map[string]string{"owner": "alice", "schema": "v1"}); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("unexpected keys owned by alice with schema v1: %v", names)
+	}
+	if names := list(1, map[string]string{"schema": "v1"}); !reflect.DeepEqual(names, []string{"a"}) {
+		t.Errorf("unexpected keys with schema v1 and limit 1: %v", names)
+	}
+	if names := list(0, nil); len(names) != 3 {
+		t.Errorf("expected every key without a filter, got: %v", names)
+	}
+
+	// setting replaces them
+	if err = store.SetKeyAttributes("a", nil); err != nil {
+		t.Fatal(err)
+	}
+	if attrs, err = store.GetKeyAttributes("a"); err != nil || len(attrs) != 0 {
+		t.Errorf("expected the attributes to be removed, got: %v %v", attrs, err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+
+	conf := NewConfig()
+	conf.Retention.Interval = time.Hour
+	conf.Retention.Policies = []RetentionPolicy{
+		{Key: "quota", MaxRecords: 2, Mode: RETENTION_REJECT},
+		{Prefix: "logs/", MaxRecords: 3, Mode: RETENTION_TRIM},
+		{Prefix: "logs/big/", MaxBytes: 10, Mode: RETENTION_TRIM},
+		{Prefix: "old/", MaxAge: time.Hour, Mode: RETENTION_TRIM},
+	}
+	store := newStoreWithConfig(dir, conf)
+	defer store.purge()
+	defer store.Close()
+
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err = store.WriteToKey("quota", []byte(fmt.Sprint("r", i))); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+	if err = store.WriteToKey("quota", []byte("r2")); err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded, got: %v", err)
+	}
+	if err = store.WriteStreamToKey("quota", strings.NewReader("r2"), 2); err != ErrQuotaExceeded {
+		t.Errorf("expected ErrQuotaExceeded from a stream, got: %v", err)
+	}
+
+	for _, key := range []string{"logs/a", "logs/big/b", "old/c"} {
+		for i := 0; i < 5; i++ {
+			if err = store.WriteToKey(key, []byte(fmt.Sprint("rec", i))); err != nil {
+				t.Fatal("Error saving test data:", err)
+			}
+		}
+	}
+
+	seqs := func(key string) string {
+		got := []string{}
+		err := store.ReadEachRecordFromKey(key, func(rec *Record) error {
+			got = append(got, fmt.Sprint(rec.Seq))
+			return nil
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strings.Join(got, ",")
+	}
+
+	now := time.Now()
+	if err = store.enforceRetention(now); err != nil {
+		t.Fatal("enforceRetention returned an error:", err)
+	}
+	expected := map[string]string{
+		"quota":      "0,1",
+		"logs/a":     "2,3,4",
+		"logs/big/b": "3,4",
+		"old/c":      "0,1,2,3,4",
+	}
+	for key, exp := range expected {
+		if got := seqs(key); got != exp {
+			t.Errorf("%s: expected records %s, got: %s", key, exp, got)
+		}
+	}
+
+	if err = store.enforceRetention(now.Add(2 * time.Hour)); err != nil {
+		t.Fatal("enforceRetention returned an error:", err)
+	}
+	if got := seqs("old/c"); got != "" {
+		t.Errorf("expected every record of old/c to be trimmed, got: %s", got)
+	}
+	st, err := store.GetStatsFromKey("old/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 0 || st.Trimmed != 5 {
+		t.Errorf("unexpected stats for old/c: %+v", st)
+	}
+	if err = store.WriteToKey("old/c", []byte("rec5")); err != nil {
+		t.Fatal(err)
+	}
+	if got := seqs("old/c"); got != "5" {
+		t.Errorf("expected the new record to be 5, got: %s", got)
+	}
+}
+
+func TestDeleteKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.Delete.Grace = time.Hour
+	store := newStoreWithConfig(dir, conf)
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err = store.WriteToKey(key, []byte(key+"0")); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+
+	names := func() string {
+		keys, err := store.ListKeys("", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := []string{}
+		for _, k := range keys {
+			n = append(n, fmt.Sprintf("%s:%d", k.Name, k.Count))
+		}
+		return strings.Join(n, ",")
+	}
+	created, err := store.registry.created("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.DeleteKey("missing"); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound for a missing key, got: %v", err)
+	}
+	if err = store.DeleteKey("a"); err != nil {
+		t.Fatal("DeleteKey returned an error:", err)
+	}
+	if err = store.DeleteKey("a"); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound for a deleted key, got: %v", err)
+	}
+	if n, err := store.GetCountFromKey("a"); err != nil || n != 0 {
+		t.Errorf("expected a deleted key to be empty, got: %d, %v", n, err)
+	}
+	if got := names(); got != "b:1" {
+		t.Errorf("expected only b to be listed, got: %s", got)
+	}
+	if err = store.WriteToKey("a", []byte("a1")); err != ErrKeyDeleted {
+		t.Errorf("expected ErrKeyDeleted, got: %v", err)
+	}
+	if err = store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// An append that was still in the tx log when the key was deleted isn't committed when the log
+	// is replayed
+	klog, err := openKeyTxLog(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = klog.Append(newSha1Key("a"), []byte("a2"), nil); err != nil {
+		t.Fatal(err)
+	}
+	conf.WriteMode = WRITE_MODE_TXLOG
+	store = newStoreWithConfig(dir, conf)
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to reopen the store:", err)
+	}
+	defer store.Close()
+	if store.recovered != 0 {
+		t.Errorf("expected the block to be dropped, got: %d replayed", store.recovered)
+	}
+	if n, err := store.GetCountFromKey("a"); err != nil || n != 0 {
+		t.Errorf("expected the deleted key to stay empty, got: %d, %v", n, err)
+	}
+	if err = store.WriteToKey("a", []byte("a3")); err != ErrKeyDeleted {
+		t.Errorf("expected ErrKeyDeleted through the tx log, got: %v", err)
+	}
+
+	deleted, err := store.ListDeletedKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0].Name != "a" || !deleted[0].ReclaimAfter.Equal(deleted[0].Deleted.Add(time.Hour)) {
+		t.Errorf("unexpected deleted keys: %+v", deleted)
+	}
+
+	if err = store.UndeleteKey("b"); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound undeleting a key that isn't deleted, got: %v", err)
+	}
+	if err = store.UndeleteKey("a"); err != nil {
+		t.Fatal("UndeleteKey returned an error:", err)
+	}
+	if got := names(); got != "a:1,b:1" {
+		t.Errorf("expected the undeleted key to be listed, got: %s", got)
+	}
+	if c, _ := store.registry.created("a"); !c.Equal(created) {
+		t.Errorf("expected the undeleted key to keep its creation time: %s, got: %s", created, c)
+	}
+
+	if err = store.SetKeyAttributes("a", map[string]string{"owner": "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.DeleteKey("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.reclaimDeleted(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if deleted, _ = store.ListDeletedKeys(); len(deleted) != 1 {
+		t.Errorf("expected the key to be kept for the grace period, got: %+v", deleted)
+	}
+
+	// The key is reclaimed once the tx log has been applied past its deletion
+	for i := 0; len(deleted) > 0 && i < 50; i++ {
+		time.Sleep(conf.TxLog.RotateInterval)
+		if err = store.reclaimDeleted(time.Now().Add(2 * time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if deleted, err = store.ListDeletedKeys(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected the key to be reclaimed, got: %+v", deleted)
+	}
+	if attrs, _ := store.GetKeyAttributes("a"); len(attrs) != 0 {
+		t.Errorf("expected the attributes to be removed, got: %v", attrs)
+	}
+	if err = store.UndeleteKey("a"); err != ErrKeyNotFound {
+		t.Errorf("expected ErrKeyNotFound undeleting a reclaimed key, got: %v", err)
+	}
+
+	if err = store.WriteToKey("a", []byte("a4")); err != nil {
+		t.Fatal("Error appending to a reclaimed key:", err)
+	}
+	for i := 0; i < 50; i++ {
+		if n, _ := store.GetCountFromKey("a"); n == 1 {
+			break
+		}
+		time.Sleep(conf.TxLog.RotateInterval)
+	}
+	got := []string{}
+	err = store.ReadEachRecordFromKey("a", func(rec *Record) error {
+		b, err := ioutil.ReadAll(rec.Data)
+		got = append(got, fmt.Sprintf("%d:%s", rec.Seq, b))
+		return err
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "0:a4" {
+		t.Errorf("expected a new key, got: %v", got)
+	}
+}
+
+func TestSealKey(t *testing.T) {
+
+	for _, mode := range []WriteMode{WRITE_MODE_DIRECT, WRITE_MODE_TXLOG} {
+		dir, err := ioutil.TempDir("", "al-store-")
+		if err != nil {
+			t.Fatal("Failed to create temporary directory:", err)
+		}
+		defer os.RemoveAll(dir)
+
+		conf := NewConfig()
+		conf.WriteMode = mode
+		store := newStoreWithConfig(dir, conf)
+		if err = store.Initialize(); err != nil {
+			t.Fatal("Failed to initialize the store:", err)
+		}
+		defer store.Close()
+
+		if err = store.SealKey("k"); err != ErrKeyNotFound {
+			t.Errorf("mode %d: expected ErrKeyNotFound, got: %v", mode, err)
+		}
+		for _, data := range []string{"r0", "r1"} {
+			if err = store.WriteToKey("k", []byte(data)); err != nil {
+				t.Fatal("Error saving test data:", err)
+			}
+		}
+		for i := 0; i < 50; i++ {
+			if n, _ := store.GetCountFromKey("k"); n == 2 {
+				break
+			}
+			time.Sleep(conf.TxLog.RotateInterval)
+		}
+
+		if err = store.SealKey("k"); err != nil {
+			t.Fatalf("mode %d: SealKey returned an error: %s", mode, err)
+		}
+		st, err := store.GetStatsFromKey("k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Sealed.IsZero() || st.Count != 2 {
+			t.Errorf("mode %d: unexpected stats of a sealed key: %+v", mode, st)
+		}
+		if err = store.SealKey("k"); err != nil {
+			t.Errorf("mode %d: sealing a sealed key returned an error: %s", mode, err)
+		}
+		if again, _ := store.GetStatsFromKey("k"); !again.Sealed.Equal(st.Sealed) {
+			t.Errorf("mode %d: sealing again changed when the key was sealed", mode)
+		}
+
+		if err = store.WriteToKey("k", []byte("r2")); err != ErrKeySealed {
+			t.Errorf("mode %d: expected ErrKeySealed, got: %v", mode, err)
+		}
+		if err = store.WriteStreamToKey("k", strings.NewReader("r2"), 2); err != ErrKeySealed {
+			t.Errorf("mode %d: expected ErrKeySealed from a stream, got: %v", mode, err)
+		}
+		if n, err := store.GetCountFromKey("k"); err != nil || n != 2 {
+			t.Errorf("mode %d: expected the sealed key to keep its 2 records, got: %d, %v", mode, n, err)
+		}
+
+		// A trim keeps the key sealed
+		k, err := OpenKeyWithConfig(store.GetKeyPath(), newSha1Key("k"), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = os.Stat(k.hashIdx.fileName); !os.IsNotExist(err) {
+			t.Errorf("mode %d: expected the hash index of a sealed key to be removed", mode)
+		}
+		if err = k.trim(1); err != nil {
+			t.Fatal(err)
+		}
+		if err = k.Append([]byte("r3")); err != ErrKeySealed {
+			t.Errorf("mode %d: expected ErrKeySealed after a trim, got: %v", mode, err)
+		}
+		if st, _ = store.GetStatsFromKey("k"); st.Count != 1 || st.Sealed.IsZero() {
+			t.Errorf("mode %d: unexpected stats after a trim: %+v", mode, st)
+		}
+	}
+}
+
+func TestRetractRecord(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := newStoreWithConfig(dir, NewConfig())
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	defer store.Close()
+
+	for _, data := range []string{"r0", "r1", "r2", "r3"} {
+		if err = store.WriteToKey("k", []byte(data)); err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+	}
+	hash := func(data string) string {
+		return fmt.Sprintf("%X", sha1.Sum([]byte(data)))
+	}
+	if err = store.RetractRecord("missing", hash("r1")); err != ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound for a missing key, got: %v", err)
+	}
+	if err = store.RetractRecord("k", hash("r9")); err != ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound for a missing record, got: %v", err)
+	}
+	if err = store.RetractRecord("k", strings.ToLower(hash("r1"))); err != nil {
+		t.Fatal("RetractRecord returned an error:", err)
+	}
+	if err = store.RetractRecord("k", hash("r1")); err != nil {
+		t.Error("retracting a retracted record returned an error:", err)
+	}
+	marker := fmt.Sprintf(`{"retract":"%s"}`, hash("r1"))
+	if err = store.RetractRecord("k", hash(marker)); err != ErrRecordNotFound {
+		t.Errorf("expected ErrRecordNotFound for a retraction, got: %v", err)
+	}
+
+	read := func(opts *ReadOptions) []string {
+		got := []string{}
+		err := store.ReadEachRecordFromKey("k", func(rec *Record) error {
+			data, err := ioutil.ReadAll(rec.Data)
+			if rec.Retraction {
+				data = append([]byte("-"), data...)
+			}
+			if rec.Retracted {
+				data = append([]byte("x"), data...)
+			}
+			got = append(got, string(data))
+			return err
+		}, opts)
+		if err != nil {
+			t.Fatal("Error reading the key:", err)
+		}
+		return got
+	}
+	if got := read(nil); !reflect.DeepEqual(got, []string{"r0", "r2", "r3"}) {
+		t.Errorf("unexpected records: %q", got)
+	}
+	all := []string{"r0", "xr1", "r2", "r3", "-" + marker}
+	if got := read(&ReadOptions{IncludeRetracted: true}); !reflect.DeepEqual(got, all) {
+		t.Errorf("unexpected records with the retracted ones: %q", got)
+	}
+
+	last := []string{}
+	err = store.ReadLastFromKey("k", 3, func(rec *Record) error {
+		data, err := ioutil.ReadAll(rec.Data)
+		last = append(last, string(data))
+		return err
+	}, nil)
+	if err != nil || !reflect.DeepEqual(last, []string{"r3", "r2", "r0"}) {
+		t.Errorf("unexpected last records: %q, %v", last, err)
+	}
+
+	st, err := store.GetStatsFromKey("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 5 || st.Effective != 3 {
+		t.Errorf("expected 5 records with 3 effective, got: %+v", st)
+	}
+
+	// The retraction survives a trim that keeps it, even once the record it retracts is gone
+	k, err := OpenKeyWithConfig(store.GetKeyPath(), newSha1Key("k"), NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.trim(2); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(&ReadOptions{IncludeRetracted: true}); !reflect.DeepEqual(got, all[2:]) {
+		t.Errorf("unexpected records after a trim: %q", got)
+	}
+	if st, _ = store.GetStatsFromKey("k"); st.Count != 3 || st.Effective != 2 {
+		t.Errorf("expected 3 records with 2 effective after a trim, got: %+v", st)
+	}
+
+	if err = store.SealKey("k"); err != nil {
+		t.Fatal(err)
+	}
+	if err = store.RetractRecord("k", hash("r2")); err != ErrKeySealed {
+		t.Errorf("expected ErrKeySealed, got: %v", err)
+	}
+}
+
+func TestDedupe(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.Dedupe.Window = 2
+	conf.Dedupe.Policies = []DedupePolicy{
+		{Key: "none", Mode: DEDUPE_NONE},
+		{Prefix: "win/", Mode: DEDUPE_WINDOW},
+		{Prefix: "id/", Mode: DEDUPE_ID},
+	}
+	store := newStoreWithConfig(dir, conf)
+	if err = store.Initialize(); err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+	defer store.Close()
+
+	fixtures := []struct {
+		key, data, id string
+		status        WriteStatus
+	}{
+		{"content", "a", "", WRITE_APPENDED},
+		{"content", "a", "", WRITE_DUPLICATE},
+		{"none", "a", "", WRITE_APPENDED},
+		{"none", "a", "", WRITE_APPENDED},
+		{"win/k", "a", "", WRITE_APPENDED},
+		{"win/k", "b", "", WRITE_APPENDED},
+		{"win/k", "a", "", WRITE_DUPLICATE},
+		{"win/k", "c", "", WRITE_APPENDED},
+		{"win/k", "a", "", WRITE_APPENDED}, // out of the window
+		{"id/k", "x", "1", WRITE_APPENDED},
+		{"id/k", "y", "1", WRITE_DUPLICATE},
+		{"id/k", "x", "2", WRITE_APPENDED},
+		{"id/k", "x", "", WRITE_APPENDED},
+	}
+	for i, fix := range fixtures {
+		status, err := store.WriteToKeyWithOptions(fix.key, []byte(fix.data), &WriteOptions{ID: fix.id})
+		if err != nil {
+			t.Fatalf("%d: error writing to %s: %s", i, fix.key, err)
+		}
+		if status != fix.status {
+			t.Errorf("%d: expected %s writing %s to %s, got: %s", i, fix.status, fix.data, fix.key, status)
+		}
+	}
+	for key, count := range map[string]uint64{"content": 1, "none": 2, "win/k": 4, "id/k": 3} {
+		st, err := store.GetStatsFromKey(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.Count != count {
+			t.Errorf("expected %d records in %s, got: %d", count, key, st.Count)
+		}
+	}
+
+	// The IDs are kept after the records they came with are trimmed
+	k, err := OpenKeyWithConfig(store.GetKeyPath(), newSha1Key("id/k"), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = k.trim(2); err != nil {
+		t.Fatal(err)
+	}
+	if status, err := store.WriteToKeyWithOptions("id/k", []byte("z"), &WriteOptions{ID: "1"}); err != nil || status != WRITE_DUPLICATE {
+		t.Errorf("expected a duplicate after a trim, got: %s, %v", status, err)
+	}
+}
+
+func TestDedupeTxLog(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "al-store-")
+	if err != nil {
+		t.Fatal("Failed to create temporary directory:", err)
+	}
+	defer os.RemoveAll(dir)
+
+	conf := NewConfig()
+	conf.WriteMode = WRITE_MODE_TXLOG
+	conf.Dedupe.Mode = DEDUPE_ID
+	store, err := NewReadWriteableStoreWithConfig(dir, conf)
+	if err != nil {
+		t.Fatal("Failed to initialize the store:", err)
+	}
+
+	// The ID goes through the tx log; duplicates are only dropped when it's committed
+	for _, id := range []string{"1", "2", "1"} {
+		status, err := store.WriteToKeyWithOptions("k", []byte("same"), &WriteOptions{ID: id})
+		if err != nil {
+			t.Fatal("Error saving test data:", err)
+		}
+		if status != WRITE_PENDING {
+			t.Errorf("expected %s, got: %s", WRITE_PENDING, status)
+		}
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal("Error closing store:", err)
+	}
+
+	st, err := store.GetStatsFromKey("k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.Count != 2 {
+		t.Errorf("expected 2 records, got: %d", st.Count)
+	}
+}
@@ -0,0 +1,361 @@
+package astore
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// UpgradeOptions control how Upgrade rewrites a store.
+type UpgradeOptions struct {
+	Config *Config // settings used to write the upgraded keys; NewConfig() if nil
+}
+
+// UpgradeReport summarizes a run of Upgrade.
+type UpgradeReport struct {
+	From        int    // format the store was in
+	To          int    // format the store is in now
+	Keys        int    // number of keys that were rewritten
+	Current     int    // number of keys that were already in the current format
+	TxLogBlocks int    // number of pending tx log blocks applied to the upgraded keys
+	Backup      string // directory holding the original files
+}
+
+// Upgrade rewrites the store at path in the current format (STORE_FORMAT_VERSION). It must only
+// be run while the store isn't open.
+//
+// Every key is copied record by record to a new key under <path>/upgrade and then swapped in:
+//
+//  1. the copy is built in upgrade/build and renamed to upgrade/ready/<hash> once it's complete
+//  2. the old key directory is moved to the backup directory
+//  3. the copy is moved to the key's place in the current layout
+//
+// A copy found in upgrade/ready when Upgrade starts was interrupted between those steps and is
+// swapped in first. Keys already in the current format are left alone so an interrupted upgrade
+// is finished by running it again. Pending tx logs are linked into the backup directory and then
+// applied to the upgraded keys. The manifest is only updated once everything else is done.
+//
+// The original files are kept in <path>/backup-format-<N>. Remove it once the upgraded store has
+// been checked.
+func Upgrade(path string, opts *UpgradeOptions) (*UpgradeReport, error) {
+	if opts == nil {
+		opts = &UpgradeOptions{}
+	}
+	if opts.Config == nil {
+		opts.Config = NewConfig()
+	}
+
+	m, err := readManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		// nothing to upgrade
+		m = &manifest{Format: STORE_FORMAT_VERSION}
+		return &UpgradeReport{From: m.Format, To: m.Format}, writeManifest(path, m)
+	}
+	if m.Format > STORE_FORMAT_VERSION {
+		return nil, &StoreFormatError{Path: path, Format: m.Format}
+	}
+
+	report := &UpgradeReport{From: m.Format, To: m.Format}
+	if m.Format == STORE_FORMAT_VERSION {
+		return report, nil
+	}
+	if m.Format >= MIN_STORE_FORMAT_VERSION {
+		// readable as is; only the manifest changes
+		report.To = STORE_FORMAT_VERSION
+		return report, writeManifest(path, &manifest{Format: STORE_FORMAT_VERSION})
+	}
+
+	u := &upgrader{
+		conf:    opts.Config,
+		path:    path,
+		keyPath: path + "/keys",
+		work:    path + "/upgrade",
+		backup:  fmt.Sprintf("%s/backup-format-%d", path, m.Format),
+		report:  report,
+	}
+	report.Backup = u.backup
+
+	if err = u.finishReady(); err != nil {
+		return report, err
+	}
+	if err = u.upgradeKeys(); err != nil {
+		return report, err
+	}
+	if err = u.upgradeTxLog(); err != nil {
+		return report, err
+	}
+	if err = os.RemoveAll(u.work); err != nil {
+		return report, err
+	}
+	if err = writeManifest(path, &manifest{Format: STORE_FORMAT_VERSION}); err != nil {
+		return report, err
+	}
+	report.To = STORE_FORMAT_VERSION
+	return report, nil
+}
+
+type upgrader struct {
+	conf    *Config
+	path    string
+	keyPath string
+	work    string // where new keys are built
+	backup  string
+	report  *UpgradeReport
+}
+
+// finishReady swaps in the copies left in upgrade/ready by an interrupted upgrade.
+func (u *upgrader) finishReady() error {
+
+	ready, err := filepath.Glob(u.work + "/ready/*")
+	if err != nil {
+		return err
+	}
+	for _, dir := range ready {
+		hk, ok := keyFromDirName(dir)
+		if !ok {
+			return fmt.Errorf("unexpected entry in the upgrade directory: %s", dir)
+		}
+		if err = u.swap(hk, dir, u.findKeyDirs(hk)); err != nil {
+			return err
+		}
+		u.report.Keys++
+	}
+	return nil
+}
+
+func (u *upgrader) upgradeKeys() error {
+
+	dirs, err := filepath.Glob(u.keyPath + "/*/*/*/*")
+	if err != nil {
+		return err
+	}
+	done := map[string]bool{}
+	for _, dir := range dirs {
+		if filepath.Ext(dir) == lockSuffix {
+			continue
+		}
+		hk, ok := keyFromDirName(dir)
+		if !ok {
+			log.Println("WARNING: skipping unexpected entry in the keys directory:", dir)
+			continue
+		}
+		if done[hk.String()] {
+			continue
+		}
+		done[hk.String()] = true
+
+		// A key can be in both layouts if it was written to again after the layout changed. The
+		// records in the old layout come first.
+		current := keyDirName(u.keyPath, hk)
+		sources := u.findKeyDirs(hk)
+		if len(sources) == 1 && sources[0] == current {
+			ok, err := keyIsCurrent(openKeyDir(u.keyPath, current, hk, u.conf))
+			if err != nil {
+				return err
+			}
+			if ok {
+				u.report.Current++
+				continue
+			}
+		}
+
+		ready, err := u.buildKey(hk, sources)
+		if err == nil {
+			err = u.swap(hk, ready, sources)
+		}
+		if err != nil {
+			return fmt.Errorf("error upgrading key: %s: %s", hk, err)
+		}
+		u.report.Keys++
+	}
+	return nil
+}
+
+// buildKey copies the records of the key in the source directories to a new key and moves it
+// to upgrade/ready. It returns the directory of the copy.
+func (u *upgrader) buildKey(hk hashableKey, sources []string) (string, error) {
+
+	build := u.work + "/build"
+	// a partial copy from an interrupted upgrade
+	if err := os.RemoveAll(build); err != nil {
+		return "", err
+	}
+
+	newKey, err := OpenKeyWithConfig(build, hk, u.conf)
+	if err != nil {
+		return "", err
+	}
+	if _, err = newKey.initalizeDirectory(); err != nil {
+		return "", err
+	}
+	for _, dir := range sources {
+		err = openKeyDir(u.keyPath, dir, hk, u.conf).ReadEach(func(r io.Reader) error {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			return newKey.Append(data)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	ready := u.work + "/ready/" + hk.String()
+	if err = os.MkdirAll(filepath.Dir(ready), defaultDirPermissions); err != nil {
+		return "", err
+	}
+	if err = os.Rename(newKey.keyDir, ready); err != nil {
+		return "", err
+	}
+	return ready, nil
+}
+
+// swap moves the old key directories to the backup and the new copy in ready to the key's place.
+func (u *upgrader) swap(hk hashableKey, ready string, old []string) error {
+
+	for _, dir := range old {
+		rel, err := filepath.Rel(u.keyPath, dir)
+		if err != nil {
+			return err
+		}
+		if err = renameInto(dir, u.backup+"/keys/"+rel); err != nil {
+			return fmt.Errorf("error backing up key: %s", err)
+		}
+		removeEmptyParents(dir, u.keyPath)
+	}
+	return renameInto(ready, keyDirName(u.keyPath, hk))
+}
+
+// findKeyDirs returns the directories of hk in the format 1 and the current layouts that exist.
+func (u *upgrader) findKeyDirs(hk hashableKey) []string {
+	dirs := []string{}
+	for _, dir := range []string{legacyKeyDirName(u.keyPath, hk), keyDirName(u.keyPath, hk)} {
+		if _, err := os.Stat(dir); err == nil {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// upgradeTxLog applies the pending tx logs to the upgraded keys. The logs are linked into the
+// backup directory first.
+func (u *upgrader) upgradeTxLog() error {
+
+	txlogPath := u.path + "/txlog"
+	if _, err := os.Stat(txlogPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	logs, err := filepath.Glob(txlogPath + "/reading/*.log")
+	if err != nil {
+		return err
+	}
+	logs = append(logs, txlogPath+"/writing/tx.log")
+	for _, name := range logs {
+		rel, err := filepath.Rel(u.path, name)
+		if err != nil {
+			return err
+		}
+		backup := u.backup + "/" + rel
+		if err = os.MkdirAll(filepath.Dir(backup), defaultDirPermissions); err != nil {
+			return err
+		}
+		err = os.Link(name, backup)
+		if err != nil && !os.IsNotExist(err) && !os.IsExist(err) {
+			return fmt.Errorf("error backing up tx log: %s", err)
+		}
+	}
+
+	u.report.TxLogBlocks, err = recoverTxLog(u.path, u.keyPath, u.conf, nil)
+	return err
+}
+
+// keyIsCurrent reports if every block in k has a versioned header. Those don't need to be
+// rewritten.
+func keyIsCurrent(k *Key) (bool, error) {
+
+	last, err := k.lastSegment()
+	if err != nil {
+		return false, err
+	}
+	for seg := 0; seg <= last; seg++ {
+		current, err := segmentIsCurrent(k.contentSegmentName(seg))
+		if err != nil || !current {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func segmentIsCurrent(fileName string) (bool, error) {
+
+	file, err := os.Open(fileName)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	for {
+		header, err := readBlockHeader(file)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil || header.Version < 2 {
+			// a damaged block is found again, and reported, when the key is copied
+			return false, nil
+		}
+		if _, err = file.Seek(int64(header.Length), io.SeekCurrent); err != nil {
+			return false, err
+		}
+	}
+}
+
+// keyFromDirName returns the key whose directory is dir.
+func keyFromDirName(dir string) (hashableKey, bool) {
+	hash, err := hex.DecodeString(filepath.Base(dir))
+	if err != nil || len(hash) != sha1.Size {
+		return nil, false
+	}
+	return newSha1KeyFromHash(hash), true
+}
+
+// legacyKeyDirName returns the directory of hkey in the format 1 layout. The first three levels
+// were named with a %s verb applied to a byte.
+func legacyKeyDirName(basePath string, hkey hashableKey) string {
+	return fmt.Sprintf("%s/%%!s(uint8=%d)/%%!s(uint8=%d)/%%!s(uint8=%d)/%s",
+		basePath,
+		hkey.Get()[0],
+		hkey.Get()[1],
+		hkey.Get()[2],
+		hkey,
+	)
+}
+
+// renameInto renames from to to, creating the parent directories of to.
+func renameInto(from, to string) error {
+	if err := os.MkdirAll(filepath.Dir(to), defaultDirPermissions); err != nil {
+		return err
+	}
+	return os.Rename(from, to)
+}
+
+// removeEmptyParents removes the empty directories above dir up to, but not including, stop.
+func removeEmptyParents(dir, stop string) {
+	for parent := filepath.Dir(dir); parent != stop && len(parent) > len(stop); parent = filepath.Dir(parent) {
+		if os.Remove(parent) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package astore
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestUpgrade(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+	keyPath := testDir + "/keys"
+
+	// a format 1 store with a key in the oldest layout, one in the hex layout and a pending
+	// write in the tx log
+	legacy := newSha1Key("legacy")
+	helpWriteV1Key(t, legacyKeyDirName(keyPath, legacy), "l1", "l2")
+	hexKey := newSha1Key("hex")
+	helpWriteV1Key(t, keyDirName(keyPath, hexKey), "h1")
+	if err := os.MkdirAll(testDir+"/txlog/writing", defaultDirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	block := &bytes.Buffer{}
+	header := &txLogBlockHeader{Magic: magicNumber, CRC64: crc64.Checksum([]byte("h2"), crc64.MakeTable(crc64.ISO)), Len: 2}
+	copy(header.Key[:], hexKey.Get())
+	binary.Write(block, binary.LittleEndian, header)
+	block.WriteString("h2")
+	if err := ioutil.WriteFile(testDir+"/txlog/writing/tx.log", block.Bytes(), defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReadWriteableStore(testDir); err == nil {
+		t.Fatal("expected opening a format 1 store to fail")
+	} else if _, ok := err.(*StoreFormatError); !ok {
+		t.Fatalf("expected a *StoreFormatError, got: %s", err)
+	}
+
+	// an upgrade interrupted after the legacy key was copied
+	u := &upgrader{conf: NewConfig(), path: testDir, keyPath: keyPath, work: testDir + "/upgrade"}
+	if _, err := u.buildKey(legacy, u.findKeyDirs(legacy)); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Upgrade(testDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.From != 1 || report.To != STORE_FORMAT_VERSION || report.Keys != 2 || report.TxLogBlocks != 1 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	helpValidateKeyContent(t, keyPath, "legacy", []string{"l1", "l2"})
+	helpValidateKeyContent(t, keyPath, "hex", []string{"h1", "h2"})
+	for _, k := range []hashableKey{legacy, hexKey} {
+		current, err := keyIsCurrent(openKeyDir(keyPath, keyDirName(keyPath, k), k, NewConfig()))
+		if !current || err != nil {
+			t.Errorf("expected key %s to be in the current format, got: %v, %v", k, current, err)
+		}
+	}
+	if _, err := os.Stat(legacyKeyDirName(keyPath, legacy)); !os.IsNotExist(err) {
+		t.Error("expected the legacy key directory to be removed")
+	}
+	for _, name := range []string{
+		"/keys/" + legacyKeyDirName("", legacy)[1:] + "/data/content.dat",
+		"/keys/" + keyDirName("", hexKey)[1:] + "/txlog",
+		"/txlog/writing/tx.log",
+	} {
+		if _, err := os.Stat(report.Backup + name); err != nil {
+			t.Errorf("expected a backup of: %s: %s", name, err)
+		}
+	}
+
+	s, err := NewReadWriteableStore(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*store).Close()
+
+	report, err = Upgrade(testDir, nil)
+	if err != nil || report.From != report.To {
+		t.Errorf("expected a second upgrade to do nothing, got: %+v, %v", report, err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	testDir := mkTestDir()
+	defer rmTestDir(testDir)
+
+	s, err := NewReadWriteableStore(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*store).Close()
+
+	m, err := readManifest(testDir)
+	if err != nil || m == nil || m.Format != STORE_FORMAT_VERSION {
+		t.Fatalf("expected a format %d manifest, got: %+v, %v", STORE_FORMAT_VERSION, m, err)
+	}
+
+	// an older format that can be read as is gets the current format when it's opened
+	writeManifest(testDir, &manifest{Format: MIN_STORE_FORMAT_VERSION})
+	s, err = NewReadWriteableStore(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.(*store).Close()
+	if m, _ = readManifest(testDir); m.Format != STORE_FORMAT_VERSION {
+		t.Errorf("expected the manifest to be updated to format %d, got: %d", STORE_FORMAT_VERSION, m.Format)
+	}
+
+	writeManifest(testDir, &manifest{Format: STORE_FORMAT_VERSION + 1})
+	if _, err = NewReadWriteableStore(testDir); err == nil {
+		t.Error("expected opening a store with a newer format to fail")
+	}
+}
+
+// helpWriteV1Key writes records to dir the way keys were written before block headers were
+// versioned.
+func helpWriteV1Key(t *testing.T, dir string, records ...string) {
+
+	if err := os.MkdirAll(dir+"/data", defaultDirPermissions); err != nil {
+		t.Fatal(err)
+	}
+	content := &bytes.Buffer{}
+	hashes := &bytes.Buffer{}
+	for _, rec := range records {
+		header := &contentHeader{magicNumber, crc64.Checksum([]byte(rec), crc64.MakeTable(crc64.ISO)), uint64(len(rec))}
+		binary.Write(content, binary.LittleEndian, header)
+		content.WriteString(rec)
+		fmt.Fprintf(hashes, "%X\n", sha1.Sum([]byte(rec)))
+	}
+	if err := ioutil.WriteFile(dir+"/data/content.dat", content.Bytes(), defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(dir+"/txlog", hashes.Bytes(), defaultFilePermisions); err != nil {
+		t.Fatal(err)
+	}
+}
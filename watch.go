@@ -0,0 +1,209 @@
+package astore
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// CancelFunc stops a Watch. The channel returned by Watch is closed once it has stopped.
+type CancelFunc func()
+
+var (
+	errWatchCancelled = errors.New("watch cancelled")
+	errSlowWatcher    = errors.New("subscriber too slow")
+)
+
+// watchHub tracks the Watch subscriptions of a store. The write paths call notify after
+// appending to a key and every subscriber to the key is woken up to read the new records.
+//
+// Subscribers read the records from the key rather than being handed them by the writer so a
+// slow subscriber never holds up a write and records that were duplicates, and so never
+// appended, are never seen. A notification that turns out to have nothing new is harmless.
+type watchHub struct {
+	keyPath  string
+	conf     *Config
+	mu       sync.Mutex
+	watchers map[string]map[*watcher]bool
+}
+
+func newWatchHub(keyPath string, conf *Config) *watchHub {
+	return &watchHub{
+		keyPath:  keyPath,
+		conf:     conf,
+		watchers: map[string]map[*watcher]bool{},
+	}
+}
+
+type watcher struct {
+	hk     hashableKey
+	from   uint64
+	wake   chan struct{} // buffered so notify never blocks
+	out    chan Record
+	done   chan struct{}
+	cancel sync.Once
+}
+
+// watch starts a subscription to hk. See store.Watch.
+func (h *watchHub) watch(hk hashableKey, from uint64) (<-chan Record, CancelFunc) {
+
+	w := &watcher{
+		hk:   hk,
+		from: from,
+		wake: make(chan struct{}, 1),
+		out:  make(chan Record, h.conf.Watch.Buffer),
+		done: make(chan struct{}),
+	}
+
+	// registered before the backlog is read so nothing appended in between is missed
+	h.mu.Lock()
+	if h.watchers[hk.String()] == nil {
+		h.watchers[hk.String()] = map[*watcher]bool{}
+	}
+	h.watchers[hk.String()][w] = true
+	h.mu.Unlock()
+
+	cancel := func() {
+		w.cancel.Do(func() {
+			h.mu.Lock()
+			delete(h.watchers[hk.String()], w)
+			if len(h.watchers[hk.String()]) == 0 {
+				delete(h.watchers, hk.String())
+			}
+			h.mu.Unlock()
+			close(w.done)
+		})
+	}
+
+	go func() {
+		defer close(w.out)
+		defer cancel()
+		err := h.run(w)
+		switch err {
+		case errWatchCancelled:
+		case errSlowWatcher:
+			log.Printf("WARNING: dropping a subscriber to key %s: %s", hk, err)
+		default:
+			log.Printf("ERROR: watching key %s: %s", hk, err)
+		}
+	}()
+	return w.out, cancel
+}
+
+// notify wakes up the subscribers to hk.
+func (h *watchHub) notify(hk hashableKey) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for w := range h.watchers[hk.String()] {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// close cancels every subscription.
+func (h *watchHub) close() {
+	h.mu.Lock()
+	watchers := []*watcher{}
+	for _, ws := range h.watchers {
+		for w := range ws {
+			watchers = append(watchers, w)
+		}
+	}
+	h.watchers = map[string]map[*watcher]bool{}
+	h.mu.Unlock()
+
+	for _, w := range watchers {
+		w.cancel.Do(func() { close(w.done) })
+	}
+}
+
+// run sends the records in the key to the subscriber, starting with the backlog, until the
+// subscription is cancelled or fails.
+func (h *watchHub) run(w *watcher) error {
+
+	var pos *Position
+	for {
+		// The key is opened again each time since the segment being appended to may have changed.
+		k, err := OpenKeyWithConfig(h.keyPath, w.hk, h.conf)
+		if err != nil {
+			return err
+		}
+		if pos == nil {
+			start, err := k.PositionAt(w.from)
+			if err != nil {
+				return err
+			}
+			pos = &start
+		}
+		if *pos, err = h.send(w, k, *pos); err != nil {
+			return err
+		}
+
+		select {
+		case <-w.wake:
+		case <-w.done:
+			return errWatchCancelled
+		}
+	}
+}
+
+// send sends the records in k from pos to the subscriber and returns the position after the last
+// one that was sent.
+func (h *watchHub) send(w *watcher, k *Key, pos Position) (Position, error) {
+
+	// subscribers see the retractions as they're appended
+	c, err := k.openCursor(pos, &ReadOptions{IncludeRetracted: true})
+	if err != nil {
+		return pos, err
+	}
+	defer c.Close()
+
+	for c.Next() {
+		if c.rec.Seq < w.from {
+			// the key was shorter than from when the watch started
+			continue
+		}
+		rec := Record{
+			Seq:       c.rec.Seq,
+			Timestamp: c.rec.Timestamp,
+			Hash:      c.rec.Hash,
+
+			Retraction: c.rec.Retraction,
+			Retracted:  c.rec.Retracted,
+		}
+		data := c.Record()
+		if data == nil {
+			break
+		}
+		rec.Data = bytes.NewReader(data)
+		if err = h.deliver(w, rec); err != nil {
+			return c.Position(), err
+		}
+	}
+	return c.Position(), c.Err()
+}
+
+// deliver queues rec for the subscriber. If the subscriber's buffer stays full for longer than
+// the configured send timeout it's dropped.
+func (h *watchHub) deliver(w *watcher, rec Record) error {
+
+	var timeout <-chan time.Time
+	if h.conf.Watch.SendTimeout > 0 {
+		t := time.NewTimer(h.conf.Watch.SendTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case w.out <- rec:
+		return nil
+	case <-w.done:
+		return errWatchCancelled
+	case <-timeout:
+		return errSlowWatcher
+	}
+}